@@ -8,13 +8,16 @@ import (
 	"image"
 	"image/color"
 	"image/jpeg"
-	"log"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/microsoft/ApplicationInsights-Go/appinsights"
 	"github.com/stuartleeks/pi-bell/internal/pkg/events"
+	"github.com/stuartleeks/pi-bell/internal/pkg/logging"
 	"github.com/stuartleeks/pi-bell/internal/pkg/pi"
+	"github.com/stuartleeks/pi-bell/internal/pkg/telemetry"
+	"github.com/stuartleeks/pi-bell/internal/pkg/timeutils"
 	"github.com/vladimirvivien/go4vl/device"
 	"github.com/vladimirvivien/go4vl/v4l2"
 	"gobot.io/x/gobot/drivers/gpio"
@@ -24,22 +27,93 @@ import (
 // TODO - make this configurable
 const buttonPinNumber string = pi.GPIO17
 
+var logger = logging.New("component", "bellpush")
+
+var initTime time.Time = timeutils.MustTimeParse(time.RFC3339, "1900-01-01T00:00:00Z")
+
 type ChimeInfo struct {
 	Events    chan events.Event
 	SnoozeEnd time.Time
+	// Version is the chime's software version (empty if it didn't send one)
+	Version string
+	// Features are the protocol features negotiated with the chime
+	Features []string
+}
+
+// HasFeature returns true if the protocol feature was negotiated with the chime
+func (c ChimeInfo) HasFeature(feature string) bool {
+	for _, f := range c.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSnoozed returns true if the chime has a snooze that hasn't expired
+func (c ChimeInfo) IsSnoozed() bool {
+	return c.SnoozeEnd.After(time.Now())
 }
 
+// EventListener is notified of events sent by the bellpush.
+// chimeName is empty for events that are broadcast to all chimes
+type EventListener func(chimeName string, event events.Event)
+
 type BellPush struct {
-	telemetryClient appinsights.TelemetryClient
+	telemetryClient telemetry.Client
+	doorName        string
+	chimesLock      sync.RWMutex
 	chimes          map[string]ChimeInfo
-	stopProcessing  bool
+	listeners       []EventListener
+	// restoredSnoozes holds snoozes loaded from the state file for chimes that haven't reconnected yet
+	restoredSnoozes map[string]time.Time
+	// offlineChimes records the rings missed by chimes that disconnected within the missedRingWindow (see missed.go)
+	missedRingWindow time.Duration
+	offlineChimes    map[string]*offlineChime
+
+	// ctx is cancelled by Stop to stop the background goroutines, which are tracked by workers
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+
+	gpioAdaptor *raspi.Adaptor
+	button      *gpio.ButtonDriver
+
+	// buttonsLock guards the raw button inputs (see button.go) and the buttons that are held
+	// (by event source) for detecting stuck buttons (see stuck.go)
+	buttonsLock        sync.Mutex
+	buttonConfig       ButtonConfig
+	buttonInputs       map[string]*buttonInput
+	heldButtons        map[string]*heldButton
+	stuckButtonTimeout time.Duration
+
+	// leaderLock guards leader and steppedDown. The bellpush is the leader unless it is the standby in a failover pair
+	leaderLock  sync.RWMutex
+	leader      bool
+	steppedDown chan struct{}
+
+	webcamFrameLock sync.RWMutex
 	webcamFrame     []byte
+	webcamFrameTime time.Time
 }
 
-func NewBellPush(telemetryClient appinsights.TelemetryClient) *BellPush {
+func NewBellPush(telemetryClient telemetry.Client, doorName string) *BellPush {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &BellPush{
-		telemetryClient: telemetryClient,
-		chimes:          make(map[string]ChimeInfo),
+		telemetryClient:    telemetryClient,
+		doorName:           doorName,
+		chimes:             make(map[string]ChimeInfo),
+		restoredSnoozes:    make(map[string]time.Time),
+		missedRingWindow:   DefaultMissedRingWindow,
+		offlineChimes:      make(map[string]*offlineChime),
+		buttonConfig:       DefaultButtonConfig(),
+		buttonInputs:       make(map[string]*buttonInput),
+		heldButtons:        make(map[string]*heldButton),
+		stuckButtonTimeout: DefaultStuckButtonTimeout,
+		ctx:                ctx,
+		cancel:             cancel,
+		leader:             true,
+		steppedDown:        make(chan struct{}),
 	}
 }
 
@@ -47,70 +121,53 @@ func NewBellPush(telemetryClient appinsights.TelemetryClient) *BellPush {
 func (b *BellPush) StartGpio() error {
 
 	raspberryPi := raspi.NewAdaptor()
-	defer raspberryPi.Finalize() // nolint:errcheck
-
 	button := gpio.NewButtonDriver(raspberryPi, buttonPinNumber)
 	err := button.On(gpio.ButtonPush, func(s interface{}) {
-		err := b.BroadcastEvent(events.NewButtonEvent(events.ButtonPressed, "bellpush"))
-		if err != nil {
-			log.Printf("Error broadcasting button pressed event: %v\n", err)
-			b.telemetryClient.TrackException(err)
-			b.telemetryClient.Channel().Flush()
-		}
+		b.ButtonChanged("bellpush", true)
 	})
 	if err != nil {
 		b.telemetryClient.TrackException(err)
-		b.telemetryClient.Channel().Flush()
 		return fmt.Errorf("error setting up button push handler: %w", err)
 	}
 	err = button.On(gpio.ButtonRelease, func(s interface{}) {
-		err2 := b.BroadcastEvent(events.NewButtonEvent(events.ButtonReleased, "bellpush"))
-		if err2 != nil {
-			log.Printf("Error broadcasting button released event: %v\n", err)
-			b.telemetryClient.TrackException(err)
-			b.telemetryClient.Channel().Flush()
-		}
+		b.ButtonChanged("bellpush", false)
 	})
 	if err != nil {
 		b.telemetryClient.TrackException(err)
-		b.telemetryClient.Channel().Flush()
 		return fmt.Errorf("error setting up button release handler: %w", err)
 	}
 
 	err = button.Start()
 	if err != nil {
 		b.telemetryClient.TrackException(err)
-		b.telemetryClient.Channel().Flush()
 		return fmt.Errorf("error starting button driver: %w", err)
 	}
+	// keep the adaptor and driver so that Stop can release the pins
+	b.gpioAdaptor = raspberryPi
+	b.button = button
 	return nil
 }
 func (b *BellPush) StartStdioReader() {
 	go func() {
 		// read from stdin
 		consoleReader := bufio.NewReaderSize(os.Stdin, 1)
-		log.Printf("Starting stdio loop\n")
-		for !b.stopProcessing {
+		logger.Info("Starting stdio loop")
+		for b.ctx.Err() == nil {
 			input, err := consoleReader.ReadByte()
 			if err != nil {
 				continue
 			}
 			char := string(input)
-			log.Printf("Read char: %s\n", char)
+			logger.Debug("Read char", "char", char)
 			switch char {
+			// simulate the GPIO transitions (with debouncing and press classification)
 			case "b": // bell push
-				err := b.BroadcastEvent(events.NewButtonEvent(events.ButtonPressed, "keyboard"))
-				if err != nil {
-					log.Printf("Error broadcasting button pressed event: %v\n", err)
-				}
+				b.ButtonChanged("keyboard", true)
 			case "r": // bell release
-				err := b.BroadcastEvent(events.NewButtonEvent(events.ButtonReleased, "keyboard"))
-				if err != nil {
-					log.Printf("Error broadcasting button released event: %v\n", err)
-				}
+				b.ButtonChanged("keyboard", false)
 			}
 		}
-		log.Printf("Exiting stdio loop\n")
+		logger.Info("Exiting stdio loop")
 	}()
 }
 
@@ -123,33 +180,42 @@ func (b *BellPush) StartCameraCapture() error {
 		device.WithFPS(1),
 	)
 	if err != nil {
-		log.Fatalf("failed to open device: %s", err)
 		return fmt.Errorf("failed to open device: %w", err)
 	}
 
 	// start stream
-	ctx, stop := context.WithCancel(context.TODO())
+	ctx, stop := context.WithCancel(b.ctx)
 	if err := device.Start(ctx); err != nil {
-		log.Fatalf("failed to start stream: %s", err)
+		stop()
 		return fmt.Errorf("failed to start stream: %w", err)
 	}
 
+	b.workers.Add(1)
 	go func() {
-		for frame := range device.GetOutput() {
-			b.webcamFrame = frame
-			if b.stopProcessing {
-				break
+		defer b.workers.Done()
+		defer device.Close()
+		defer stop()
+		for {
+			select {
+			case <-ctx.Done():
+				logger.Info("Stopping camera capture")
+				return
+			case frame, ok := <-device.GetOutput():
+				if !ok {
+					logger.Warn("Camera stream closed")
+					return
+				}
+				b.setWebcamFrame(frame)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(1 * time.Second):
 			}
-			time.Sleep(1 * time.Second)
 		}
-
-		stop()
-		device.Close()
 	}()
 	return nil
 }
 func (b *BellPush) StartFakeCameraCapture() error {
-	var buf bytes.Buffer
 	colors := []color.Color{
 		color.RGBA{100, 200, 200, 0xff},
 		color.RGBA{200, 100, 200, 0xff},
@@ -160,14 +226,17 @@ func (b *BellPush) StartFakeCameraCapture() error {
 	}
 	colorIndex := 0
 
+	b.workers.Add(1)
 	go func() {
-		for !b.stopProcessing {
+		defer b.workers.Done()
+		for b.ctx.Err() == nil {
 
 			colorIndex++
 			if colorIndex >= len(colors) {
 				colorIndex = 0
 			}
-			buf.Reset()
+			// use a new buffer for each frame as the previous frame may still be in use
+			var buf bytes.Buffer
 			curentColor := colors[colorIndex]
 
 			width := 640
@@ -193,81 +262,407 @@ func (b *BellPush) StartFakeCameraCapture() error {
 			}
 			err := jpeg.Encode(&buf, img, nil)
 			if err != nil {
-				log.Printf("Error encoding fake frame: %v\n", err)
+				logger.Error("Error encoding fake frame", "err", err)
 				continue
 			}
-			b.webcamFrame = buf.Bytes()
-			time.Sleep(1 * time.Second)
+			b.setWebcamFrame(buf.Bytes())
+			select {
+			case <-b.ctx.Done():
+			case <-time.After(1 * time.Second):
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops the GPIO button handler and camera capture. It waits for the camera capture to finish
+// (so that the device is closed) until ctx is done
+func (b *BellPush) Stop(ctx context.Context) error {
+	b.cancel()
+	b.stopButtonInputTimers()
+	b.stopButtonTimers()
+
+	var errs []string
+	if b.button != nil {
+		if err := b.button.Halt(); err != nil {
+			errs = append(errs, fmt.Sprintf("failed to stop button driver: %v", err))
+		}
+	}
+	if b.gpioAdaptor != nil {
+		if err := b.gpioAdaptor.Finalize(); err != nil {
+			errs = append(errs, fmt.Sprintf("failed to release GPIO: %v", err))
 		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		b.workers.Wait()
+		close(done)
 	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, "timed out waiting for camera capture to stop")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("error stopping bellpush: %s", strings.Join(errs, "; "))
+	}
 	return nil
 }
 
-func (b *BellPush) Stop() {
-	b.stopProcessing = true
+func (b *BellPush) setWebcamFrame(frame []byte) {
+	b.webcamFrameLock.Lock()
+	defer b.webcamFrameLock.Unlock()
+	b.webcamFrame = frame
+	b.webcamFrameTime = time.Now()
+	cameraFramesCounter.Inc()
+}
+
+// GetDoorName returns the name of the door that the bellpush is for
+func (b *BellPush) GetDoorName() string {
+	return b.doorName
 }
 
 func (b *BellPush) GetWebcamFrame() []byte {
+	b.webcamFrameLock.RLock()
+	defer b.webcamFrameLock.RUnlock()
 	return b.webcamFrame
 }
 
+// GetWebcamFrameTime returns the time that the latest webcam frame was captured
+func (b *BellPush) GetWebcamFrameTime() time.Time {
+	b.webcamFrameLock.RLock()
+	defer b.webcamFrameLock.RUnlock()
+	return b.webcamFrameTime
+}
+
 func (b *BellPush) GetChimes() map[string]ChimeInfo {
-	return b.chimes
+	b.chimesLock.RLock()
+	defer b.chimesLock.RUnlock()
+	chimes := make(map[string]ChimeInfo, len(b.chimes))
+	for name, chime := range b.chimes {
+		chimes[name] = chime
+	}
+	return chimes
 }
 func (b *BellPush) GetChime(name string) (ChimeInfo, bool) {
+	b.chimesLock.RLock()
+	defer b.chimesLock.RUnlock()
 	chime, ok := b.chimes[name]
 	return chime, ok
 }
+
+// SetChime adds or updates a chime. If the chime is new (or has a new events channel)
+// then listeners are notified that the chime has connected. A chime that is reconnecting is sent
+// the rings that it missed while it was disconnected
 func (b *BellPush) SetChime(name string, chime ChimeInfo) {
+	b.chimesLock.Lock()
+	existing, ok := b.chimes[name]
 	b.chimes[name] = chime
+	b.chimesLock.Unlock()
+
+	if !ok || existing.Events != chime.Events {
+		b.notifyListeners("", events.NewChimeStatusEvent(name, true))
+	}
+	if !ok {
+		if missedRings := b.takeMissedRings(name); missedRings != nil {
+			if !chime.HasFeature(events.FeatureMissedRings) {
+				logger.WithCorrelationID(missedRings.ID).Info("Chime reconnected but doesn't support missed rings - discarding", "chime", name, "count", missedRings.Count)
+				return
+			}
+			logger.WithCorrelationID(missedRings.ID).Info("Chime reconnected - sending missed rings", "chime", name, "count", missedRings.Count, "disconnectedAt", missedRings.DisconnectedAt)
+			if err := b.SendEvent(name, missedRings); err != nil {
+				logger.WithCorrelationID(missedRings.ID).Error("Error sending missed rings", "chime", name, "err", err)
+			}
+		}
+	}
 }
+
+// RemoveChime removes a chime that has disconnected and starts recording the rings that it misses
 func (b *BellPush) RemoveChime(name string) {
+	b.removeChime(name, nil)
+}
+
+// RemoveChimeIfChannel removes a chime that has disconnected, as for RemoveChime, but only if it is still
+// registered with the events channel for the connection. If the chime has already reconnected then it is
+// registered with the new connection's channel and is left alone. Returns true if the chime was removed
+func (b *BellPush) RemoveChimeIfChannel(name string, eventsChannel chan events.Event) bool {
+	return b.removeChime(name, eventsChannel)
+}
+
+func (b *BellPush) removeChime(name string, eventsChannel chan events.Event) bool {
+	b.chimesLock.Lock()
+	chime, ok := b.chimes[name]
+	if ok && eventsChannel != nil && chime.Events != eventsChannel {
+		ok = false
+	}
+	if ok {
+		delete(b.chimes, name)
+		b.chimeDisconnected(name)
+	}
+	b.chimesLock.Unlock()
+
+	if ok {
+		b.notifyListeners("", events.NewChimeStatusEvent(name, false))
+	}
+	return ok
+}
+
+// HandOverChime removes a chime that is moving to the other bellpush in a failover pair. Its snooze is kept
+// (as if restored from the state file) so that it is still published in the failover heartbeats
+func (b *BellPush) HandOverChime(name string) {
+	b.chimesLock.Lock()
+	chime, ok := b.chimes[name]
 	delete(b.chimes, name)
+	if chime.IsSnoozed() {
+		b.restoredSnoozes[name] = chime.SnoozeEnd
+	}
+	b.chimesLock.Unlock()
+
+	if ok {
+		b.notifyListeners("", events.NewChimeStatusEvent(name, false))
+	}
+}
+
+// SnoozeChime snoozes the named chime for the specified duration and notifies the chime
+func (b *BellPush) SnoozeChime(name string, duration time.Duration) error {
+	b.chimesLock.Lock()
+	chime, ok := b.chimes[name]
+	if !ok {
+		b.chimesLock.Unlock()
+		return fmt.Errorf("unknown chime: %q", name)
+	}
+	chime.SnoozeEnd = time.Now().Add(duration)
+	b.chimes[name] = chime
+	b.chimesLock.Unlock()
+
+	return b.SendEvent(name, events.NewSnoozeEvent(chime.SnoozeEnd))
+}
+
+// UnSnoozeChime cancels any snooze for the named chime and notifies the chime
+func (b *BellPush) UnSnoozeChime(name string) error {
+	b.chimesLock.Lock()
+	chime, ok := b.chimes[name]
+	if !ok {
+		b.chimesLock.Unlock()
+		return fmt.Errorf("unknown chime: %q", name)
+	}
+	chime.SnoozeEnd = initTime
+	b.chimes[name] = chime
+	b.chimesLock.Unlock()
+
+	return b.SendEvent(name, events.NewUnSnoozeEvent())
+}
+
+// HandleSnoozeRequest applies a snooze request sent by the named chime (e.g. from a button on the chime),
+// snoozing or unsnoozing the chime and notifying it
+func (b *BellPush) HandleSnoozeRequest(name string, request *events.SnoozeRequest) error {
+	requestLogger := logger.WithCorrelationID(request.ID).With("chime", name)
+	if request.IsCancel() {
+		requestLogger.Info("Chime requested unsnooze")
+		snoozeRequestsCounter.WithLabelValues(name, "cancel").Inc()
+		return b.UnSnoozeChime(name)
+	}
+	requestLogger.Info("Chime requested snooze", "duration", request.Duration)
+	snoozeRequestsCounter.WithLabelValues(name, "snooze").Inc()
+	return b.SnoozeChime(name, request.Duration)
+}
+
+// AddEventListener registers a listener that is called for each event sent by the bellpush.
+// Listeners are called synchronously so should not block
+func (b *BellPush) AddEventListener(listener EventListener) {
+	b.chimesLock.Lock()
+	defer b.chimesLock.Unlock()
+	b.listeners = append(b.listeners, listener)
+}
+
+// NotifySnoozeRestored tells the event listeners (e.g. the home page) about a snooze that was applied to a chime
+// without sending the chime an event, e.g. one restored from a retained MQTT message
+func (b *BellPush) NotifySnoozeRestored(chimeName string, snoozeEnd time.Time) {
+	var event events.Event = events.NewUnSnoozeEvent()
+	if snoozeEnd.After(time.Now()) {
+		event = events.NewSnoozeEvent(snoozeEnd)
+	}
+	b.notifyListeners(chimeName, event)
+}
+
+func (b *BellPush) notifyListeners(chimeName string, event events.Event) {
+	b.chimesLock.RLock()
+	listeners := b.listeners
+	b.chimesLock.RUnlock()
+	for _, listener := range listeners {
+		listener(chimeName, event)
+	}
+}
+
+// IsLeader returns false while the bellpush is the standby in a failover pair. Only the leader
+// broadcasts button events and accepts chime connections
+func (b *BellPush) IsLeader() bool {
+	b.leaderLock.RLock()
+	defer b.leaderLock.RUnlock()
+	return b.leader
+}
+
+// SetLeader sets whether the bellpush is the leader. When it stops being the leader, the channel
+// returned by SteppedDown is closed so that the chime connections can be handed over to the new leader
+func (b *BellPush) SetLeader(leader bool) {
+	b.leaderLock.Lock()
+	defer b.leaderLock.Unlock()
+	if leader == b.leader {
+		return
+	}
+	b.leader = leader
+	if leader {
+		b.steppedDown = make(chan struct{})
+	} else {
+		close(b.steppedDown)
+	}
+}
+
+// SteppedDown returns a channel that is closed when the bellpush stops being the leader
+// (which is already closed if the bellpush is the standby)
+func (b *BellPush) SteppedDown() <-chan struct{} {
+	b.leaderLock.RLock()
+	defer b.leaderLock.RUnlock()
+	return b.steppedDown
 }
 
 func (b *BellPush) BroadcastEvent(event events.Event) error {
-	jsonValue, err := event.ToJSON()
-	log.Printf("Event: %s (err: %s)\n", jsonValue, err)
+	if buttonEvent, ok := event.(*events.ButtonEvent); ok {
+		if !b.IsLeader() {
+			// the leader is wired to the same button and broadcasts the event
+			logger.WithCorrelationID(buttonEvent.ID).Info("Standby - not broadcasting button event", "type", events.TypeToString(buttonEvent.ButtonEventType))
+			return nil
+		}
+		if buttonEvent.Door == "" {
+			buttonEvent.Door = b.doorName
+		}
+		if !b.trackButton(buttonEvent) {
+			return nil
+		}
+		buttonEvent.AddHop(events.HopBroadcast, time.Now())
+		switch buttonEvent.ButtonEventType {
+		case events.ButtonPressed:
+			ringsCounter.WithLabelValues(buttonEvent.Door, buttonEvent.Source).Inc()
+			b.recordMissedRing(buttonEvent)
+		case events.ButtonShortPress, events.ButtonLongPress, events.ButtonDoublePress:
+			buttonPressesCounter.WithLabelValues(buttonEvent.Door, events.TypeToString(buttonEvent.ButtonEventType)).Inc()
+		}
+	}
+
+	eventLogger := logger.WithCorrelationID(event.GetID())
+	jsonValue, err := events.ToJSON(event)
 	if err != nil {
+		eventLogger.Error("Error converting event to JSON", "eventType", event.GetType(), "err", err)
 		return err
 	}
+	eventLogger.Info("Broadcasting event", "eventType", event.GetType(), "event", jsonValue)
+
+	b.telemetryClient.TrackEvent(event.GetType(), event.GetProperties())
 
-	if b.telemetryClient != nil {
-		eventTelemetry := appinsights.NewEventTelemetry(event.GetType())
-		for name, value := range event.GetProperties() {
-			eventTelemetry.Properties[name] = value
+	for name, client := range b.GetChimes() {
+		if chimeEvent := eventForChime(client, event); chimeEvent != nil {
+			b.queueEvent(name, client, chimeEvent)
 		}
-		b.telemetryClient.Track(eventTelemetry)
-		b.telemetryClient.Channel().Flush()
 	}
+	b.notifyListeners("", event)
+	return nil
+}
 
-	for _, client := range b.chimes {
-		client.Events <- event
+// eventForChime returns the event to send to chime for a broadcast event, or nil if the chime shouldn't get it.
+// Chimes that didn't negotiate FeatureButtonEvents get a released event in place of a stuck event (so that they
+// turn their relay off) and don't get the press classification events
+func eventForChime(chime ChimeInfo, event events.Event) events.Event {
+	buttonEvent, ok := event.(*events.ButtonEvent)
+	if !ok || chime.HasFeature(events.FeatureButtonEvents) {
+		return event
 	}
-	return nil
+	switch buttonEvent.ButtonEventType {
+	case events.ButtonStuck:
+		released := events.NewButtonEvent(events.ButtonReleased, buttonEvent.Source)
+		released.Door = buttonEvent.Door
+		released.Time = buttonEvent.Time
+		released.Hops = append([]events.Hop(nil), buttonEvent.Hops...)
+		return released
+	case events.ButtonShortPress, events.ButtonLongPress, events.ButtonDoublePress:
+		return nil
+	}
+	return event
 }
 func (b *BellPush) SendEvent(chimeName string, event events.Event) error {
-	jsonValue, err := event.ToJSON()
-	log.Printf("Event: %s (err: %s)\n", jsonValue, err)
+	eventLogger := logger.WithCorrelationID(event.GetID()).With("chime", chimeName)
+	jsonValue, err := events.ToJSON(event)
 	if err != nil {
+		eventLogger.Error("Error converting event to JSON", "eventType", event.GetType(), "err", err)
 		return err
 	}
-	chime, ok := b.chimes[chimeName]
+	eventLogger.Info("Sending event", "eventType", event.GetType(), "event", jsonValue)
+	chime, ok := b.GetChime(chimeName)
 	if !ok {
-		log.Printf("Unknown chime: %q\n", chimeName)
+		eventLogger.Warn("Unknown chime")
 		return fmt.Errorf("unknown chime: %q", chimeName)
 	}
 
-	if b.telemetryClient != nil {
-		eventTelemetry := appinsights.NewEventTelemetry(event.GetType())
-		for name, value := range event.GetProperties() {
-			eventTelemetry.Properties[name] = value
+	properties := event.GetProperties()
+	properties["chimeName"] = chimeName
+	b.telemetryClient.TrackEvent(event.GetType(), properties)
+
+	b.queueEvent(chimeName, chime, event)
+	b.notifyListeners(chimeName, event)
+	return nil
+}
+
+// StopChimeEvents tells the loop that sends the events queued on eventsChannel (e.g. for a chime's previous
+// connection) to stop. It doesn't block, so a connection that has stopped sending can't hold up the caller
+func (b *BellPush) StopChimeEvents(chimeName string, eventsChannel chan events.Event) {
+	b.queueEvent(chimeName, ChimeInfo{Events: eventsChannel}, events.NewStopProcessingEvent())
+}
+
+// queueEvent adds an event to a chime's queue. If the queue is full (e.g. the chime has stopped responding)
+// then the event is dropped so that one chime can't hold up the others. Button released and stuck events
+// turn the chime's relay off and stop processing events end the connection's loop, so the oldest queued events
+// are dropped to make room for them instead
+func (b *BellPush) queueEvent(chimeName string, chime ChimeInfo, event events.Event) {
+	for {
+		select {
+		case chime.Events <- event:
+			return
+		default:
+		}
+		if !mustDeliver(event) {
+			logger.WithCorrelationID(event.GetID()).Warn("Queue full for chime - dropping event", "chime", chimeName, "eventType", event.GetType())
+			droppedEventsCounter.WithLabelValues(chimeName, event.GetType()).Inc()
+			return
+		}
+		select {
+		case oldest := <-chime.Events:
+			if oldest.GetType() == events.EventTypeStopProcessing {
+				// the connection is being replaced, so the event wouldn't be sent anyway
+				select {
+				case chime.Events <- oldest:
+				default:
+				}
+				logger.WithCorrelationID(event.GetID()).Warn("Queue full for stopping chime connection - dropping event", "chime", chimeName, "eventType", event.GetType())
+				droppedEventsCounter.WithLabelValues(chimeName, event.GetType()).Inc()
+				return
+			}
+			logger.WithCorrelationID(oldest.GetID()).Warn("Queue full for chime - dropping oldest event", "chime", chimeName, "eventType", oldest.GetType())
+			droppedEventsCounter.WithLabelValues(chimeName, oldest.GetType()).Inc()
+		default:
+			// the connection took an event from the queue, so there is now room
 		}
-		eventTelemetry.Properties["chimeName"] = chimeName
-		b.telemetryClient.Track(eventTelemetry)
-		b.telemetryClient.Channel().Flush()
 	}
+}
 
-	chime.Events <- event
-	return nil
+// mustDeliver returns true for events that shouldn't be dropped when a chime's queue is full
+func mustDeliver(event events.Event) bool {
+	if event.GetType() == events.EventTypeStopProcessing {
+		return true
+	}
+	buttonEvent, ok := event.(*events.ButtonEvent)
+	if !ok {
+		return false
+	}
+	return buttonEvent.ButtonEventType == events.ButtonReleased || buttonEvent.ButtonEventType == events.ButtonStuck
 }
@@ -0,0 +1,238 @@
+package bellpush
+
+import (
+	"time"
+
+	"github.com/stuartleeks/pi-bell/internal/pkg/configfile"
+	"github.com/stuartleeks/pi-bell/internal/pkg/events"
+)
+
+// ButtonConfig holds the settings for turning the raw button transitions into button events
+type ButtonConfig struct {
+	// Debounce is how long the button must stay in a new state before the change is accepted
+	Debounce time.Duration `yaml:"debounce" env:"BUTTON_DEBOUNCE"`
+	// MinPress is the shortest press that rings the bell. Shorter presses are rejected as glitches
+	MinPress time.Duration `yaml:"minPress" env:"BUTTON_MIN_PRESS"`
+	// LongPress is how long the button must be held for a long press
+	LongPress time.Duration `yaml:"longPress" env:"BUTTON_LONG_PRESS"`
+	// DoublePressWindow is the longest gap between a short press and the next press for them to be a double press
+	// (0 to disable double presses)
+	DoublePressWindow time.Duration `yaml:"doublePressWindow" env:"BUTTON_DOUBLE_PRESS_WINDOW"`
+}
+
+// DefaultButtonConfig returns the default button settings
+func DefaultButtonConfig() ButtonConfig {
+	return ButtonConfig{
+		Debounce:          20 * time.Millisecond,
+		MinPress:          50 * time.Millisecond,
+		LongPress:         1 * time.Second,
+		DoublePressWindow: 400 * time.Millisecond,
+	}
+}
+
+// Validate checks the settings, adding any problems to errs
+func (c ButtonConfig) Validate(errs *configfile.Errors, path string) {
+	if c.Debounce < 0 {
+		errs.Add(configfile.Join(path, "debounce"), "must not be negative")
+	}
+	if c.MinPress < 0 {
+		errs.Add(configfile.Join(path, "minPress"), "must not be negative")
+	}
+	if c.LongPress <= c.MinPress {
+		errs.Add(configfile.Join(path, "longPress"), "must be greater than minPress")
+	}
+	if c.DoublePressWindow < 0 {
+		errs.Add(configfile.Join(path, "doublePressWindow"), "must not be negative")
+	}
+}
+
+// buttonInput is the state of a button (identified by the event source) between the raw transitions and the
+// button events. A transition is accepted once the button has been stable for the debounce time. A press is
+// broadcast once it has been held for the min press time, and the release is followed by a short, long or
+// double press event
+type buttonInput struct {
+	source string
+	// raw is the latest raw state, changedAt is when it changed and debounceTimer accepts it when it has been stable
+	raw           bool
+	changedAt     time.Time
+	debounceTimer *time.Timer
+	// stable is the debounced state and pressedAt is when the debounced press started
+	stable    bool
+	pressedAt time.Time
+	// minPressTimer broadcasts the press once it has been held for the min press time
+	minPressTimer *time.Timer
+	// pressed is set when the press has been broadcast (after the min press time)
+	pressed bool
+	// shortPress is the pending short press that becomes a double press if there is another press within the window
+	shortPress      *time.Timer
+	shortPressHeld  time.Duration
+	secondPressSeen bool
+}
+
+// SetButtonConfig sets the debounce, glitch rejection and press classification settings
+func (b *BellPush) SetButtonConfig(config ButtonConfig) {
+	b.buttonsLock.Lock()
+	defer b.buttonsLock.Unlock()
+	b.buttonConfig = config
+}
+
+// ButtonChanged handles a raw transition of the button for source (e.g. from the GPIO driver). Transitions
+// are debounced and classified before button events are broadcast
+func (b *BellPush) ButtonChanged(source string, pressed bool) {
+	b.buttonsLock.Lock()
+	input, ok := b.buttonInputs[source]
+	if !ok {
+		input = &buttonInput{source: source}
+		b.buttonInputs[source] = input
+	}
+	if pressed == input.raw {
+		b.buttonsLock.Unlock()
+		return
+	}
+	input.raw = pressed
+	input.changedAt = time.Now()
+	changedAt := input.changedAt
+	if input.debounceTimer != nil {
+		input.debounceTimer.Stop()
+	}
+	if b.buttonConfig.Debounce > 0 {
+		input.debounceTimer = time.AfterFunc(b.buttonConfig.Debounce, func() { b.settleButton(input, changedAt) })
+		b.buttonsLock.Unlock()
+		return
+	}
+	b.buttonsLock.Unlock()
+	b.settleButton(input, changedAt)
+}
+
+// settleButton accepts the raw state of input if it hasn't changed since changedAt
+func (b *BellPush) settleButton(input *buttonInput, changedAt time.Time) {
+	b.buttonsLock.Lock()
+	if !input.changedAt.Equal(changedAt) {
+		b.buttonsLock.Unlock()
+		return
+	}
+	if input.raw == input.stable {
+		// the button bounced back before the debounce time
+		b.buttonsLock.Unlock()
+		buttonBouncesCounter.WithLabelValues(input.source).Inc()
+		return
+	}
+	input.stable = input.raw
+	config := b.buttonConfig
+
+	if input.stable {
+		input.pressedAt = changedAt
+		if wait := config.MinPress - time.Since(changedAt); wait > 0 {
+			if input.minPressTimer != nil {
+				input.minPressTimer.Stop()
+			}
+			input.minPressTimer = time.AfterFunc(wait, func() { b.confirmPress(input, changedAt) })
+			b.buttonsLock.Unlock()
+			return
+		}
+		b.buttonsLock.Unlock()
+		b.confirmPress(input, changedAt)
+		return
+	}
+
+	if !input.pressed {
+		b.buttonsLock.Unlock()
+		logger.Debug("Rejecting glitch on button", "source", input.source, "heldFor", changedAt.Sub(input.pressedAt))
+		buttonGlitchesCounter.WithLabelValues(input.source).Inc()
+		return
+	}
+	input.pressed = false
+	heldFor := changedAt.Sub(input.pressedAt)
+	released := events.NewButtonEvent(events.ButtonReleased, input.source)
+	released.Time = changedAt
+	var classified *events.ButtonEvent
+	switch {
+	case b.isButtonStuck(input.source):
+		// the stuck event has already turned the chimes off, so the press isn't classified
+		input.secondPressSeen = false
+		logger.Info("Not classifying the release of a stuck button", "source", input.source, "heldFor", heldFor)
+	case input.secondPressSeen:
+		input.secondPressSeen = false
+		classified = events.NewButtonEvent(events.ButtonDoublePress, input.source)
+	case heldFor >= config.LongPress:
+		classified = events.NewButtonEvent(events.ButtonLongPress, input.source)
+		classified.HeldFor = heldFor
+	case config.DoublePressWindow <= 0:
+		classified = events.NewButtonEvent(events.ButtonShortPress, input.source)
+		classified.HeldFor = heldFor
+	default:
+		// wait to see whether there is a second press
+		var timer *time.Timer
+		timer = time.AfterFunc(config.DoublePressWindow, func() { b.shortPressTimedOut(input, timer) })
+		input.shortPress = timer
+		input.shortPressHeld = heldFor
+	}
+	b.buttonsLock.Unlock()
+
+	b.broadcastButtonEvent(released)
+	if classified != nil {
+		b.broadcastButtonEvent(classified)
+	}
+}
+
+// confirmPress broadcasts the press that started at pressedAt if the button is still held (even before the debounce)
+func (b *BellPush) confirmPress(input *buttonInput, pressedAt time.Time) {
+	b.buttonsLock.Lock()
+	if !input.stable || !input.raw || input.pressed || !input.pressedAt.Equal(pressedAt) {
+		b.buttonsLock.Unlock()
+		return
+	}
+	input.pressed = true
+	if input.shortPress != nil {
+		// a press soon after a short press makes a double press when it is released
+		input.shortPress.Stop()
+		input.shortPress = nil
+		input.secondPressSeen = true
+	}
+	b.buttonsLock.Unlock()
+
+	event := events.NewButtonEvent(events.ButtonPressed, input.source)
+	// the latency measurements start from the edge rather than the end of the debounce
+	event.Time = pressedAt
+	b.broadcastButtonEvent(event)
+}
+
+// shortPressTimedOut broadcasts a short press when there hasn't been a second press within the double press window
+func (b *BellPush) shortPressTimedOut(input *buttonInput, timer *time.Timer) {
+	b.buttonsLock.Lock()
+	if input.shortPress != timer {
+		b.buttonsLock.Unlock()
+		return
+	}
+	input.shortPress = nil
+	heldFor := input.shortPressHeld
+	b.buttonsLock.Unlock()
+
+	event := events.NewButtonEvent(events.ButtonShortPress, input.source)
+	event.HeldFor = heldFor
+	b.broadcastButtonEvent(event)
+}
+
+func (b *BellPush) broadcastButtonEvent(event *events.ButtonEvent) {
+	if err := b.BroadcastEvent(event); err != nil {
+		logger.WithCorrelationID(event.ID).Error("Error broadcasting button event", "type", events.TypeToString(event.ButtonEventType), "err", err)
+		b.telemetryClient.TrackException(err)
+	}
+}
+
+// stopButtonInputTimers stops the debounce and press timers (on shutdown)
+func (b *BellPush) stopButtonInputTimers() {
+	b.buttonsLock.Lock()
+	defer b.buttonsLock.Unlock()
+	for _, input := range b.buttonInputs {
+		if input.debounceTimer != nil {
+			input.debounceTimer.Stop()
+		}
+		if input.minPressTimer != nil {
+			input.minPressTimer.Stop()
+		}
+		if input.shortPress != nil {
+			input.shortPress.Stop()
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package bellpush
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stuartleeks/pi-bell/internal/pkg/events"
+	"github.com/stuartleeks/pi-bell/internal/pkg/telemetry"
+)
+
+// newTestBellPush returns a bellpush and a channel that receives the button events that it broadcasts
+func newTestBellPush(t *testing.T) (*BellPush, chan *events.ButtonEvent) {
+	b := NewBellPush(telemetry.NewNoopClient(), "front")
+	buttonEvents := make(chan *events.ButtonEvent, 100)
+	b.AddEventListener(func(chimeName string, event events.Event) {
+		if buttonEvent, ok := event.(*events.ButtonEvent); ok && chimeName == "" {
+			buttonEvents <- buttonEvent
+		}
+	})
+	t.Cleanup(func() {
+		b.stopButtonInputTimers()
+		b.stopButtonTimers()
+	})
+	return b, buttonEvents
+}
+
+// takeButtonEvents returns the types of the button events received so far
+func takeButtonEvents(buttonEvents chan *events.ButtonEvent) []events.ButtonEventType {
+	var types []events.ButtonEventType
+	for {
+		select {
+		case event := <-buttonEvents:
+			types = append(types, event.ButtonEventType)
+		default:
+			return types
+		}
+	}
+}
+
+// buttonTypesString formats button event types for test failures
+func buttonTypesString(types []events.ButtonEventType) string {
+	s := "["
+	for i, eventType := range types {
+		if i > 0 {
+			s += " "
+		}
+		s += events.TypeToString(eventType)
+	}
+	return s + "]"
+}
+
+func TestButtonChanged(t *testing.T) {
+	config := ButtonConfig{
+		Debounce:          10 * time.Millisecond,
+		MinPress:          30 * time.Millisecond,
+		LongPress:         250 * time.Millisecond,
+		DoublePressWindow: 0,
+	}
+	withDoublePress := config
+	withDoublePress.DoublePressWindow = 200 * time.Millisecond
+
+	// step is a raw button transition followed by a wait
+	type step struct {
+		pressed bool
+		wait    time.Duration
+	}
+	testCases := []struct {
+		name     string
+		config   ButtonConfig
+		steps    []step
+		expected []events.ButtonEventType
+	}{
+		{
+			name:   "bounce within the debounce time is ignored",
+			config: config,
+			steps:  []step{{true, 2 * time.Millisecond}, {false, 60 * time.Millisecond}},
+		},
+		{
+			name:   "press shorter than the min press is rejected as a glitch",
+			config: config,
+			steps:  []step{{true, 15 * time.Millisecond}, {false, 60 * time.Millisecond}},
+		},
+		{
+			name:     "short press",
+			config:   config,
+			steps:    []step{{true, 80 * time.Millisecond}, {false, 60 * time.Millisecond}},
+			expected: []events.ButtonEventType{events.ButtonPressed, events.ButtonReleased, events.ButtonShortPress},
+		},
+		{
+			name:     "bounces during a press are ignored",
+			config:   config,
+			steps:    []step{{true, 50 * time.Millisecond}, {false, 2 * time.Millisecond}, {true, 50 * time.Millisecond}, {false, 60 * time.Millisecond}},
+			expected: []events.ButtonEventType{events.ButtonPressed, events.ButtonReleased, events.ButtonShortPress},
+		},
+		{
+			name:     "long press",
+			config:   config,
+			steps:    []step{{true, 350 * time.Millisecond}, {false, 60 * time.Millisecond}},
+			expected: []events.ButtonEventType{events.ButtonPressed, events.ButtonReleased, events.ButtonLongPress},
+		},
+		{
+			name:     "short press after the double press window",
+			config:   withDoublePress,
+			steps:    []step{{true, 80 * time.Millisecond}, {false, 350 * time.Millisecond}},
+			expected: []events.ButtonEventType{events.ButtonPressed, events.ButtonReleased, events.ButtonShortPress},
+		},
+		{
+			name:   "double press",
+			config: withDoublePress,
+			steps:  []step{{true, 80 * time.Millisecond}, {false, 60 * time.Millisecond}, {true, 80 * time.Millisecond}, {false, 350 * time.Millisecond}},
+			expected: []events.ButtonEventType{
+				events.ButtonPressed, events.ButtonReleased,
+				events.ButtonPressed, events.ButtonReleased, events.ButtonDoublePress,
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			b, buttonEvents := newTestBellPush(t)
+			b.SetButtonConfig(testCase.config)
+			for _, step := range testCase.steps {
+				b.ButtonChanged("test", step.pressed)
+				time.Sleep(step.wait)
+			}
+			actual := takeButtonEvents(buttonEvents)
+			if buttonTypesString(actual) != buttonTypesString(testCase.expected) {
+				t.Errorf("expected %s, got %s", buttonTypesString(testCase.expected), buttonTypesString(actual))
+			}
+		})
+	}
+}
+
+func TestStuckButtonReleaseIsNotClassified(t *testing.T) {
+	b, buttonEvents := newTestBellPush(t)
+	b.SetButtonConfig(ButtonConfig{LongPress: 10 * time.Millisecond})
+	b.SetStuckButtonTimeout(50 * time.Millisecond)
+
+	b.ButtonChanged("test", true)
+	time.Sleep(150 * time.Millisecond)
+	b.ButtonChanged("test", false)
+
+	// the stuck event has turned the chimes off, so the release isn't a long press (which would ring them all)
+	expected := []events.ButtonEventType{events.ButtonPressed, events.ButtonStuck, events.ButtonReleased}
+	if actual := takeButtonEvents(buttonEvents); buttonTypesString(actual) != buttonTypesString(expected) {
+		t.Errorf("expected %s, got %s", buttonTypesString(expected), buttonTypesString(actual))
+	}
+}
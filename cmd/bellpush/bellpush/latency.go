@@ -0,0 +1,43 @@
+package bellpush
+
+import (
+	"time"
+
+	"github.com/stuartleeks/pi-bell/internal/pkg/events"
+)
+
+// RecordLatencyReport records the latency measured by a chime for a button event.
+// receivedAt is when the report was read from the chime's connection
+func (b *BellPush) RecordLatencyReport(chimeName string, report *events.LatencyReport, receivedAt time.Time) {
+	reportLogger := logger.WithCorrelationID(report.EventID).With("chime", chimeName)
+	breakdown, err := report.Analyse(receivedAt)
+	if err != nil {
+		reportLogger.Warn("Invalid latency report", "err", err)
+		return
+	}
+
+	chimeClockOffsetGauge.WithLabelValues(chimeName).Set(breakdown.ClockOffset.Seconds())
+	chimeRoundTripHistogram.WithLabelValues(chimeName).Observe(breakdown.RoundTrip.Seconds())
+	for _, hop := range []string{events.HopBroadcast, events.HopSend, events.HopReceive, events.HopRelayOn} {
+		if latency, ok := breakdown.Latencies[hop]; ok {
+			ringLatencyHistogram.WithLabelValues(chimeName, hop).Observe(latency.Seconds())
+		}
+	}
+
+	keyValues := []any{"clockOffset", breakdown.ClockOffset, "roundTrip", breakdown.RoundTrip}
+	for _, hop := range report.Hops {
+		keyValues = append(keyValues, hop.Name, breakdown.Latencies[hop.Name])
+	}
+	reportLogger.Info("Latency report", keyValues...)
+
+	properties := map[string]string{
+		"id":          report.EventID.String(),
+		"chimeName":   chimeName,
+		"clockOffset": breakdown.ClockOffset.String(),
+		"roundTrip":   breakdown.RoundTrip.String(),
+	}
+	for hop, latency := range breakdown.Latencies {
+		properties[hop] = latency.String()
+	}
+	b.telemetryClient.TrackEvent("latency-report", properties)
+}
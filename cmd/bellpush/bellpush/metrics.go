@@ -0,0 +1,113 @@
+package bellpush
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	ringsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pibell_bellpush_rings_total",
+		Help: "The number of times the bell has been rung",
+	}, []string{"door", "source"})
+	buttonBouncesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pibell_bellpush_button_bounces_total",
+		Help: "The number of button transitions that were reversed within the debounce time",
+	}, []string{"source"})
+	buttonGlitchesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pibell_bellpush_button_glitches_total",
+		Help: "The number of presses rejected as glitches because they were shorter than the min press time",
+	}, []string{"source"})
+	buttonPressesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pibell_bellpush_button_presses_total",
+		Help: "The number of classified presses by kind (short-press, long-press or double-press)",
+	}, []string{"door", "kind"})
+	stuckButtonsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pibell_bellpush_stuck_buttons_total",
+		Help: "The number of times a button has been held for longer than the stuck button timeout",
+	}, []string{"door", "source"})
+	stuckPressesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pibell_bellpush_stuck_presses_total",
+		Help: "The number of presses that weren't broadcast because the button was stuck",
+	}, []string{"door", "source"})
+	droppedEventsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pibell_bellpush_dropped_events_total",
+		Help: "The number of events dropped because a chime's queue was full",
+	}, []string{"chime", "type"})
+	snoozeRequestsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pibell_bellpush_snooze_requests_total",
+		Help: "The number of snooze requests sent by chimes by action (snooze or cancel)",
+	}, []string{"chime", "action"})
+	missedRingsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pibell_bellpush_missed_rings_total",
+		Help: "The number of rings recorded for chimes that were disconnected (within the missed ring window)",
+	}, []string{"chime"})
+	cameraFramesCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pibell_bellpush_camera_frames_total",
+		Help: "The number of webcam frames captured (use rate() for FPS)",
+	})
+	ringLatencyHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pibell_bellpush_ring_latency_seconds",
+		Help:    "The time from the button event to each hop (broadcast, send, receive, relay-on) as reported by the chimes, adjusted for clock skew",
+		Buckets: []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+	}, []string{"chime", "hop"})
+	chimeRoundTripHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pibell_bellpush_chime_round_trip_seconds",
+		Help:    "The network round trip time to the chime measured from latency reports",
+		Buckets: []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1},
+	}, []string{"chime"})
+	chimeClockOffsetGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pibell_bellpush_chime_clock_offset_seconds",
+		Help: "The estimated offset of the chime's clock from the bellpush's clock (from the latest latency report)",
+	}, []string{"chime"})
+
+	connectedChimesDesc = prometheus.NewDesc(
+		"pibell_bellpush_connected_chimes",
+		"The number of connected chimes",
+		nil, nil,
+	)
+	chimeQueueDepthDesc = prometheus.NewDesc(
+		"pibell_bellpush_chime_queue_depth",
+		"The number of events queued for a chime",
+		[]string{"chime"}, nil,
+	)
+	chimeSnoozedDesc = prometheus.NewDesc(
+		"pibell_bellpush_chime_snoozed",
+		"Whether a chime is snoozed (1) or not (0)",
+		[]string{"chime"}, nil,
+	)
+	cameraFrameAgeDesc = prometheus.NewDesc(
+		"pibell_bellpush_camera_frame_age_seconds",
+		"The time since the latest webcam frame was captured",
+		nil, nil,
+	)
+)
+
+var _ prometheus.Collector = &BellPush{}
+
+// Describe implements prometheus.Collector for the metrics that are read from the bellpush state when scraped
+func (b *BellPush) Describe(ch chan<- *prometheus.Desc) {
+	ch <- connectedChimesDesc
+	ch <- chimeQueueDepthDesc
+	ch <- chimeSnoozedDesc
+	ch <- cameraFrameAgeDesc
+}
+
+// Collect implements prometheus.Collector
+func (b *BellPush) Collect(ch chan<- prometheus.Metric) {
+	chimes := b.GetChimes()
+	ch <- prometheus.MustNewConstMetric(connectedChimesDesc, prometheus.GaugeValue, float64(len(chimes)))
+	for name, chime := range chimes {
+		ch <- prometheus.MustNewConstMetric(chimeQueueDepthDesc, prometheus.GaugeValue, float64(len(chime.Events)), name)
+		snoozed := 0.0
+		if chime.IsSnoozed() {
+			snoozed = 1
+		}
+		ch <- prometheus.MustNewConstMetric(chimeSnoozedDesc, prometheus.GaugeValue, snoozed, name)
+	}
+	if frameTime := b.GetWebcamFrameTime(); !frameTime.IsZero() {
+		ch <- prometheus.MustNewConstMetric(cameraFrameAgeDesc, prometheus.GaugeValue, time.Since(frameTime).Seconds())
+	}
+}
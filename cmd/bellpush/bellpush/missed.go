@@ -0,0 +1,100 @@
+package bellpush
+
+import (
+	"time"
+
+	"github.com/stuartleeks/pi-bell/internal/pkg/events"
+)
+
+const (
+	// DefaultMissedRingWindow is how long rings are recorded for a chime after it disconnects
+	DefaultMissedRingWindow = 1 * time.Hour
+	// maxMissedRings is the number of missed rings kept for each chime (the most recent are kept)
+	maxMissedRings = 20
+)
+
+// offlineChime records the rings missed by a chime since it disconnected
+type offlineChime struct {
+	disconnectedAt time.Time
+	count          int
+	rings          []events.MissedRing
+}
+
+// SetMissedRingWindow sets how long rings are recorded for a chime after it disconnects (0 to disable). A chime that
+// reconnects within the window is sent a missed rings event if the bell rang while it was disconnected
+func (b *BellPush) SetMissedRingWindow(window time.Duration) {
+	b.chimesLock.Lock()
+	defer b.chimesLock.Unlock()
+	b.missedRingWindow = window
+	if window <= 0 {
+		b.offlineChimes = make(map[string]*offlineChime)
+	}
+}
+
+// chimeDisconnected starts recording missed rings for a chime that has disconnected. chimesLock must be held
+func (b *BellPush) chimeDisconnected(name string) {
+	if b.missedRingWindow <= 0 {
+		return
+	}
+	b.pruneOfflineChimes()
+	b.offlineChimes[name] = &offlineChime{disconnectedAt: time.Now()}
+}
+
+// pruneOfflineChimes stops recording missed rings for chimes that disconnected longer ago than the
+// missed ring window. chimesLock must be held
+func (b *BellPush) pruneOfflineChimes() {
+	for name, offline := range b.offlineChimes {
+		if time.Since(offline.disconnectedAt) > b.missedRingWindow {
+			if offline.count > 0 {
+				logger.Info("Chime didn't reconnect within the missed ring window - discarding missed rings", "chime", name, "disconnectedAt", offline.disconnectedAt, "count", offline.count)
+			}
+			delete(b.offlineChimes, name)
+		}
+	}
+}
+
+// recordMissedRing records a ring for each chime that is disconnected
+func (b *BellPush) recordMissedRing(event *events.ButtonEvent) {
+	ringTime := event.Time
+	if ringTime.IsZero() {
+		ringTime = time.Now()
+	}
+	ring := events.MissedRing{
+		ID:     event.ID,
+		Time:   ringTime,
+		Door:   event.Door,
+		Source: event.Source,
+	}
+
+	b.chimesLock.Lock()
+	defer b.chimesLock.Unlock()
+	b.pruneOfflineChimes()
+	for name, offline := range b.offlineChimes {
+		if _, connected := b.chimes[name]; connected {
+			continue
+		}
+		offline.count++
+		offline.rings = append(offline.rings, ring)
+		if len(offline.rings) > maxMissedRings {
+			offline.rings = offline.rings[len(offline.rings)-maxMissedRings:]
+		}
+		logger.WithCorrelationID(event.ID).Info("Chime disconnected - recording missed ring", "chime", name, "disconnectedAt", offline.disconnectedAt)
+		missedRingsCounter.WithLabelValues(name).Inc()
+	}
+}
+
+// takeMissedRings returns the missed rings event for a chime that has reconnected (nil if it didn't miss any rings)
+func (b *BellPush) takeMissedRings(name string) *events.MissedRingsEvent {
+	b.chimesLock.Lock()
+	defer b.chimesLock.Unlock()
+	b.pruneOfflineChimes()
+	offline, ok := b.offlineChimes[name]
+	if !ok {
+		return nil
+	}
+	delete(b.offlineChimes, name)
+	if offline.count == 0 {
+		return nil
+	}
+	return events.NewMissedRingsEvent(offline.disconnectedAt, offline.count, offline.rings)
+}
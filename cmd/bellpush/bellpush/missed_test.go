@@ -0,0 +1,125 @@
+package bellpush
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stuartleeks/pi-bell/internal/pkg/events"
+)
+
+// ring broadcasts a press and release for source
+func ring(t *testing.T, b *BellPush, source string) {
+	t.Helper()
+	for _, eventType := range []events.ButtonEventType{events.ButtonPressed, events.ButtonReleased} {
+		if err := b.BroadcastEvent(events.NewButtonEvent(eventType, source)); err != nil {
+			t.Fatalf("BroadcastEvent returned an error: %v", err)
+		}
+	}
+}
+
+// connectChime registers a chime with features and returns the missed rings events that it was sent
+func connectChime(b *BellPush, name string, features ...string) []*events.MissedRingsEvent {
+	chimeEvents := make(chan events.Event, 10)
+	b.SetChime(name, ChimeInfo{Events: chimeEvents, Features: features})
+	var missedRings []*events.MissedRingsEvent
+	for {
+		select {
+		case event := <-chimeEvents:
+			if missed, ok := event.(*events.MissedRingsEvent); ok {
+				missedRings = append(missedRings, missed)
+			}
+		default:
+			return missedRings
+		}
+	}
+}
+
+func TestMissedRingsOnlyRecordedForDisconnectedChimes(t *testing.T) {
+	b, _ := newTestBellPush(t)
+	connectChime(b, "kitchen", events.FeatureMissedRings)
+	connectChime(b, "hall", events.FeatureMissedRings)
+	b.RemoveChime("kitchen")
+
+	ring(t, b, "gpio")
+
+	missed := connectChime(b, "kitchen", events.FeatureMissedRings)
+	if len(missed) != 1 || missed[0].Count != 1 || len(missed[0].Rings) != 1 || missed[0].Rings[0].Door != "front" {
+		t.Fatalf("expected the disconnected chime to be sent one missed ring for the front door, got %#v", missed)
+	}
+	// the connected chime and a chime that has never connected don't miss rings
+	b.RemoveChime("hall")
+	if missed := connectChime(b, "hall", events.FeatureMissedRings); len(missed) != 0 {
+		t.Errorf("expected no missed rings for a chime that was connected, got %#v", missed)
+	}
+	if missed := connectChime(b, "garage", events.FeatureMissedRings); len(missed) != 0 {
+		t.Errorf("expected no missed rings for an unknown chime, got %#v", missed)
+	}
+}
+
+func TestMissedRingsSummarisedOnReconnect(t *testing.T) {
+	b, _ := newTestBellPush(t)
+	connectChime(b, "kitchen", events.FeatureMissedRings)
+	b.RemoveChime("kitchen")
+
+	for i := 0; i < maxMissedRings+5; i++ {
+		ring(t, b, "gpio")
+	}
+
+	missed := connectChime(b, "kitchen", events.FeatureMissedRings)
+	if len(missed) != 1 {
+		t.Fatalf("expected one missed rings event, got %d", len(missed))
+	}
+	if missed[0].Count != maxMissedRings+5 || len(missed[0].Rings) != maxMissedRings {
+		t.Errorf("expected a count of %d with the latest %d rings, got %d with %d rings", maxMissedRings+5, maxMissedRings, missed[0].Count, len(missed[0].Rings))
+	}
+
+	// the missed rings are cleared once they have been delivered
+	b.RemoveChime("kitchen")
+	if missed := connectChime(b, "kitchen", events.FeatureMissedRings); len(missed) != 0 {
+		t.Errorf("expected no missed rings after they were delivered, got %#v", missed)
+	}
+}
+
+func TestMissedRingsNotSentWithoutFeature(t *testing.T) {
+	b, _ := newTestBellPush(t)
+	connectChime(b, "kitchen")
+	b.RemoveChime("kitchen")
+	ring(t, b, "gpio")
+
+	if missed := connectChime(b, "kitchen", events.FeatureAck); len(missed) != 0 {
+		t.Errorf("expected no missed rings for a chime without the missed-rings feature, got %#v", missed)
+	}
+	// they are discarded rather than kept for a later connection
+	b.RemoveChime("kitchen")
+	if missed := connectChime(b, "kitchen", events.FeatureMissedRings); len(missed) != 0 {
+		t.Errorf("expected the missed rings to have been discarded, got %#v", missed)
+	}
+}
+
+func TestMissedRingsExpireOutsideWindow(t *testing.T) {
+	b, _ := newTestBellPush(t)
+	b.SetMissedRingWindow(50 * time.Millisecond)
+	connectChime(b, "kitchen", events.FeatureMissedRings)
+	connectChime(b, "hall", events.FeatureMissedRings)
+	b.RemoveChime("kitchen")
+	ring(t, b, "gpio")
+
+	// the kitchen chime reconnects after the window, and the hall chime disconnects after it has passed
+	time.Sleep(100 * time.Millisecond)
+	b.RemoveChime("hall")
+	ring(t, b, "gpio")
+	if missed := connectChime(b, "kitchen", events.FeatureMissedRings); len(missed) != 0 {
+		t.Errorf("expected no missed rings after the window, got %#v", missed)
+	}
+	if missed := connectChime(b, "hall", events.FeatureMissedRings); len(missed) != 1 || missed[0].Count != 1 {
+		t.Errorf("expected one missed ring within the window, got %#v", missed)
+	}
+
+	// disabling the window stops recording
+	b.SetMissedRingWindow(0)
+	b.RemoveChime("hall")
+	ring(t, b, "gpio")
+	if missed := connectChime(b, "hall", events.FeatureMissedRings); len(missed) != 0 {
+		t.Errorf("expected no missed rings with the window disabled, got %#v", missed)
+	}
+}
@@ -0,0 +1,139 @@
+package bellpush
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// state is the bellpush state that is saved on shutdown so that it survives a restart
+type state struct {
+	// Snoozes holds the snooze expiry for each snoozed chime
+	Snoozes map[string]time.Time `json:"snoozes"`
+}
+
+// LoadState loads the state saved by SaveState. Snoozes are applied when the chimes reconnect.
+// A missing file is not an error (e.g. on first run)
+func (b *BellPush) LoadState(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to read state file: %w", err)
+	}
+	var s state
+	if err = json.Unmarshal(content, &s); err != nil {
+		return fmt.Errorf("invalid state file %s: %w", path, err)
+	}
+
+	b.chimesLock.Lock()
+	defer b.chimesLock.Unlock()
+	now := time.Now()
+	for name, snoozeEnd := range s.Snoozes {
+		if snoozeEnd.After(now) {
+			b.restoredSnoozes[name] = snoozeEnd
+		}
+	}
+	logger.Info("Loaded state", "path", path, "snoozes", len(b.restoredSnoozes))
+	return nil
+}
+
+// SaveState saves the snoozes for connected chimes and for chimes that haven't reconnected since LoadState
+func (b *BellPush) SaveState(path string) error {
+	s := state{Snoozes: make(map[string]time.Time)}
+	now := time.Now()
+	b.chimesLock.RLock()
+	for name, snoozeEnd := range b.restoredSnoozes {
+		if snoozeEnd.After(now) {
+			s.Snoozes[name] = snoozeEnd
+		}
+	}
+	for name, chime := range b.chimes {
+		delete(s.Snoozes, name)
+		if chime.IsSnoozed() {
+			s.Snoozes[name] = chime.SnoozeEnd
+		}
+	}
+	b.chimesLock.RUnlock()
+
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write to a temporary file and rename so that a partially written file doesn't replace the previous state
+	tempFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create state file: %w", err)
+	}
+	defer os.Remove(tempFile.Name()) // nolint:errcheck
+	if _, err = tempFile.Write(content); err != nil {
+		_ = tempFile.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err = tempFile.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err = os.Rename(tempFile.Name(), path); err != nil {
+		return fmt.Errorf("failed to save state file: %w", err)
+	}
+	logger.Info("Saved state", "path", path, "snoozes", len(s.Snoozes))
+	return nil
+}
+
+// TakeRestoredSnooze returns the snooze expiry loaded from the state file for a chime that is reconnecting
+// (or initTime if there isn't one). The restored snooze is removed so that it is only applied once
+func (b *BellPush) TakeRestoredSnooze(name string) time.Time {
+	b.chimesLock.Lock()
+	defer b.chimesLock.Unlock()
+	snoozeEnd, ok := b.restoredSnoozes[name]
+	if !ok {
+		return initTime
+	}
+	delete(b.restoredSnoozes, name)
+	return snoozeEnd
+}
+
+// ImportPeerSnoozes applies the chime snoozes from the other bellpush in a failover pair (the snooze expiry for each
+// chime connected to it, zero if not snoozed). They are applied like snoozes from the state file when the chimes
+// connect, so that a chime keeps its snooze when it fails over. Chimes that are connected to this bellpush are skipped
+func (b *BellPush) ImportPeerSnoozes(snoozes map[string]time.Time) {
+	b.chimesLock.Lock()
+	defer b.chimesLock.Unlock()
+	now := time.Now()
+	for name, snoozeEnd := range snoozes {
+		if _, connected := b.chimes[name]; connected {
+			continue
+		}
+		if snoozeEnd.After(now) {
+			b.restoredSnoozes[name] = snoozeEnd
+		} else {
+			delete(b.restoredSnoozes, name)
+		}
+	}
+}
+
+// GetSnoozes returns the snooze expiry for each connected chime (zero if not snoozed)
+// and for the chimes with a snooze that is waiting for them to reconnect
+func (b *BellPush) GetSnoozes() map[string]time.Time {
+	b.chimesLock.RLock()
+	defer b.chimesLock.RUnlock()
+	snoozes := make(map[string]time.Time, len(b.chimes)+len(b.restoredSnoozes))
+	now := time.Now()
+	for name, snoozeEnd := range b.restoredSnoozes {
+		if snoozeEnd.After(now) {
+			snoozes[name] = snoozeEnd
+		}
+	}
+	for name, chime := range b.chimes {
+		if chime.IsSnoozed() {
+			snoozes[name] = chime.SnoozeEnd
+		} else {
+			snoozes[name] = time.Time{}
+		}
+	}
+	return snoozes
+}
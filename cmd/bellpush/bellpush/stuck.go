@@ -0,0 +1,98 @@
+package bellpush
+
+import (
+	"time"
+
+	"github.com/stuartleeks/pi-bell/internal/pkg/events"
+)
+
+// DefaultStuckButtonTimeout is how long a button can be held before it is treated as stuck
+const DefaultStuckButtonTimeout = 30 * time.Second
+
+// heldButton tracks a button (by event source) between being pressed and released
+type heldButton struct {
+	pressedAt time.Time
+	timer     *time.Timer
+	// stuck is set when the button has been held for longer than the stuck button timeout
+	stuck bool
+}
+
+// SetStuckButtonTimeout sets how long a button can be held before it is treated as stuck (0 to disable)
+func (b *BellPush) SetStuckButtonTimeout(timeout time.Duration) {
+	b.buttonsLock.Lock()
+	defer b.buttonsLock.Unlock()
+	b.stuckButtonTimeout = timeout
+}
+
+// trackButton updates the held buttons for a button event that is about to be broadcast.
+// Returns false if the event shouldn't be broadcast because the button is stuck
+func (b *BellPush) trackButton(event *events.ButtonEvent) bool {
+	b.buttonsLock.Lock()
+	defer b.buttonsLock.Unlock()
+	held, ok := b.heldButtons[event.Source]
+	switch event.ButtonEventType {
+	case events.ButtonPressed:
+		if ok && held.stuck {
+			logger.WithCorrelationID(event.ID).Warn("Button stuck - not broadcasting press", "source", event.Source, "pressedAt", held.pressedAt)
+			stuckPressesCounter.WithLabelValues(event.Door, event.Source).Inc()
+			return false
+		}
+		if ok {
+			// pressed again without a release (e.g. a lost GPIO edge) - keep timing from the first press
+			return true
+		}
+		if b.stuckButtonTimeout <= 0 {
+			return true
+		}
+		held = &heldButton{pressedAt: time.Now()}
+		held.timer = time.AfterFunc(b.stuckButtonTimeout, func() { b.buttonStuck(event.Source, event.Door, held) })
+		b.heldButtons[event.Source] = held
+	case events.ButtonReleased:
+		if !ok {
+			return true
+		}
+		held.timer.Stop()
+		delete(b.heldButtons, event.Source)
+		if held.stuck {
+			logger.WithCorrelationID(event.ID).Info("Stuck button released", "source", event.Source, "heldFor", time.Since(held.pressedAt))
+		}
+	}
+	return true
+}
+
+// isButtonStuck returns true if the button for source has been treated as stuck and not released yet.
+// Must be called with buttonsLock held
+func (b *BellPush) isButtonStuck(source string) bool {
+	held, ok := b.heldButtons[source]
+	return ok && held.stuck
+}
+
+// buttonStuck is called when held has been held for the stuck button timeout and broadcasts a stuck event
+// so that the chimes turn off their relays
+func (b *BellPush) buttonStuck(source string, door string, held *heldButton) {
+	b.buttonsLock.Lock()
+	if b.heldButtons[source] != held {
+		b.buttonsLock.Unlock()
+		return
+	}
+	held.stuck = true
+	b.buttonsLock.Unlock()
+
+	event := events.NewButtonEvent(events.ButtonStuck, source)
+	event.Door = door
+	logger.WithCorrelationID(event.ID).Warn("Button held for longer than the stuck button timeout - treating as stuck", "source", source, "pressedAt", held.pressedAt)
+	stuckButtonsCounter.WithLabelValues(door, source).Inc()
+	if err := b.BroadcastEvent(event); err != nil {
+		logger.WithCorrelationID(event.ID).Error("Error broadcasting button stuck event", "err", err)
+		b.telemetryClient.TrackException(err)
+	}
+}
+
+// stopButtonTimers stops the stuck button timers (on shutdown)
+func (b *BellPush) stopButtonTimers() {
+	b.buttonsLock.Lock()
+	defer b.buttonsLock.Unlock()
+	for _, held := range b.heldButtons {
+		held.timer.Stop()
+	}
+}
@@ -0,0 +1,101 @@
+package bellpush
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stuartleeks/pi-bell/internal/pkg/events"
+)
+
+func TestStuckButton(t *testing.T) {
+	b, buttonEvents := newTestBellPush(t)
+	b.SetStuckButtonTimeout(50 * time.Millisecond)
+	broadcast := func(eventType events.ButtonEventType) {
+		if err := b.BroadcastEvent(events.NewButtonEvent(eventType, "test")); err != nil {
+			t.Fatalf("BroadcastEvent returned an error: %v", err)
+		}
+	}
+	expectEvents := func(expected ...events.ButtonEventType) {
+		t.Helper()
+		if actual := takeButtonEvents(buttonEvents); buttonTypesString(actual) != buttonTypesString(expected) {
+			t.Errorf("expected %s, got %s", buttonTypesString(expected), buttonTypesString(actual))
+		}
+	}
+
+	// the stuck event is only sent once however long the button is held
+	broadcast(events.ButtonPressed)
+	time.Sleep(200 * time.Millisecond)
+	expectEvents(events.ButtonPressed, events.ButtonStuck)
+
+	// presses aren't broadcast while the button is stuck
+	broadcast(events.ButtonPressed)
+	expectEvents()
+
+	// the release clears the stuck state
+	broadcast(events.ButtonReleased)
+	broadcast(events.ButtonPressed)
+	broadcast(events.ButtonReleased)
+	expectEvents(events.ButtonReleased, events.ButtonPressed, events.ButtonReleased)
+}
+
+func TestEventForChime(t *testing.T) {
+	withButtonEvents := ChimeInfo{Features: []string{events.FeatureButtonEvents}}
+	withoutButtonEvents := ChimeInfo{}
+
+	testCases := []struct {
+		eventType events.ButtonEventType
+		chime     ChimeInfo
+		expected  events.ButtonEventType
+		dropped   bool
+	}{
+		{events.ButtonPressed, withoutButtonEvents, events.ButtonPressed, false},
+		{events.ButtonReleased, withoutButtonEvents, events.ButtonReleased, false},
+		{events.ButtonStuck, withoutButtonEvents, events.ButtonReleased, false},
+		{events.ButtonShortPress, withoutButtonEvents, 0, true},
+		{events.ButtonLongPress, withoutButtonEvents, 0, true},
+		{events.ButtonDoublePress, withoutButtonEvents, 0, true},
+		{events.ButtonStuck, withButtonEvents, events.ButtonStuck, false},
+		{events.ButtonLongPress, withButtonEvents, events.ButtonLongPress, false},
+	}
+	for _, testCase := range testCases {
+		event := events.NewButtonEvent(testCase.eventType, "test")
+		event.Door = "front"
+		chimeEvent := eventForChime(testCase.chime, event)
+		name := events.TypeToString(testCase.eventType)
+		if testCase.dropped {
+			if chimeEvent != nil {
+				t.Errorf("%s (features %v): expected no event, got %#v", name, testCase.chime.Features, chimeEvent)
+			}
+			continue
+		}
+		buttonEvent, ok := chimeEvent.(*events.ButtonEvent)
+		if !ok {
+			t.Errorf("%s (features %v): expected a button event, got %#v", name, testCase.chime.Features, chimeEvent)
+			continue
+		}
+		if buttonEvent.ButtonEventType != testCase.expected || buttonEvent.Door != "front" || buttonEvent.Source != "test" {
+			t.Errorf("%s (features %v): expected a %s event for the same door and source, got %#v", name, testCase.chime.Features, events.TypeToString(testCase.expected), buttonEvent)
+		}
+	}
+}
+
+func TestBroadcastStuckToChimeWithoutButtonEvents(t *testing.T) {
+	b, _ := newTestBellPush(t)
+	chimeEvents := make(chan events.Event, 10)
+	b.SetChime("kitchen", ChimeInfo{Events: chimeEvents, Features: []string{events.FeatureAck}})
+
+	for _, eventType := range []events.ButtonEventType{events.ButtonPressed, events.ButtonStuck, events.ButtonReleased, events.ButtonLongPress} {
+		if err := b.BroadcastEvent(events.NewButtonEvent(eventType, "test")); err != nil {
+			t.Fatalf("BroadcastEvent returned an error: %v", err)
+		}
+	}
+	close(chimeEvents)
+	var received []events.ButtonEventType
+	for event := range chimeEvents {
+		received = append(received, event.(*events.ButtonEvent).ButtonEventType)
+	}
+	expected := []events.ButtonEventType{events.ButtonPressed, events.ButtonReleased, events.ButtonReleased}
+	if buttonTypesString(received) != buttonTypesString(expected) {
+		t.Errorf("expected %s, got %s", buttonTypesString(expected), buttonTypesString(received))
+	}
+}
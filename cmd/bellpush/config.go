@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/stuartleeks/pi-bell/cmd/bellpush/bellpush"
+	"github.com/stuartleeks/pi-bell/cmd/bellpush/failover"
+	"github.com/stuartleeks/pi-bell/cmd/bellpush/notifications"
+	"github.com/stuartleeks/pi-bell/internal/pkg/configfile"
+	"github.com/stuartleeks/pi-bell/internal/pkg/logging"
+	"github.com/stuartleeks/pi-bell/internal/pkg/mqttutils"
+	"github.com/stuartleeks/pi-bell/internal/pkg/telemetry"
+)
+
+// Config holds the bellpush settings. See scripts/bellpush.yaml for an example config file
+type Config struct {
+	// ListenAddress is the address for the HTTP server
+	ListenAddress string `yaml:"listenAddress" env:"LISTEN_ADDRESS"`
+	// DoorName is used in notifications and MQTT (defaults to the hostname)
+	DoorName      string `yaml:"doorName" env:"DOOR_NAME"`
+	DisableGPIO   bool   `yaml:"disableGpio" env:"DISABLE_GPIO"`
+	DisableWebcam bool   `yaml:"disableWebcam" env:"DISABLE_WEBCAM"`
+	// StateFile is where state (e.g. chime snoozes) is saved on shutdown (disabled if empty)
+	StateFile string `yaml:"stateFile" env:"STATE_FILE"`
+	// ShutdownTimeout is how long to wait for connections to close and background work to stop on shutdown
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout" env:"SHUTDOWN_TIMEOUT"`
+	// StuckButtonTimeout is how long the button can be held before it is treated as stuck (0 to disable)
+	StuckButtonTimeout time.Duration `yaml:"stuckButtonTimeout" env:"STUCK_BUTTON_TIMEOUT"`
+	// MissedRingWindow is how long rings are recorded for a disconnected chime so that it can be told about them
+	// when it reconnects (0 to disable)
+	MissedRingWindow time.Duration `yaml:"missedRingWindow" env:"MISSED_RING_WINDOW"`
+
+	Log           logging.Config        `yaml:"log"`
+	Telemetry     telemetry.Config      `yaml:"telemetry"`
+	MDNS          MDNSConfig            `yaml:"mdns"`
+	MQTT          MQTTConfig            `yaml:"mqtt"`
+	Notifications notifications.Config  `yaml:"notifications"`
+	Failover      failover.Config       `yaml:"failover"`
+	Button        bellpush.ButtonConfig `yaml:"button"`
+}
+
+// MDNSConfig holds the settings for advertising the bellpush via mDNS/DNS-SD so that chimes can discover it
+type MDNSConfig struct {
+	Enabled bool `yaml:"enabled" env:"MDNS_ENABLED"`
+	// Instance is the advertised service name (defaults to the door name)
+	Instance string `yaml:"instance" env:"MDNS_INSTANCE"`
+}
+
+// MQTTConfig holds the settings for the MQTT bridge
+type MQTTConfig struct {
+	mqttutils.Config `yaml:",inline"`
+	// NodeID identifies the bellpush in topics (defaults to the hostname)
+	NodeID          string        `yaml:"nodeId" env:"MQTT_NODE_ID"`
+	DiscoveryPrefix string        `yaml:"discoveryPrefix" env:"MQTT_DISCOVERY_PREFIX"`
+	SnoozeDuration  time.Duration `yaml:"snoozeDuration" env:"MQTT_SNOOZE_DURATION"`
+}
+
+// reloadableSettings are the settings that are applied when the config is reloaded on SIGHUP
+var reloadableSettings = []string{"log", "notifications", "button"}
+
+func defaultConfig() Config {
+	return Config{
+		ListenAddress:      "0.0.0.0:8080",
+		ShutdownTimeout:    10 * time.Second,
+		StuckButtonTimeout: bellpush.DefaultStuckButtonTimeout,
+		MissedRingWindow:   bellpush.DefaultMissedRingWindow,
+		MDNS: MDNSConfig{
+			Enabled: true,
+		},
+		MQTT: MQTTConfig{
+			Config: mqttutils.Config{
+				TopicPrefix: mqttutils.DefaultTopicPrefix,
+			},
+			DiscoveryPrefix: "homeassistant",
+			SnoozeDuration:  1 * time.Hour,
+		},
+		Notifications: notifications.DefaultConfig(),
+		Failover:      failover.DefaultConfig(),
+		Button:        bellpush.DefaultButtonConfig(),
+	}
+}
+
+// loadConfig loads the config file at path (if not empty) on top of the defaults and applies
+// environment variable and flag overrides before validating the result
+func loadConfig(path string, listenAddressFlag string) (Config, error) {
+	config := defaultConfig()
+	if path != "" {
+		if err := configfile.Load(path, &config); err != nil {
+			return config, err
+		}
+	}
+	if err := configfile.ApplyEnv(&config); err != nil {
+		return config, err
+	}
+	if listenAddressFlag != "" {
+		config.ListenAddress = listenAddressFlag
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return config, fmt.Errorf("failed to get hostname: %w", err)
+	}
+	if config.DoorName == "" {
+		config.DoorName = hostname
+	}
+	if config.MDNS.Instance == "" {
+		config.MDNS.Instance = config.DoorName
+	}
+	if config.MQTT.NodeID == "" {
+		config.MQTT.NodeID = hostname
+	}
+	if config.Notifications.HomePageURL == "" {
+		port := "8080"
+		if _, listenPort, err := net.SplitHostPort(config.ListenAddress); err == nil {
+			port = listenPort
+		}
+		config.Notifications.HomePageURL = fmt.Sprintf("http://%s/", net.JoinHostPort(hostname, port))
+	}
+
+	return config, config.validate()
+}
+
+func (c Config) validate() error {
+	errs := &configfile.Errors{}
+	if _, _, err := net.SplitHostPort(c.ListenAddress); err != nil {
+		errs.Add("listenAddress", "invalid address %q: expected host:port, e.g. 0.0.0.0:8080", c.ListenAddress)
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs.Add("shutdownTimeout", "must be greater than zero")
+	}
+	if c.StuckButtonTimeout < 0 {
+		errs.Add("stuckButtonTimeout", "must not be negative")
+	}
+	if c.MissedRingWindow < 0 {
+		errs.Add("missedRingWindow", "must not be negative")
+	}
+	c.Log.Validate(errs, "log")
+	c.Telemetry.Validate(errs, "telemetry")
+	c.MQTT.Validate(errs, "mqtt")
+	if c.MQTT.Enabled() && c.MQTT.SnoozeDuration <= 0 {
+		errs.Add("mqtt.snoozeDuration", "must be greater than zero")
+	}
+	c.Notifications.Validate(errs, "notifications")
+	c.Failover.Validate(errs, "failover")
+	c.Button.Validate(errs, "button")
+	return errs.Err()
+}
@@ -0,0 +1,60 @@
+package failover
+
+import (
+	"net"
+	"time"
+
+	"github.com/stuartleeks/pi-bell/internal/pkg/configfile"
+)
+
+const (
+	// RolePrimary is the bellpush that leads whenever it is running
+	RolePrimary = "primary"
+	// RoleStandby is the bellpush that takes over when the primary's heartbeat stops
+	RoleStandby = "standby"
+)
+
+// Config holds the settings for running two bellpushes (wired to the same button) as an active/standby pair
+type Config struct {
+	// Peer is the address (host:port) of the other bellpush in the pair. Failover is disabled if this is empty
+	Peer string `yaml:"peer" env:"FAILOVER_PEER"`
+	// Role is RolePrimary or RoleStandby
+	Role string `yaml:"role" env:"FAILOVER_ROLE"`
+	// HeartbeatInterval is how often the peer is polled for its heartbeat
+	HeartbeatInterval time.Duration `yaml:"heartbeatInterval" env:"FAILOVER_HEARTBEAT_INTERVAL"`
+	// HeartbeatTimeout is how long the standby waits without a heartbeat from the primary before taking over
+	HeartbeatTimeout time.Duration `yaml:"heartbeatTimeout" env:"FAILOVER_HEARTBEAT_TIMEOUT"`
+}
+
+// DefaultConfig returns the default failover settings (with failover disabled)
+func DefaultConfig() Config {
+	return Config{
+		Role:              RolePrimary,
+		HeartbeatInterval: 1 * time.Second,
+		HeartbeatTimeout:  5 * time.Second,
+	}
+}
+
+// Enabled returns true if a peer has been configured
+func (c Config) Enabled() bool {
+	return c.Peer != ""
+}
+
+// Validate checks the settings, adding any problems to errs
+func (c Config) Validate(errs *configfile.Errors, path string) {
+	if !c.Enabled() {
+		return
+	}
+	if _, _, err := net.SplitHostPort(c.Peer); err != nil {
+		errs.Add(configfile.Join(path, "peer"), "invalid address %q: expected host:port, e.g. pibell-2:8080", c.Peer)
+	}
+	if c.Role != RolePrimary && c.Role != RoleStandby {
+		errs.Add(configfile.Join(path, "role"), "invalid role %q (expected %s or %s)", c.Role, RolePrimary, RoleStandby)
+	}
+	if c.HeartbeatInterval <= 0 {
+		errs.Add(configfile.Join(path, "heartbeatInterval"), "must be greater than zero")
+	}
+	if c.HeartbeatTimeout <= c.HeartbeatInterval {
+		errs.Add(configfile.Join(path, "heartbeatTimeout"), "must be greater than heartbeatInterval")
+	}
+}
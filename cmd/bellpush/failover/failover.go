@@ -0,0 +1,190 @@
+// Package failover runs two bellpushes that are wired to the same button as an active/standby pair.
+//
+// Each bellpush polls the other's /failover endpoint for a heartbeat, which carries its role and the
+// chimes it knows about with their snoozes. The primary leads whenever it is running. The standby takes
+// over leadership when it hasn't had a heartbeat from the primary for the heartbeat timeout, and hands
+// back to the primary when its heartbeats resume.
+//
+// Only the leader broadcasts button events and accepts chime connections: the standby rejects chimes
+// so that they fail over to the next address in their list, and closes its chime connections when it
+// steps down. The chime snoozes from the heartbeats are applied when the chimes fail over
+package failover
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stuartleeks/pi-bell/cmd/bellpush/bellpush"
+	"github.com/stuartleeks/pi-bell/internal/pkg/logging"
+)
+
+var logger = logging.New("component", "failover")
+
+// Heartbeat is the state that each bellpush in the pair publishes on /failover
+type Heartbeat struct {
+	Role   string `json:"role"`
+	Leader bool   `json:"leader"`
+	Door   string `json:"door"`
+	// Chimes is the snooze expiry for each chime known to the bellpush (zero if not snoozed)
+	Chimes map[string]time.Time `json:"chimes"`
+	Time   time.Time            `json:"time"`
+}
+
+// Manager exchanges heartbeats with the peer and sets whether the bellpush is the leader
+type Manager struct {
+	config   Config
+	bellPush *bellpush.BellPush
+	client   *http.Client
+
+	mutex sync.Mutex
+	// lastPrimaryHeartbeat is when the standby last heard from the primary
+	lastPrimaryHeartbeat time.Time
+	peer                 Heartbeat
+	peerSeen             time.Time
+	listeners            []func(leader bool)
+}
+
+// NewManager returns a Manager for the bellpush. The primary starts as the leader and the standby
+// waits for the heartbeat timeout before taking over (in case the primary is starting up)
+func NewManager(config Config, bellPush *bellpush.BellPush) *Manager {
+	m := &Manager{
+		config:               config,
+		bellPush:             bellPush,
+		client:               &http.Client{Timeout: config.HeartbeatInterval},
+		lastPrimaryHeartbeat: time.Now(),
+	}
+	leader := config.Role == RolePrimary
+	bellPush.SetLeader(leader)
+	setLeaderGauge(leader)
+	return m
+}
+
+// OnLeadershipChange registers a function that is called when the bellpush becomes the leader or steps down.
+// Listeners are called from the heartbeat loop so should not block
+func (m *Manager) OnLeadershipChange(listener func(leader bool)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.listeners = append(m.listeners, listener)
+}
+
+// Run polls the peer for heartbeats and updates the leadership until ctx is done
+func (m *Manager) Run(ctx context.Context) {
+	logger.Info("Starting failover", "role", m.config.Role, "peer", m.config.Peer, "leader", m.bellPush.IsLeader())
+	ticker := time.NewTicker(m.config.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.poll(ctx); err != nil {
+				logger.Debug("Failed to get heartbeat from peer", "peer", m.config.Peer, "err", err)
+			}
+			m.updateLeadership()
+		}
+	}
+}
+
+func (m *Manager) poll(ctx context.Context) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+m.config.Peer+"/failover", nil)
+	if err != nil {
+		return err
+	}
+	response, err := m.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+	var heartbeat Heartbeat
+	if err = json.NewDecoder(response.Body).Decode(&heartbeat); err != nil {
+		return fmt.Errorf("invalid heartbeat: %w", err)
+	}
+
+	m.mutex.Lock()
+	now := time.Now()
+	if m.peerSeen.IsZero() || now.Sub(m.peerSeen) > m.config.HeartbeatTimeout {
+		logger.Info("Peer is up", "peer", m.config.Peer, "role", heartbeat.Role, "leader", heartbeat.Leader, "door", heartbeat.Door)
+		if heartbeat.Role == m.config.Role {
+			logger.Warn("Peer has the same role - check the failover.role settings", "peer", m.config.Peer, "role", heartbeat.Role)
+		}
+	}
+	m.peer = heartbeat
+	m.peerSeen = now
+	if heartbeat.Role == RolePrimary {
+		m.lastPrimaryHeartbeat = now
+	}
+	m.mutex.Unlock()
+
+	m.bellPush.ImportPeerSnoozes(heartbeat.Chimes)
+	return nil
+}
+
+func (m *Manager) updateLeadership() {
+	m.mutex.Lock()
+	leader := m.config.Role == RolePrimary || time.Since(m.lastPrimaryHeartbeat) > m.config.HeartbeatTimeout
+	lastPrimaryHeartbeat := m.lastPrimaryHeartbeat
+	listeners := m.listeners
+	m.mutex.Unlock()
+
+	if leader == m.bellPush.IsLeader() {
+		return
+	}
+	if leader {
+		logger.Warn("No heartbeat from primary - taking over as leader", "peer", m.config.Peer, "lastHeartbeat", lastPrimaryHeartbeat)
+		failoversCounter.Inc()
+	} else {
+		logger.Info("Primary is back - stepping down to standby", "peer", m.config.Peer)
+	}
+	m.bellPush.SetLeader(leader)
+	setLeaderGauge(leader)
+	for _, listener := range listeners {
+		listener(leader)
+	}
+}
+
+// ServeHTTP serves the heartbeat for the peer
+func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	heartbeat := Heartbeat{
+		Role:   m.config.Role,
+		Leader: m.bellPush.IsLeader(),
+		Door:   m.bellPush.GetDoorName(),
+		Chimes: m.bellPush.GetSnoozes(),
+		Time:   time.Now(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(heartbeat); err != nil {
+		logger.Error("Error writing heartbeat", "err", err)
+	}
+}
+
+// Status describes the failover state for `systemctl status`, e.g. "standby, primary up with chimes [kitchen]"
+func (m *Manager) Status() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	role := m.config.Role
+	if m.bellPush.IsLeader() {
+		role += " (leader)"
+	}
+	if m.peerSeen.IsZero() || time.Since(m.peerSeen) > m.config.HeartbeatTimeout {
+		return fmt.Sprintf("%s, peer down", role)
+	}
+	chimes := make([]string, 0, len(m.peer.Chimes))
+	for name := range m.peer.Chimes {
+		chimes = append(chimes, name)
+	}
+	sort.Strings(chimes)
+	return fmt.Sprintf("%s, %s up with chimes [%s]", role, m.peer.Role, strings.Join(chimes, ","))
+}
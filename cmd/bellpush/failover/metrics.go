@@ -0,0 +1,25 @@
+package failover
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	leaderGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "pibell_bellpush_failover_leader",
+		Help: "Whether the bellpush is the leader of its failover pair (1) or the standby (0)",
+	})
+	failoversCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pibell_bellpush_failover_takeovers_total",
+		Help: "The number of times the standby has taken over as leader",
+	})
+)
+
+func setLeaderGauge(leader bool) {
+	if leader {
+		leaderGauge.Set(1)
+	} else {
+		leaderGauge.Set(0)
+	}
+}
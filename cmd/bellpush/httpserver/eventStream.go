@@ -0,0 +1,123 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/stuartleeks/pi-bell/internal/pkg/events"
+)
+
+const (
+	sseEventSnapshot  = "snapshot"
+	sseKeepAliveDelay = 30 * time.Second
+)
+
+type sseMessage struct {
+	eventType string
+	data      []byte
+}
+
+// eventStream fans out bellpush events to Server-Sent Events clients
+type eventStream struct {
+	subscribersLock sync.Mutex
+	subscribers     map[chan sseMessage]bool
+}
+
+func newEventStream() *eventStream {
+	return &eventStream{
+		subscribers: make(map[chan sseMessage]bool),
+	}
+}
+
+func (s *eventStream) subscribe() chan sseMessage {
+	s.subscribersLock.Lock()
+	defer s.subscribersLock.Unlock()
+	subscriber := make(chan sseMessage, 50)
+	s.subscribers[subscriber] = true
+	return subscriber
+}
+
+func (s *eventStream) unsubscribe(subscriber chan sseMessage) {
+	s.subscribersLock.Lock()
+	defer s.subscribersLock.Unlock()
+	delete(s.subscribers, subscriber)
+}
+
+// publish sends a message to all subscribers. Slow subscribers miss messages rather than blocking the bellpush
+func (s *eventStream) publish(message sseMessage) {
+	s.subscribersLock.Lock()
+	defer s.subscribersLock.Unlock()
+	for subscriber := range s.subscribers {
+		select {
+		case subscriber <- message:
+		default:
+			logger.Warn("SSE subscriber queue full - dropping message", "eventType", message.eventType)
+		}
+	}
+}
+
+// handleEvent is registered as a listener on the bellpush
+func (s *eventStream) handleEvent(chimeName string, event events.Event) {
+	data, err := json.Marshal(map[string]interface{}{
+		"chimeName": chimeName,
+		"event":     event,
+	})
+	if err != nil {
+		logger.WithCorrelationID(event.GetID()).Error("Error converting event to JSON", "err", err)
+		return
+	}
+	s.publish(sseMessage{eventType: event.GetType(), data: data})
+}
+
+func (b *BellPushHTTPServer) httpEventStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	subscriber := b.eventStream.subscribe()
+	defer b.eventStream.unsubscribe(subscriber)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAliveTicker := time.NewTicker(sseKeepAliveDelay)
+	defer keepAliveTicker.Stop()
+	// check for new webcam frames so that the page can update the image without polling
+	snapshotTicker := time.NewTicker(1 * time.Second)
+	defer snapshotTicker.Stop()
+	lastFrameTime := b.BellPush.GetWebcamFrameTime()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-b.shuttingDown:
+			return
+		case message := <-subscriber:
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", message.eventType, message.data)
+		case <-snapshotTicker.C:
+			frameTime := b.BellPush.GetWebcamFrameTime()
+			if frameTime.Equal(lastFrameTime) {
+				continue
+			}
+			lastFrameTime = frameTime
+			_, err = fmt.Fprintf(w, "event: %s\ndata: {\"time\":%q}\n\n", sseEventSnapshot, frameTime.Format(time.RFC3339Nano))
+		case <-keepAliveTicker.C:
+			_, err = fmt.Fprintf(w, ": keep-alive\n\n")
+		}
+		if err != nil {
+			logger.Info("Error writing to event stream", "err", err)
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -1,23 +1,38 @@
 package httpserver
 
 import (
+	"context"
 	"embed"
 	"encoding/json"
 	"fmt"
 	"html/template"
-	"log"
+	"net"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
-	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/stuartleeks/pi-bell/cmd/bellpush/bellpush"
+	"github.com/stuartleeks/pi-bell/cmd/bellpush/notifications"
 	"github.com/stuartleeks/pi-bell/internal/pkg/events"
+	"github.com/stuartleeks/pi-bell/internal/pkg/logging"
+	"github.com/stuartleeks/pi-bell/internal/pkg/telemetry"
 	"github.com/stuartleeks/pi-bell/internal/pkg/timeutils"
+	"github.com/stuartleeks/pi-bell/internal/pkg/version"
 )
 
-const messageHello string = "hello"
+// closeTimeout is how long to wait for a chime to acknowledge the close frame on shutdown
+const closeTimeout = 1 * time.Second
+
+// maxCloseReasonLength is the longest reason that fits in a close frame (a control frame payload is 125 bytes,
+// including the 2 byte code)
+const maxCloseReasonLength = 123
+
+var logger = logging.New("component", "httpserver")
 
 var initTime time.Time = timeutils.MustTimeParse(time.RFC3339, "1900-01-01T00:00:00Z")
 
@@ -31,26 +46,44 @@ var f embed.FS
 var templates = template.Must(template.ParseFS(f, "templates/*"))
 
 type BellPushHTTPServer struct {
-	telemetryClient appinsights.TelemetryClient
+	telemetryClient telemetry.Client
 	BellPush        *bellpush.BellPush
+	Notifications   *notifications.Dispatcher
+	eventStream     *eventStream
+	// seenChimes tracks the names of chimes that have connected (to count reconnects)
+	seenChimes sync.Map
+
+	server   *http.Server
+	listener net.Listener
+	// shuttingDown is closed by Shutdown to end the long-lived websocket and event stream connections
+	shuttingDown     chan struct{}
+	shutdownOnce     sync.Once
+	chimeConnections sync.WaitGroup
 }
 
-func NewBellPushHTTPServer(bellPush *bellpush.BellPush, telemetryClient appinsights.TelemetryClient) *BellPushHTTPServer {
-	return &BellPushHTTPServer{
+func NewBellPushHTTPServer(bellPush *bellpush.BellPush, notificationDispatcher *notifications.Dispatcher, telemetryClient telemetry.Client) *BellPushHTTPServer {
+	server := &BellPushHTTPServer{
 		telemetryClient: telemetryClient,
 		BellPush:        bellPush,
+		Notifications:   notificationDispatcher,
+		eventStream:     newEventStream(),
+		server:          &http.Server{},
+		shuttingDown:    make(chan struct{}),
 	}
+	bellPush.AddEventListener(server.eventStream.handleEvent)
+	return server
 }
 
-func (b *BellPushHTTPServer) httpHomePage(w http.ResponseWriter, _ *http.Request) {
-	type chimeModel struct {
-		Name         string
-		SnoozeExpiry string
-	}
+type chimeModel struct {
+	Name         string `json:"name"`
+	SnoozeExpiry string `json:"snoozeExpiry"`
+}
+
+func (b *BellPushHTTPServer) getChimeModels() []chimeModel {
 	chimeInfos := []chimeModel{}
 	for name, chime := range b.BellPush.GetChimes() {
 		snoozeExpiry := ""
-		if chime.SnoozeEnd.After(time.Now()) {
+		if chime.IsSnoozed() {
 			snoozeExpiry = chime.SnoozeEnd.Format(time.RFC3339)
 		}
 		c := chimeModel{
@@ -59,98 +92,122 @@ func (b *BellPushHTTPServer) httpHomePage(w http.ResponseWriter, _ *http.Request
 		}
 		chimeInfos = append(chimeInfos, c)
 	}
+	sort.Slice(chimeInfos, func(i, j int) bool { return chimeInfos[i].Name < chimeInfos[j].Name })
+	return chimeInfos
+}
+
+func (b *BellPushHTTPServer) httpHomePage(w http.ResponseWriter, _ *http.Request) {
 	if err := templates.ExecuteTemplate(w, "index.html", map[string]interface{}{
-		"Title":  "Home Page",
-		"Chimes": chimeInfos,
+		"Title":     "Home Page",
+		"Chimes":    b.getChimeModels(),
+		"Notifiers": b.Notifications.NotifierNames(),
 	}); err != nil {
-		log.Printf("Error executing template: %v\n", err)
+		logger.Error("Error executing template", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
+func (b *BellPushHTTPServer) httpChimes(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(b.getChimeModels()); err != nil {
+		logger.Error("Error writing chimes", "err", err)
+	}
+}
 func (b *BellPushHTTPServer) httpSnooze(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		log.Printf("Invalid method: %s\n", r.Method)
+		logger.Warn("Invalid method", "path", r.URL.Path, "method", r.Method)
 		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
 		return
 	}
 
 	name := r.URL.Query().Get(("name"))
 	if name == "" {
-		log.Printf("Missing name\n")
+		logger.Warn("Missing name", "path", r.URL.Path)
 		http.Error(w, "Missing name", http.StatusBadRequest)
 		return
 	}
 	durationString := r.URL.Query().Get(("duration"))
 	if durationString == "" {
-		log.Printf("Missing duration\n")
+		logger.Warn("Missing duration", "path", r.URL.Path)
 		http.Error(w, "Missing duration", http.StatusBadRequest)
 		return
 	}
 	duration, err := time.ParseDuration(durationString)
 	if err != nil {
-		log.Printf("Invalid duration: %v\n", err)
+		logger.Warn("Invalid duration", "path", r.URL.Path, "err", err)
 		http.Error(w, fmt.Sprintf("Invalid duration: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("Snoozing chime %q for %f minutes\n", name, duration.Minutes())
+	logger.Info("Snoozing chime", "chime", name, "duration", duration)
 
-	chime, ok := b.BellPush.GetChime(name)
-	if !ok {
-		log.Printf("Unknown chime: %q\n", name)
+	if _, ok := b.BellPush.GetChime(name); !ok {
+		logger.Warn("Unknown chime", "chime", name)
 		http.Error(w, fmt.Sprintf("Unknown chime: %q", name), http.StatusBadRequest)
 		return
 	}
 
-	chime.SnoozeEnd = time.Now().Add(duration)
-	b.BellPush.SetChime(name, chime)
-
-	err = b.BellPush.SendEvent(name, events.NewSnoozeEvent(chime.SnoozeEnd))
+	err = b.BellPush.SnoozeChime(name, duration)
 	if err != nil {
-		log.Printf("Error sending snooze event: %v\n", err)
+		logger.Error("Error sending snooze event", "chime", name, "err", err)
 		http.Error(w, fmt.Sprintf("Error sending snooze event: %v", err), http.StatusInternalServerError)
 		return
 	}
 }
 func (b *BellPushHTTPServer) httpUnSnooze(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		log.Printf("Invalid method: %s\n", r.Method)
+		logger.Warn("Invalid method", "path", r.URL.Path, "method", r.Method)
 		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
 		return
 	}
 
 	name := r.URL.Query().Get(("name"))
 	if name == "" {
-		log.Printf("Missing name\n")
+		logger.Warn("Missing name", "path", r.URL.Path)
 		http.Error(w, "Missing name", http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("UnSnoozing chime %q\n", name)
+	logger.Info("UnSnoozing chime", "chime", name)
 
-	chime, ok := b.BellPush.GetChime(name)
-	if !ok {
-		log.Printf("Unknown chime: %q\n", name)
+	if _, ok := b.BellPush.GetChime(name); !ok {
+		logger.Warn("Unknown chime", "chime", name)
 		http.Error(w, fmt.Sprintf("Unknown chime: %q", name), http.StatusBadRequest)
 		return
 	}
 
-	chime.SnoozeEnd = initTime
-	b.BellPush.SetChime(name, chime)
-
-	err := b.BellPush.SendEvent(name, events.NewUnSnoozeEvent())
+	err := b.BellPush.UnSnoozeChime(name)
 	if err != nil {
-		log.Printf("Error sending unsnooze event: %v\n", err)
+		logger.Error("Error sending unsnooze event", "chime", name, "err", err)
 		http.Error(w, fmt.Sprintf("Error sending unsnooze event: %v", err), http.StatusInternalServerError)
 		return
 	}
 }
 
-func (b *BellPushHTTPServer) httpPing(w http.ResponseWriter, _ *http.Request) {
-	if b.telemetryClient != nil {
-		b.telemetryClient.TrackEvent("ping")
-		b.telemetryClient.Channel().Flush()
+func (b *BellPushHTTPServer) httpNotificationTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		logger.Warn("Invalid method", "path", r.URL.Path, "method", r.Method)
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.URL.Query().Get(("name"))
+	if name == "" {
+		logger.Warn("Missing name", "path", r.URL.Path)
+		http.Error(w, "Missing name", http.StatusBadRequest)
+		return
 	}
+
+	logger.Info("Sending test notification", "notifier", name)
+	err := b.Notifications.SendTest(r.Context(), name)
+	if err != nil {
+		logger.Error("Error sending test notification", "notifier", name, "err", err)
+		http.Error(w, fmt.Sprintf("Error sending test notification: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (b *BellPushHTTPServer) httpPing(w http.ResponseWriter, _ *http.Request) {
+	b.telemetryClient.TrackEvent("ping", nil)
 	w.Header().Add("Content-Type", "text/html")
 	_, _ = w.Write([]byte("<html><body><h1>pong</h1></body></html>"))
 }
@@ -159,21 +216,21 @@ func (b *BellPushHTTPServer) httpPing(w http.ResponseWriter, _ *http.Request) {
 func (b *BellPushHTTPServer) httpButtonPush(w http.ResponseWriter, _ *http.Request) {
 	err := b.BellPush.BroadcastEvent(events.NewButtonEvent(events.ButtonPressed, "web"))
 	if err != nil {
-		log.Printf("Error broadcasting button pressed event: %v\n", err)
+		logger.Error("Error broadcasting button pressed event", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 func (b *BellPushHTTPServer) httpButtonRelease(w http.ResponseWriter, _ *http.Request) {
 	err := b.BellPush.BroadcastEvent(events.NewButtonEvent(events.ButtonReleased, "web"))
 	if err != nil {
-		log.Printf("Error broadcasting button released event: %v\n", err)
+		logger.Error("Error broadcasting button released event", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 func (b *BellPushHTTPServer) httpButtonPushRelease(w http.ResponseWriter, _ *http.Request) {
 	err := b.BellPush.BroadcastEvent(events.NewButtonEvent(events.ButtonPressed, "web"))
 	if err != nil {
-		log.Printf("Error broadcasting button pressed event: %v\n", err)
+		logger.Error("Error broadcasting button pressed event", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 
@@ -181,7 +238,7 @@ func (b *BellPushHTTPServer) httpButtonPushRelease(w http.ResponseWriter, _ *htt
 
 	err = b.BellPush.BroadcastEvent(events.NewButtonEvent(events.ButtonReleased, "web"))
 	if err != nil {
-		log.Printf("Error broadcasting button released event: %v\n", err)
+		logger.Error("Error broadcasting button released event", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
@@ -191,6 +248,14 @@ var connectCounter int32
 // Set up web socket endpoint for pushing doorbell notifications
 func (b *BellPushHTTPServer) httpDoorbellNotifications(w http.ResponseWriter, r *http.Request) {
 	connectID := atomic.AddInt32(&connectCounter, 1)
+	connLogger := logger.With("connection", connectID)
+	if !b.BellPush.IsLeader() {
+		// chimes fail over to the next address in their list (the leader)
+		connLogger.Info("Standby - rejecting chime connection", "remoteAddr", r.RemoteAddr)
+		http.Error(w, "standby bellpush - connect to the leader", http.StatusServiceUnavailable)
+		return
+	}
+	steppedDown := b.BellPush.SteppedDown()
 	// Upgrade to websocket connection
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -199,34 +264,41 @@ func (b *BellPushHTTPServer) httpDoorbellNotifications(w http.ResponseWriter, r
 	}
 	defer conn.Close()
 
-	// Read "hello" message from client
+	// Read the hello message from the chime and negotiate the protocol
 	t, p, err := conn.ReadMessage()
 	if err != nil {
-		log.Printf("%d:Error reading message: %v\n", connectID, err)
+		connLogger.Warn("Error reading hello message", "err", err)
 		return
 	}
 	if t != websocket.TextMessage {
-		log.Printf("%d:Unexpected message type: %d\n", connectID, t)
+		connLogger.Warn("Unexpected websocket message type", "websocketMessageType", t)
 		return
 	}
-	log.Printf("%d:Message type: %d; Message payload: %s\n", connectID, t, p)
-	var dat map[string]interface{}
-	if err = json.Unmarshal(p, &dat); err != nil {
-		log.Printf("%d:Error unmarshalling message: %v\n", connectID, err)
+	connLogger.Debug("Received hello message", "payload", string(p))
+	hello, err := events.ParseHelloJSON(p)
+	if err != nil {
+		connLogger.Warn("Invalid hello message", "err", err)
+		closeWithReason(connLogger, conn, websocket.CloseProtocolError, fmt.Sprintf("invalid hello message: %v", err))
 		return
 	}
-	messageType, ok := dat["messageType"].(string)
-	if !ok {
-		log.Printf("%d:No messageType in message\n", connectID)
-	}
-	if messageType != messageHello {
-		log.Printf("%d:Unexpected messageType: %s\n", connectID, messageType)
+	senderName := hello.SenderName
+	connLogger = connLogger.With("chime", senderName)
+	protocolVersion, features, err := events.Negotiate(hello)
+	if err != nil {
+		connLogger.Warn("Rejecting chime with incompatible protocol", "chimeVersion", hello.SoftwareVersion, "err", err)
+		incompatibleChimesCounter.WithLabelValues(senderName).Inc()
+		closeWithReason(connLogger, conn, websocket.CloseProtocolError, err.Error())
 		return
 	}
-	senderName, ok := dat["senderName"].(string)
-	if !ok {
-		log.Printf("%d:No senderName in message\n", connectID)
-		return
+	connLogger.Info("Negotiated protocol", "protocolVersion", protocolVersion, "features", strings.Join(features, ","),
+		"chimeVersion", hello.SoftwareVersion, "capabilities", strings.Join(hello.Capabilities, ","))
+	if protocolVersion >= 2 {
+		// version 1 chimes don't expect a welcome message
+		welcome := events.NewWelcome(protocolVersion, features, version.Version, b.BellPush.GetDoorName())
+		if err = conn.WriteJSON(welcome); err != nil {
+			connLogger.Warn("Error sending welcome message", "err", err)
+			return
+		}
 	}
 
 	// Read from message channel and write back to client
@@ -236,80 +308,246 @@ func (b *BellPushHTTPServer) httpDoorbellNotifications(w http.ResponseWriter, r
 	// 	log.Printf("Client already connected with name: %s\n", senderName)
 	// 	return
 	// }
-	var chime bellpush.ChimeInfo
+	b.chimeConnections.Add(1)
+	defer b.chimeConnections.Done()
+
+	chime, ok := b.BellPush.GetChime(senderName)
 	sendSnoozeEvent := false
-	if chime, ok = b.BellPush.GetChime(senderName); ok {
+	if ok {
 		// Send stop processing event to existing client loop (before replacing with new loop)
-		chime.Events <- events.NewStopProcessingEvent()
+		b.BellPush.StopChimeEvents(senderName, chime.Events)
 		chime.Events = outputChannel // replace with new channel for new loop
 		sendSnoozeEvent = chime.SnoozeEnd.After(time.Now())
-		log.Printf("%d:Existing client with name %q. SnoozeEnd: %s, sendSnoozeEvent: %v\n", connectID, senderName, chime.SnoozeEnd.Format(time.RFC3339), sendSnoozeEvent)
+		connLogger.Info("Replacing existing client", "snoozeEnd", chime.SnoozeEnd, "sendSnoozeEvent", sendSnoozeEvent)
 	} else {
+		// restore the snooze if the chime was snoozed before the bellpush restarted
 		chime = bellpush.ChimeInfo{
 			Events:    outputChannel,
-			SnoozeEnd: initTime,
+			SnoozeEnd: b.BellPush.TakeRestoredSnooze(senderName),
 		}
+		sendSnoozeEvent = chime.IsSnoozed()
 	}
+	chime.Version = hello.SoftwareVersion
+	chime.Features = features
 
-	log.Printf("%d:Client connected with name: %q\n", connectID, senderName)
+	connLogger.Info("Client connected")
+	websocketConnectionsCounter.WithLabelValues(senderName).Inc()
+	if _, seen := b.seenChimes.LoadOrStore(senderName, true); seen {
+		websocketReconnectsCounter.WithLabelValues(senderName).Inc()
+	}
 	b.BellPush.SetChime(senderName, chime)
 
 	if sendSnoozeEvent {
 		err = b.BellPush.SendEvent(senderName, events.NewSnoozeEvent(chime.SnoozeEnd))
 		if err != nil {
-			log.Printf("%d:Error sending snooze event: %v\n", connectID, err)
+			connLogger.Error("Error sending snooze event", "err", err)
 			return
 		}
 	}
 
+	// read from the client so that we detect disconnects without waiting for the next event to be sent
+	readErrors := make(chan error, 1)
+	go func() {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				readErrors <- err
+				return
+			}
+			b.handleChimeMessage(connLogger, senderName, message, time.Now())
+		}
+	}()
+
 	// set up send loop for client
 	for {
-		event := <-outputChannel
+		var event events.Event
+		select {
+		case event = <-outputChannel:
+		case err := <-readErrors:
+			connLogger.Warn("Error reading from client - disconnecting", "err", err)
+			b.removeChime(connLogger, senderName, outputChannel)
+			return
+		case <-b.shuttingDown:
+			// leave the chime registered so that its snooze is saved in the state file
+			closeGoingAway(connLogger, conn, readErrors, "bellpush shutting down")
+			return
+		case <-steppedDown:
+			// the chime reconnects to the leader, which gets its snooze from the failover heartbeats
+			b.BellPush.HandOverChime(senderName)
+			closeGoingAway(connLogger, conn, readErrors, "bellpush is now the standby")
+			return
+		}
 		if event.GetType() == events.EventTypeStopProcessing {
-			log.Printf("%d:Received StopProcessingEvent - exiting\n", connectID)
+			connLogger.Info("Received StopProcessingEvent - exiting")
 			break
 		}
 
-		message, err := event.ToJSON()
+		if buttonEvent, ok := event.(*events.ButtonEvent); ok {
+			// copy the event as it is shared with the other chimes
+			event = buttonEvent.WithHop(events.HopSend, time.Now())
+		}
+		eventLogger := connLogger.WithCorrelationID(event.GetID()).With("eventType", event.GetType())
+		message, err := events.ToJSON(event)
 		if err != nil {
-			log.Printf("%d:Error converting button event to JSON: %v\n", connectID, err)
+			eventLogger.Error("Error converting event to JSON", "err", err)
 			continue
 		}
-		log.Printf("*** %d:Sending message (%q): %s\n", connectID, senderName, message)
+		eventLogger.Debug("Sending message", "payload", message)
 
 		// Write message back to client
 		if err := conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
-			log.Printf("%d:***Error sending message to sender %q - disconnecting: %s\n", connectID, senderName, err)
-			b.BellPush.RemoveChime(senderName)
+			eventLogger.Warn("Error sending event - disconnecting", "err", err)
+			b.removeChime(connLogger, senderName, outputChannel)
 			return
 		}
+		eventLogger.Info("Sent event")
 	}
 }
 
-func (b *BellPushHTTPServer) httpCameraLatest(w http.ResponseWriter, _ *http.Request) {
-	if b.telemetryClient != nil {
-		b.telemetryClient.TrackEvent("cameraLatest")
-		b.telemetryClient.Channel().Flush()
+// removeChime removes the chime when its connection fails, unless it has already reconnected on a new connection
+func (b *BellPushHTTPServer) removeChime(connLogger *logging.Logger, senderName string, outputChannel chan events.Event) {
+	if !b.BellPush.RemoveChimeIfChannel(senderName, outputChannel) {
+		connLogger.Info("Chime is no longer registered with this connection - not removing")
+	}
+}
+
+// closeGoingAway sends a "going away" close frame so that the chime reconnects promptly rather than
+// waiting to detect the dropped connection, and waits briefly for the chime to close its side
+func closeGoingAway(connLogger *logging.Logger, conn *websocket.Conn, readErrors <-chan error, reason string) {
+	if !closeWithReason(connLogger, conn, websocket.CloseGoingAway, reason) {
+		return
+	}
+	select {
+	case <-readErrors:
+	case <-time.After(closeTimeout):
+	}
+}
+
+// closeWithReason sends a close frame with the code and reason (e.g. so that a chime can log why it was rejected).
+// Returns false if the close frame couldn't be sent
+func closeWithReason(connLogger *logging.Logger, conn *websocket.Conn, code int, reason string) bool {
+	connLogger.Info("Closing connection", "reason", reason)
+	// the reason has to fit in a control frame
+	if len(reason) > maxCloseReasonLength {
+		reason = reason[:maxCloseReasonLength]
+	}
+	message := websocket.FormatCloseMessage(code, reason)
+	if err := conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(closeTimeout)); err != nil {
+		connLogger.Warn("Error sending close message", "err", err)
+		return false
+	}
+	return true
+}
+
+// handleChimeMessage handles a message sent by a chime after the hello message
+func (b *BellPushHTTPServer) handleChimeMessage(connLogger *logging.Logger, chimeName string, message []byte, receivedAt time.Time) {
+	var dat map[string]interface{}
+	if err := json.Unmarshal(message, &dat); err != nil {
+		connLogger.Warn("Error unmarshalling message", "err", err)
+		return
+	}
+	switch messageType, _ := dat["messageType"].(string); messageType {
+	case events.MessageTypeLatencyReport:
+		report, err := events.ParseLatencyReportJSON(message)
+		if err != nil {
+			connLogger.Warn("Error parsing latency report", "err", err)
+			return
+		}
+		b.BellPush.RecordLatencyReport(chimeName, report, receivedAt)
+	case events.MessageTypeSnoozeRequest:
+		request, err := events.ParseSnoozeRequestJSON(message)
+		if err != nil {
+			connLogger.Warn("Error parsing snooze request", "err", err)
+			return
+		}
+		if chime, ok := b.BellPush.GetChime(chimeName); !ok || !chime.HasFeature(events.FeatureSnoozeRequest) {
+			connLogger.WithCorrelationID(request.ID).Warn("Ignoring snooze request from chime that didn't negotiate snooze requests")
+			return
+		}
+		if err = b.BellPush.HandleSnoozeRequest(chimeName, request); err != nil {
+			connLogger.WithCorrelationID(request.ID).Error("Error handling snooze request", "err", err)
+		}
+	default:
+		connLogger.Warn("Unexpected messageType", "messageType", messageType)
 	}
+}
+
+func (b *BellPushHTTPServer) httpCameraLatest(w http.ResponseWriter, _ *http.Request) {
+	b.telemetryClient.TrackEvent("cameraLatest", nil)
 	latestImage := b.BellPush.GetWebcamFrame()
 	w.Header().Add("Content-Type", "image/jpeg")
 	_, err := w.Write(latestImage)
 	if err != nil {
-		log.Printf("Error writing image: %v\n", err)
+		logger.Error("Error writing image", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
-func (b *BellPushHTTPServer) ListenAndServe(addr string) error {
+// Listen registers the handlers and starts listening on addr. Call Serve to start handling requests
+func (b *BellPushHTTPServer) Listen(addr string) error {
+	// long-lived connections aren't instrumented as their durations would skew the latency metrics
 	http.HandleFunc("/doorbell", b.httpDoorbellNotifications)
-	http.HandleFunc("/ping", b.httpPing)
-	http.HandleFunc("/", b.httpHomePage)
-	http.HandleFunc("/chime/snooze", b.httpSnooze)
-	http.HandleFunc("/chime/unsnooze", b.httpUnSnooze)
-	http.HandleFunc("/button/push", b.httpButtonPush)
-	http.HandleFunc("/button/release", b.httpButtonRelease)
-	http.HandleFunc("/button/push-release", b.httpButtonPushRelease)
-	http.HandleFunc("/camera/latest", b.httpCameraLatest)
-
-	return http.ListenAndServe(addr, nil)
+	http.HandleFunc("/events/stream", b.httpEventStream)
+	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/log/level", logging.LevelHandler())
+
+	handlers := map[string]http.HandlerFunc{
+		"/ping":                b.httpPing,
+		"/":                    b.httpHomePage,
+		"/chime/snooze":        b.httpSnooze,
+		"/chime/unsnooze":      b.httpUnSnooze,
+		"/button/push":         b.httpButtonPush,
+		"/button/release":      b.httpButtonRelease,
+		"/button/push-release": b.httpButtonPushRelease,
+		"/camera/latest":       b.httpCameraLatest,
+		"/notifications/test":  b.httpNotificationTest,
+		"/chimes":              b.httpChimes,
+	}
+	for pattern, handler := range handlers {
+		http.Handle(pattern, instrumentHandler(pattern, handler))
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	b.listener = listener
+	return nil
+}
+
+// Serve handles requests on the listener opened by Listen
+func (b *BellPushHTTPServer) Serve() error {
+	return b.server.Serve(b.listener)
+}
+
+func (b *BellPushHTTPServer) ListenAndServe(addr string) error {
+	if err := b.Listen(addr); err != nil {
+		return err
+	}
+	return b.Serve()
+}
+
+// Shutdown stops accepting connections and closes the chime websockets and event streams.
+// It waits for in-flight requests and the chime connections to finish until ctx is done.
+// ListenAndServe returns http.ErrServerClosed once Shutdown has been called
+func (b *BellPushHTTPServer) Shutdown(ctx context.Context) error {
+	b.shutdownOnce.Do(func() {
+		close(b.shuttingDown)
+	})
+	if err := b.server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to shut down HTTP server: %w", err)
+	}
+
+	// websocket connections are hijacked so aren't tracked by the http.Server
+	done := make(chan struct{})
+	go func() {
+		b.chimeConnections.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("timed out waiting for chime connections to close: %w", ctx.Err())
+	}
 }
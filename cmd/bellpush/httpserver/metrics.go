@@ -0,0 +1,35 @@
+package httpserver
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pibell_bellpush_http_request_duration_seconds",
+		Help:    "The duration of HTTP requests to the bellpush",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"handler", "method", "code"})
+	websocketConnectionsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pibell_bellpush_websocket_connections_total",
+		Help: "The number of websocket connections from chimes",
+	}, []string{"chime"})
+	websocketReconnectsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pibell_bellpush_websocket_reconnects_total",
+		Help: "The number of websocket connections from chimes that had previously connected",
+	}, []string{"chime"})
+	incompatibleChimesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pibell_bellpush_incompatible_chimes_total",
+		Help: "The number of websocket connections from chimes rejected because there wasn't a protocol version supported by both",
+	}, []string{"chime"})
+)
+
+// instrumentHandler records request durations for a handler
+func instrumentHandler(pattern string, handler http.HandlerFunc) http.Handler {
+	observer := httpRequestDuration.MustCurryWith(prometheus.Labels{"handler": pattern})
+	return promhttp.InstrumentHandlerDuration(observer, handler)
+}
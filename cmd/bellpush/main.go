@@ -1,19 +1,38 @@
 package main
 
 import (
+	"context"
 	_ "embed"
 	"flag"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/stuartleeks/pi-bell/cmd/bellpush/bellpush"
+	"github.com/stuartleeks/pi-bell/cmd/bellpush/failover"
 	"github.com/stuartleeks/pi-bell/cmd/bellpush/httpserver"
+	"github.com/stuartleeks/pi-bell/cmd/bellpush/mqttbridge"
+	"github.com/stuartleeks/pi-bell/cmd/bellpush/notifications"
+	"github.com/stuartleeks/pi-bell/internal/pkg/configfile"
+	"github.com/stuartleeks/pi-bell/internal/pkg/discovery"
+	"github.com/stuartleeks/pi-bell/internal/pkg/logging"
+	"github.com/stuartleeks/pi-bell/internal/pkg/sdnotify"
+	"github.com/stuartleeks/pi-bell/internal/pkg/telemetry"
+	"github.com/stuartleeks/pi-bell/internal/pkg/version"
 
-	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-var telemetryClient appinsights.TelemetryClient
+var telemetryClient telemetry.Client
+
+var logger = logging.New("component", "main")
 
 // // Set up homepage for testing
 //
@@ -22,35 +41,82 @@ var telemetryClient appinsights.TelemetryClient
 //	}
 
 func main() {
+	configPath := flag.String("config", os.Getenv("BELLPUSH_CONFIG"), "path to the YAML config file (optional)")
+	checkConfig := flag.Bool("check-config", false, "validate the config and exit")
+	listenAddress := flag.String("listen-address", "", "address for the HTTP server (overrides the config file)")
 	flag.Parse()
 
-	key := os.Getenv("APPINSIGHTS_INSTRUMENTATIONKEY")
-	telemetryConfig := appinsights.NewTelemetryConfiguration(key) // seems happy to not not error without a key!
-	// Configure the maximum delay before sending queued telemetry:
-	telemetryConfig.MaxBatchInterval = 2 * time.Second
-	telemetryClient = appinsights.NewTelemetryClientFromConfig(telemetryConfig)
-	telemetryClient.Context().Tags.Cloud().SetRole("bellpush")
+	config, err := loadConfig(*configPath, *listenAddress)
+	if *checkConfig {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("Configuration is valid")
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err = config.Log.Apply(); err != nil {
+		panic(err)
+	}
+
+	// SIGINT/SIGTERM cancel ctx to start a graceful shutdown
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
 
-	trace := appinsights.NewTraceTelemetry("bellpush starting", appinsights.Information)
-	telemetryClient.Track(trace)
-	telemetryClient.Channel().Flush()
+	telemetryClient, err = telemetry.NewClient(config.Telemetry, "bellpush")
+	if err != nil {
+		panic(err)
+	}
+	telemetryClient.TrackTrace("bellpush starting", telemetry.Information, nil)
+	logger.Info("bellpush starting", "version", version.Version, "logLevel", logging.GetLevel(), "config", *configPath)
+	sdnotify.Status("Starting")
 
-	disableGpioEnv := os.Getenv("DISABLE_GPIO")
-	disableGpio := disableGpioEnv == "true"
+	bellpush := bellpush.NewBellPush(telemetryClient, config.DoorName)
+	bellpush.SetStuckButtonTimeout(config.StuckButtonTimeout)
+	bellpush.SetMissedRingWindow(config.MissedRingWindow)
+	bellpush.SetButtonConfig(config.Button)
+	prometheus.MustRegister(bellpush)
 
-	disableWebcamEnv := os.Getenv("DISABLE_WEBCAM")
-	disableWebcam := disableWebcamEnv == "true"
+	if config.StateFile != "" {
+		if err = bellpush.LoadState(config.StateFile); err != nil {
+			logger.Warn("Failed to load state - continuing without it", "err", err)
+		}
+	}
 
-	bellpush := bellpush.NewBellPush(telemetryClient)
+	// with failover, the standby doesn't broadcast button events or accept chimes until the primary's heartbeat stops
+	var failoverManager *failover.Manager
+	if config.Failover.Enabled() {
+		failoverManager = failover.NewManager(config.Failover, bellpush)
+		http.Handle("/failover", failoverManager)
+	}
 
-	if !disableGpio {
+	if !config.DisableGPIO {
 		err := bellpush.StartGpio()
 		if err != nil {
 			panic(err)
 		}
 	}
 
-	fmt.Println("Starting health ticker...")
+	var mqttBridge *mqttbridge.Bridge
+	if config.MQTT.Enabled() {
+		mqttBridge = newMqttBridge(config.MQTT, bellpush)
+		err = mqttBridge.Start()
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	notificationDispatcher := notifications.NewDispatcher(bellpush, config.DoorName)
+	notificationDispatcher.Configure(config.Notifications)
+	notificationDispatcher.Start()
+
+	go reloadConfigOnSignal(*configPath, *listenAddress, config, bellpush, notificationDispatcher)
+
+	logger.Info("Starting health ticker")
 	healthTicker := time.NewTicker(1 * time.Minute)
 	healthTickerDone := make(chan bool)
 	go func() {
@@ -60,39 +126,209 @@ func main() {
 				return
 			case <-healthTicker.C:
 				// Send health ping to show we're still alive
-				telemetryClient.TrackEvent("health-ping")
-				telemetryClient.Channel().Flush()
+				telemetryClient.TrackEvent("health-ping", nil)
+				if mqttBridge != nil {
+					mqttBridge.PublishHealth()
+				}
 			}
 		}
 	}()
 
 	// GPIO events are disabled - set up keyboard input for simulation when testing
-	if disableGpio {
+	if config.DisableGPIO {
 		bellpush.StartStdioReader()
 	}
 
-	var err error
-	if disableWebcam {
+	if config.DisableWebcam {
 		err = bellpush.StartFakeCameraCapture()
 	} else {
 		err = bellpush.StartCameraCapture()
 	}
 	if err != nil {
 		telemetryClient.TrackException(err)
-		telemetryClient.Channel().Flush()
+		telemetryClient.Close(5 * time.Second)
+		panic(err)
+	}
+
+	bellpushHTTPServer := httpserver.NewBellPushHTTPServer(bellpush, notificationDispatcher, telemetryClient)
+
+	logger.Info("Starting server", "address", config.ListenAddress)
+	if err = bellpushHTTPServer.Listen(config.ListenAddress); err != nil {
+		telemetryClient.TrackException(err)
+		telemetryClient.Close(5 * time.Second)
 		panic(err)
 	}
+	serverErrors := make(chan error, 1)
+	go func() {
+		serverErrors <- bellpushHTTPServer.Serve()
+	}()
+
+	advertiser := &advertiser{config: config}
+	if config.MDNS.Enabled {
+		advertiser.setAdvertising(bellpush.IsLeader())
+	}
+	if failoverManager != nil {
+		if config.MDNS.Enabled {
+			// only the leader is advertised so that chimes using discovery follow the leadership
+			failoverManager.OnLeadershipChange(advertiser.setAdvertising)
+		}
+		go failoverManager.Run(ctx)
+	}
+
+	// GPIO, camera and listener are up
+	sdnotify.Ready()
+	reportStatus(config.ListenAddress, bellpush, failoverManager)
+
+	// the main loop pings the systemd watchdog. Reporting the status reads the chime registry
+	// so a deadlock there stops the pings and systemd restarts the bellpush
+	statusInterval := sdnotify.WatchdogInterval()
+	if statusInterval == 0 {
+		statusInterval = 30 * time.Second
+	}
+	statusTicker := time.NewTicker(statusInterval)
+	defer statusTicker.Stop()
 
-	bellpushHTTPServer := httpserver.NewBellPushHTTPServer(bellpush, telemetryClient)
+	var serverErr error
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("Shutting down", "timeout", config.ShutdownTimeout)
+			break loop
+		case serverErr = <-serverErrors:
+			logger.Error("HTTP server failed - shutting down", "err", serverErr)
+			break loop
+		case <-statusTicker.C:
+			reportStatus(config.ListenAddress, bellpush, failoverManager)
+			sdnotify.Watchdog()
+		}
+	}
+	// restore the default signal handling so that a second signal exits immediately
+	stopSignals()
+	sdnotify.Stopping()
+	sdnotify.Status("Shutting down")
 
-	fmt.Println("Starting server...")
-	err = bellpushHTTPServer.ListenAndServe("0.0.0.0:8080")
-	bellpush.Stop()
+	// withdraw the service first so that reconnecting chimes don't find this bellpush
+	advertiser.setAdvertising(false)
+	shutdownDeadline := time.Now().Add(config.ShutdownTimeout)
+	shutdownCtx, cancelShutdown := context.WithDeadline(context.Background(), shutdownDeadline)
+	defer cancelShutdown()
+	if err = bellpushHTTPServer.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("Error shutting down HTTP server", "err", err)
+	}
+	if err = bellpush.Stop(shutdownCtx); err != nil {
+		logger.Warn("Error stopping bellpush", "err", err)
+	}
+	if mqttBridge != nil {
+		mqttBridge.Stop()
+	}
 	healthTicker.Stop()
 	healthTickerDone <- true
+	if config.StateFile != "" {
+		if err = bellpush.SaveState(config.StateFile); err != nil {
+			logger.Error("Failed to save state", "err", err)
+		}
+	}
+
+	// flush telemetry with whatever is left of the shutdown timeout
+	flushTimeout := time.Until(shutdownDeadline)
+	if serverErr != nil {
+		telemetryClient.TrackException(serverErr)
+		telemetryClient.Close(flushTimeout)
+		os.Exit(1)
+	}
+	telemetryClient.TrackTrace("bellpush stopped", telemetry.Information, nil)
+	telemetryClient.Close(flushTimeout)
+	logger.Info("bellpush stopped")
+}
+
+// advertiser advertises the bellpush via mDNS while it is the leader
+type advertiser struct {
+	config        Config
+	mutex         sync.Mutex
+	advertisement *discovery.Advertisement
+}
+
+// setAdvertising starts or withdraws the advertisement
+func (a *advertiser) setAdvertising(advertising bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if !advertising {
+		if a.advertisement != nil {
+			a.advertisement.Shutdown()
+			a.advertisement = nil
+		}
+		return
+	}
+	if a.advertisement != nil {
+		return
+	}
+	advertisement, err := advertise(a.config)
 	if err != nil {
-		telemetryClient.TrackException(err)
-		telemetryClient.Channel().Flush()
-		panic(err)
+		// chimes can still connect using a configured address
+		logger.Warn("Failed to advertise bellpush via mDNS", "err", err)
+		return
+	}
+	a.advertisement = advertisement
+}
+
+// advertise publishes the bellpush via mDNS so that chimes can find it without a configured address
+func advertise(config Config) (*discovery.Advertisement, error) {
+	_, portString, err := net.SplitHostPort(config.ListenAddress)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q: %w", portString, err)
+	}
+	return discovery.Advertise(config.MDNS.Instance, port, discovery.Info{
+		Version: version.Version,
+		Doors:   []string{config.DoorName},
+		// the HTTP server doesn't serve TLS yet
+		TLS: false,
+	})
+}
+
+// reportStatus sets the status shown by `systemctl status`
+func reportStatus(listenAddress string, bellPush *bellpush.BellPush, failoverManager *failover.Manager) {
+	if failoverManager != nil {
+		sdnotify.Status("Listening on %s, %d chime(s) connected, %s", listenAddress, len(bellPush.GetChimes()), failoverManager.Status())
+		return
+	}
+	sdnotify.Status("Listening on %s, %d chime(s) connected", listenAddress, len(bellPush.GetChimes()))
+}
+
+func newMqttBridge(config MQTTConfig, bellPush *bellpush.BellPush) *mqttbridge.Bridge {
+	bridge := mqttbridge.NewBridge(config.Config, config.NodeID, bellPush)
+	bridge.DiscoveryPrefix = config.DiscoveryPrefix
+	bridge.SnoozeDuration = config.SnoozeDuration
+	return bridge
+}
+
+// reloadConfigOnSignal reloads the config on SIGHUP. The log, notification and button settings are applied
+// immediately; changes to other settings (compared with running, the config last loaded) are logged as needing a restart.
+// If the new config is invalid then the current settings are kept
+func reloadConfigOnSignal(path string, listenAddress string, running Config, bellPush *bellpush.BellPush, dispatcher *notifications.Dispatcher) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		logger.Info("Reloading config", "config", path)
+		config, err := loadConfig(path, listenAddress)
+		if err != nil {
+			logger.Error("Failed to reload config - keeping current settings", "error", err)
+			continue
+		}
+		if err = config.Log.Apply(); err != nil {
+			logger.Error("Failed to apply log settings", "error", err)
+		}
+		dispatcher.Configure(config.Notifications)
+		bellPush.SetButtonConfig(config.Button)
+		if changed := configfile.ChangedSettings(running, config, reloadableSettings...); len(changed) > 0 {
+			logger.Warn("Some changed settings only take effect after a restart", "settings", strings.Join(changed, ","))
+		}
+		// compare the next reload with this config so that the warnings are only logged once for each change
+		running = config
+		logger.Info("Config reloaded", "logLevel", logging.GetLevel(), "notifiers", strings.Join(dispatcher.NotifierNames(), ","))
 	}
 }
@@ -0,0 +1,241 @@
+package mqttbridge
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stuartleeks/pi-bell/cmd/bellpush/bellpush"
+	"github.com/stuartleeks/pi-bell/internal/pkg/events"
+	"github.com/stuartleeks/pi-bell/internal/pkg/logging"
+	"github.com/stuartleeks/pi-bell/internal/pkg/mqttutils"
+)
+
+// Chimes can use MQTT as their transport instead of a websocket (see cmd/chime/mqtt.go).
+// These chimes are registered with the bellpush when they publish their presence so that
+// they show up on the home page and can be snoozed. Button events reach them via the events topic
+// and snooze state is published to a retained topic. Snooze requests from a chime's button are published
+// to the chime's presence topics so that every bellpush node applies them. Rings missed while a chime was
+// offline are published to its missed-rings topic when it comes back online.
+//
+// The topics use the chime's sanitized name (its ID), so chimes publish their name to a retained info topic
+// and are registered with the bellpush under that name. Chimes that don't publish one are registered under their ID
+
+// mqttChime is a chime using MQTT as its transport
+type mqttChime struct {
+	name   string
+	events chan events.Event
+}
+
+func (b *Bridge) subscribeChimeTopics(client mqtt.Client) {
+	// the info topic is subscribed to first so that the retained names are usually known before the presence
+	subscriptions := []struct {
+		topic   string
+		handler mqtt.MessageHandler
+	}{
+		{b.config.AllChimePresenceTopic("info"), b.handleChimeInfo},
+		{b.config.AllChimesTopic(b.nodeID, "snooze"), b.handleRetainedSnooze},
+		{b.config.AllChimePresenceTopic("status"), b.handleChimePresence},
+		{b.config.AllChimePresenceTopic("ack"), b.handleChimeAck},
+		{b.config.AllChimePresenceTopic("snooze-request"), b.handleChimeSnoozeRequest},
+	}
+	for _, subscription := range subscriptions {
+		token := client.Subscribe(subscription.topic, 1, subscription.handler)
+		if err := mqttutils.Wait(token, publishTimeout); err != nil {
+			logger.Error("Error subscribing", "topic", subscription.topic, "err", err)
+		}
+	}
+}
+
+// chimeIDFromTopic returns the chime ID from a topic of the form <prefix>/.../<chime>/<path>
+func chimeIDFromTopic(topic string) string {
+	parts := strings.Split(topic, "/")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[len(parts)-2]
+}
+
+// chimeName returns the name published by the chime with the specified ID, or the ID if it hasn't published one.
+// Must be called with mqttChimesLock held
+func (b *Bridge) chimeName(chimeID string) string {
+	if name, ok := b.chimeNames[chimeID]; ok {
+		return name
+	}
+	return chimeID
+}
+
+// handleChimeInfo records a chime's name. If the chime has already been registered under a different name
+// (e.g. because its presence arrived first) then it is registered again under the new name
+func (b *Bridge) handleChimeInfo(_ mqtt.Client, message mqtt.Message) {
+	chimeID := chimeIDFromTopic(message.Topic())
+	var info mqttutils.ChimeInfo
+	if len(message.Payload()) == 0 {
+		// the retained info has been cleared
+		info.Name = chimeID
+	} else if err := json.Unmarshal(message.Payload(), &info); err != nil || info.Name == "" || mqttutils.SanitizeID(info.Name) != chimeID {
+		logger.Warn("Unexpected chime info", "chime", chimeID, "payload", string(message.Payload()), "err", err)
+		return
+	}
+
+	b.mqttChimesLock.Lock()
+	b.chimeNames[chimeID] = info.Name
+	chime, online := b.mqttChimes[chimeID]
+	b.mqttChimesLock.Unlock()
+	if online && chime.name != info.Name {
+		b.removeMqttChime(chimeID)
+		b.addMqttChime(chimeID)
+	}
+}
+
+func (b *Bridge) handleChimePresence(_ mqtt.Client, message mqtt.Message) {
+	chimeID := chimeIDFromTopic(message.Topic())
+	switch payload := string(message.Payload()); payload {
+	case mqttutils.PayloadOnline:
+		b.addMqttChime(chimeID)
+	case mqttutils.PayloadOffline:
+		b.removeMqttChime(chimeID)
+	default:
+		logger.Warn("Unexpected presence payload", "chime", chimeID, "payload", payload)
+	}
+}
+
+func (b *Bridge) addMqttChime(chimeID string) {
+	b.mqttChimesLock.Lock()
+	if _, ok := b.mqttChimes[chimeID]; ok {
+		b.mqttChimesLock.Unlock()
+		return
+	}
+	chimeName := b.chimeName(chimeID)
+	logger.Info("MQTT chime connected", "chime", chimeName)
+
+	outputChannel := make(chan events.Event, 50)
+	chime, ok := b.bellPush.GetChime(chimeName)
+	restoredSnooze := false
+	// previousChannel is the existing client loop's channel, which is stopped once the lock is released
+	var previousChannel chan events.Event
+	if ok {
+		previousChannel = chime.Events
+		chime.Events = outputChannel
+	} else {
+		chime = bellpush.ChimeInfo{
+			Events:    outputChannel,
+			SnoozeEnd: b.retainedSnoozes[chimeID],
+		}
+		restoredSnooze = chime.IsSnoozed()
+	}
+	// MQTT chimes don't negotiate the protocol, so they are assumed to match the bellpush
+	chime.Version = ""
+	chime.Features = events.Features
+	b.mqttChimes[chimeID] = mqttChime{name: chimeName, events: outputChannel}
+	b.bellPush.SetChime(chimeName, chime)
+	b.mqttChimesLock.Unlock()
+
+	if previousChannel != nil {
+		// Send stop processing event to existing client loop (now that it has been replaced with the new loop)
+		b.bellPush.StopChimeEvents(chimeName, previousChannel)
+	}
+	if restoredSnooze {
+		b.bellPush.NotifySnoozeRestored(chimeName, chime.SnoozeEnd)
+	}
+
+	go b.forwardChimeEvents(chimeName, outputChannel)
+}
+
+func (b *Bridge) removeMqttChime(chimeID string) {
+	b.mqttChimesLock.Lock()
+	chime, ok := b.mqttChimes[chimeID]
+	if !ok {
+		b.mqttChimesLock.Unlock()
+		return
+	}
+	logger.Info("MQTT chime disconnected", "chime", chime.name)
+	delete(b.mqttChimes, chimeID)
+	b.bellPush.RemoveChimeIfChannel(chime.name, chime.events)
+	b.mqttChimesLock.Unlock()
+
+	b.bellPush.StopChimeEvents(chime.name, chime.events)
+}
+
+// forwardChimeEvents publishes snooze changes for an MQTT chime to its retained snooze topic and missed rings
+// to its missed-rings topic. Button events are ignored here as the chime receives them from the events topic
+func (b *Bridge) forwardChimeEvents(chimeName string, outputChannel chan events.Event) {
+	for event := range outputChannel {
+		switch event.GetType() {
+		case events.EventTypeStopProcessing:
+			return
+		case events.EventTypeSnooze, events.EventTypeUnSnooze:
+			eventJSON, err := events.ToJSON(event)
+			if err != nil {
+				logger.WithCorrelationID(event.GetID()).Error("Error converting event to JSON", "chime", chimeName, "err", err)
+				continue
+			}
+			b.publish(b.config.ChimeTopic(b.nodeID, chimeName, "snooze"), true, eventJSON)
+		case events.EventTypeMissedRings:
+			eventJSON, err := events.ToJSON(event)
+			if err != nil {
+				logger.WithCorrelationID(event.GetID()).Error("Error converting event to JSON", "chime", chimeName, "err", err)
+				continue
+			}
+			b.publish(b.config.ChimeTopic(b.nodeID, chimeName, "missed-rings"), false, eventJSON)
+		}
+	}
+}
+
+// handleRetainedSnooze restores snooze state for MQTT chimes when the bellpush restarts
+func (b *Bridge) handleRetainedSnooze(_ mqtt.Client, message mqtt.Message) {
+	chimeID := chimeIDFromTopic(message.Topic())
+	snoozeEnd := time.Time{}
+	event, err := events.Decode(message.Payload())
+	if err != nil {
+		logger.Warn("Error parsing retained snooze", "chime", chimeID, "err", err)
+		return
+	}
+	switch event := event.(type) {
+	case *events.SnoozeEvent:
+		snoozeEnd = event.SnoozeExpiry
+	case *events.UnSnoozeEvent:
+	default:
+		logger.Warn("Unexpected retained snooze", "chime", chimeID, "eventType", event.GetType())
+		return
+	}
+
+	b.mqttChimesLock.Lock()
+	defer b.mqttChimesLock.Unlock()
+	b.retainedSnoozes[chimeID] = snoozeEnd
+	chimeName := b.chimeName(chimeID)
+	if chime, ok := b.bellPush.GetChime(chimeName); ok && !chime.SnoozeEnd.Equal(snoozeEnd) {
+		chime.SnoozeEnd = snoozeEnd
+		b.bellPush.SetChime(chimeName, chime)
+		b.bellPush.NotifySnoozeRestored(chimeName, snoozeEnd)
+	}
+}
+
+// handleChimeSnoozeRequest applies a snooze request from an MQTT chime
+func (b *Bridge) handleChimeSnoozeRequest(_ mqtt.Client, message mqtt.Message) {
+	b.mqttChimesLock.Lock()
+	chimeName := b.chimeName(chimeIDFromTopic(message.Topic()))
+	b.mqttChimesLock.Unlock()
+	request, err := events.ParseSnoozeRequestJSON(message.Payload())
+	if err != nil {
+		logger.Warn("Error parsing snooze request", "chime", chimeName, "err", err)
+		return
+	}
+	if _, ok := b.bellPush.GetChime(chimeName); !ok {
+		logger.WithCorrelationID(request.ID).Warn("Snooze request from unknown chime", "chime", chimeName)
+		return
+	}
+	if err = b.bellPush.HandleSnoozeRequest(chimeName, request); err != nil {
+		logger.WithCorrelationID(request.ID).Error("Error handling snooze request", "chime", chimeName, "err", err)
+	}
+}
+
+func (b *Bridge) handleChimeAck(_ mqtt.Client, message mqtt.Message) {
+	var ack mqttutils.Ack
+	if err := json.Unmarshal(message.Payload(), &ack); err != nil {
+		logger.Warn("Error parsing ack", "topic", message.Topic(), "err", err)
+		return
+	}
+	logger.With(logging.CorrelationIDKey, ack.ID).Info("Chime acknowledged event", "chime", ack.ChimeName, "eventType", ack.EventType)
+}
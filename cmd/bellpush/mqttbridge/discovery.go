@@ -0,0 +1,120 @@
+package mqttbridge
+
+import (
+	"fmt"
+
+	"github.com/stuartleeks/pi-bell/internal/pkg/mqttutils"
+)
+
+// Home Assistant MQTT discovery - see https://www.home-assistant.io/integrations/mqtt/#mqtt-discovery
+
+type discoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+	ViaDevice    string   `json:"via_device,omitempty"`
+}
+
+type discoveryConfig struct {
+	Name              string          `json:"name"`
+	UniqueID          string          `json:"unique_id"`
+	ObjectID          string          `json:"object_id"`
+	Device            discoveryDevice `json:"device"`
+	AvailabilityTopic string          `json:"availability_topic"`
+	Icon              string          `json:"icon,omitempty"`
+	DeviceClass       string          `json:"device_class,omitempty"`
+
+	StateTopic   string   `json:"state_topic,omitempty"`
+	CommandTopic string   `json:"command_topic,omitempty"`
+	Topic        string   `json:"topic,omitempty"`
+	EventTypes   []string `json:"event_types,omitempty"`
+	Options      []string `json:"options,omitempty"`
+	PayloadOn    string   `json:"payload_on,omitempty"`
+	PayloadOff   string   `json:"payload_off,omitempty"`
+}
+
+func (b *Bridge) bellPushDevice() discoveryDevice {
+	return discoveryDevice{
+		Identifiers:  []string{"pibell_" + b.nodeID},
+		Name:         fmt.Sprintf("pi-bell %s", b.nodeID),
+		Manufacturer: "pi-bell",
+		Model:        "bellpush",
+	}
+}
+func (b *Bridge) chimeDevice(chimeName string) discoveryDevice {
+	return discoveryDevice{
+		Identifiers:  []string{fmt.Sprintf("pibell_%s_chime_%s", b.nodeID, mqttutils.SanitizeID(chimeName))},
+		Name:         fmt.Sprintf("pi-bell chime %s", chimeName),
+		Manufacturer: "pi-bell",
+		Model:        "chime",
+		ViaDevice:    "pibell_" + b.nodeID,
+	}
+}
+
+func (b *Bridge) discoveryTopic(component string, objectID string) string {
+	return fmt.Sprintf("%s/%s/%s/%s/config", b.DiscoveryPrefix, component, b.nodeID, objectID)
+}
+
+func (b *Bridge) publishDiscovery() {
+	device := b.bellPushDevice()
+
+	b.publishJSON(b.discoveryTopic("event", "doorbell"), true, discoveryConfig{
+		Name:              "Doorbell",
+		UniqueID:          fmt.Sprintf("pibell_%s_doorbell_event", b.nodeID),
+		ObjectID:          fmt.Sprintf("pibell_%s_doorbell", b.nodeID),
+		Device:            device,
+		AvailabilityTopic: b.availabilityTopic(),
+		DeviceClass:       "doorbell",
+		StateTopic:        b.topic("doorbell/event"),
+		EventTypes:        []string{"pressed", "released", "stuck", "short-press", "long-press", "double-press"},
+	})
+	b.publishJSON(b.discoveryTopic("binary_sensor", "doorbell"), true, discoveryConfig{
+		Name:              "Doorbell pressed",
+		UniqueID:          fmt.Sprintf("pibell_%s_doorbell_pressed", b.nodeID),
+		ObjectID:          fmt.Sprintf("pibell_%s_doorbell_pressed", b.nodeID),
+		Device:            device,
+		AvailabilityTopic: b.availabilityTopic(),
+		Icon:              "mdi:doorbell",
+		StateTopic:        b.topic("doorbell/state"),
+		PayloadOn:         payloadOn,
+		PayloadOff:        payloadOff,
+	})
+	b.publishJSON(b.discoveryTopic("camera", "camera"), true, discoveryConfig{
+		Name:              "Camera",
+		UniqueID:          fmt.Sprintf("pibell_%s_camera", b.nodeID),
+		ObjectID:          fmt.Sprintf("pibell_%s_camera", b.nodeID),
+		Device:            device,
+		AvailabilityTopic: b.availabilityTopic(),
+		Topic:             b.topic("camera/image"),
+	})
+}
+
+func (b *Bridge) publishChimeDiscovery(chimeName string) {
+	device := b.chimeDevice(chimeName)
+	chimeID := mqttutils.SanitizeID(chimeName)
+
+	b.publishJSON(b.discoveryTopic("switch", "chime_"+chimeID+"_snoozed"), true, discoveryConfig{
+		Name:              "Snoozed",
+		UniqueID:          fmt.Sprintf("pibell_%s_chime_%s_snoozed", b.nodeID, chimeID),
+		ObjectID:          fmt.Sprintf("pibell_chime_%s_snoozed", chimeID),
+		Device:            device,
+		AvailabilityTopic: b.availabilityTopic(),
+		Icon:              "mdi:bell-sleep",
+		StateTopic:        b.config.ChimeTopic(b.nodeID, chimeName, "snoozed"),
+		CommandTopic:      b.config.ChimeTopic(b.nodeID, chimeName, "snoozed/set"),
+		PayloadOn:         payloadOn,
+		PayloadOff:        payloadOff,
+	})
+	b.publishJSON(b.discoveryTopic("sensor", "chime_"+chimeID+"_connection"), true, discoveryConfig{
+		Name:              "Connection",
+		UniqueID:          fmt.Sprintf("pibell_%s_chime_%s_connection", b.nodeID, chimeID),
+		ObjectID:          fmt.Sprintf("pibell_chime_%s_connection", chimeID),
+		Device:            device,
+		AvailabilityTopic: b.availabilityTopic(),
+		Icon:              "mdi:lan-connect",
+		DeviceClass:       "enum",
+		StateTopic:        b.config.ChimeTopic(b.nodeID, chimeName, "connection"),
+		Options:           []string{payloadConnected, payloadDisconnected},
+	})
+}
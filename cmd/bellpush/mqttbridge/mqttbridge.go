@@ -0,0 +1,303 @@
+package mqttbridge
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stuartleeks/pi-bell/cmd/bellpush/bellpush"
+	"github.com/stuartleeks/pi-bell/internal/pkg/events"
+	"github.com/stuartleeks/pi-bell/internal/pkg/logging"
+	"github.com/stuartleeks/pi-bell/internal/pkg/mqttutils"
+)
+
+const publishTimeout = 5 * time.Second
+
+var logger = logging.New("component", "mqttbridge")
+
+const (
+	payloadOn           = "ON"
+	payloadOff          = "OFF"
+	payloadConnected    = "connected"
+	payloadDisconnected = "disconnected"
+)
+
+// Bridge publishes bellpush events and state to MQTT along with
+// Home Assistant discovery payloads, and handles snooze commands from Home Assistant
+type Bridge struct {
+	config   mqttutils.Config
+	nodeID   string
+	bellPush *bellpush.BellPush
+	client   mqtt.Client
+
+	// DiscoveryPrefix is the Home Assistant discovery prefix (defaults to "homeassistant")
+	DiscoveryPrefix string
+	// SnoozeDuration is how long a chime is snoozed for when the Home Assistant switch is turned on
+	SnoozeDuration time.Duration
+	// CameraInterval is how often the webcam frame is published
+	CameraInterval time.Duration
+
+	chimesLock sync.Mutex
+	// chimes tracks the chimes that discovery has been published for, keyed by sanitized ID
+	chimes map[string]string
+
+	mqttChimesLock sync.Mutex
+	// mqttChimes tracks the chimes using MQTT as their transport, keyed by the ID in their topics
+	mqttChimes map[string]mqttChime
+	// chimeNames holds the names published by MQTT chimes, keyed by ID
+	chimeNames map[string]string
+	// retainedSnoozes holds the snooze state for MQTT chimes restored from retained messages, keyed by ID
+	retainedSnoozes map[string]time.Time
+
+	stopCamera chan bool
+}
+
+func NewBridge(config mqttutils.Config, nodeID string, bellPush *bellpush.BellPush) *Bridge {
+	return &Bridge{
+		config:          config,
+		nodeID:          mqttutils.SanitizeID(nodeID),
+		bellPush:        bellPush,
+		DiscoveryPrefix: "homeassistant",
+		SnoozeDuration:  1 * time.Hour,
+		CameraInterval:  10 * time.Second,
+		chimes:          make(map[string]string),
+		mqttChimes:      make(map[string]mqttChime),
+		chimeNames:      make(map[string]string),
+		retainedSnoozes: make(map[string]time.Time),
+		stopCamera:      make(chan bool, 1),
+	}
+}
+
+// Start connects to the broker and starts publishing. Connection retries happen in the background
+func (b *Bridge) Start() error {
+	options := b.config.NewClientOptions("pibell-bellpush-"+b.nodeID, b.availabilityTopic())
+	options.SetOnConnectHandler(b.onConnect)
+	options.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		logger.Warn("MQTT connection lost", "err", err)
+	})
+	b.client = mqtt.NewClient(options)
+
+	logger.Info("Connecting to MQTT broker", "broker", b.config.Broker)
+	token := b.client.Connect()
+	// With ConnectRetry set, the token only completes once connected so don't block startup on it
+	go func() {
+		if token.Wait() && token.Error() != nil {
+			logger.Error("Error connecting to MQTT broker", "err", token.Error())
+		}
+	}()
+
+	b.bellPush.AddEventListener(b.handleEvent)
+	go b.cameraLoop()
+	return nil
+}
+
+func (b *Bridge) Stop() {
+	b.stopCamera <- true
+	if b.client == nil || !b.client.IsConnected() {
+		return
+	}
+	b.publish(b.availabilityTopic(), true, mqttutils.PayloadOffline)
+	b.client.Disconnect(250)
+}
+
+// PublishHealth refreshes availability and chime state. Called from the bellpush health ticker
+func (b *Bridge) PublishHealth() {
+	if !b.client.IsConnected() {
+		return
+	}
+	b.publish(b.availabilityTopic(), true, mqttutils.PayloadOnline)
+	b.publishChimeStates()
+}
+
+func (b *Bridge) onConnect(client mqtt.Client) {
+	logger.Info("Connected to MQTT broker")
+
+	b.publishDiscovery()
+	b.publish(b.availabilityTopic(), true, mqttutils.PayloadOnline)
+	b.publish(b.topic("doorbell/state"), true, payloadOff)
+
+	// re-announce any chimes we already know about in case the broker has lost retained messages
+	for _, name := range b.knownChimes() {
+		b.publishChimeDiscovery(name)
+	}
+	b.publishChimeStates()
+
+	snoozeCommandTopic := b.config.AllChimesTopic(b.nodeID, "snoozed/set")
+	token := client.Subscribe(snoozeCommandTopic, 1, b.handleSnoozeCommand)
+	if err := mqttutils.Wait(token, publishTimeout); err != nil {
+		logger.Error("Error subscribing", "topic", snoozeCommandTopic, "err", err)
+	}
+	b.subscribeChimeTopics(client)
+}
+
+func (b *Bridge) handleEvent(chimeName string, event events.Event) {
+	if !b.client.IsConnected() {
+		return
+	}
+	switch e := event.(type) {
+	case *events.ButtonEvent:
+		eventJSON, err := events.ToJSON(e)
+		if err != nil {
+			logger.WithCorrelationID(e.ID).Error("Error converting event to JSON", "err", err)
+			return
+		}
+		b.publish(b.topic("events"), false, eventJSON)
+
+		eventType := events.TypeToString(e.ButtonEventType)
+		b.publishJSON(b.topic("doorbell/event"), false, map[string]string{"event_type": eventType})
+		switch e.ButtonEventType {
+		case events.ButtonPressed:
+			b.publish(b.topic("doorbell/state"), true, payloadOn)
+			b.publishCameraFrame()
+		case events.ButtonReleased, events.ButtonStuck:
+			b.publish(b.topic("doorbell/state"), true, payloadOff)
+		}
+	case *events.ChimeStatusEvent:
+		b.publishChimeState(e.ChimeName, e.Connected)
+	case *events.SnoozeEvent, *events.UnSnoozeEvent:
+		b.publishChimeState(chimeName, true)
+	}
+}
+
+func (b *Bridge) handleSnoozeCommand(_ mqtt.Client, message mqtt.Message) {
+	// topic is <prefix>/<node>/chime/<chime>/snoozed/set
+	var chimeName string
+	b.chimesLock.Lock()
+	for id, name := range b.chimes {
+		if message.Topic() == b.config.ChimeTopic(b.nodeID, id, "snoozed/set") {
+			chimeName = name
+		}
+	}
+	b.chimesLock.Unlock()
+	if chimeName == "" {
+		logger.Warn("Snooze command for unknown chime", "topic", message.Topic())
+		return
+	}
+
+	var err error
+	switch payload := string(message.Payload()); payload {
+	case payloadOn:
+		logger.Info("Snoozing chime", "chime", chimeName, "duration", b.SnoozeDuration)
+		err = b.bellPush.SnoozeChime(chimeName, b.SnoozeDuration)
+	case payloadOff:
+		logger.Info("UnSnoozing chime", "chime", chimeName)
+		err = b.bellPush.UnSnoozeChime(chimeName)
+	default:
+		logger.Warn("Unexpected snooze command payload", "chime", chimeName, "payload", payload)
+		return
+	}
+	if err != nil {
+		logger.Error("Error handling snooze command", "chime", chimeName, "err", err)
+		// publish the current snooze state so that Home Assistant doesn't show the switch in the requested
+		// state. A failed send doesn't mean that the chime has disconnected, so the connection is left alone
+		b.publishChimeSnoozed(chimeName)
+	}
+}
+
+func (b *Bridge) publishChimeStates() {
+	connectedChimes := b.bellPush.GetChimes()
+	for name := range connectedChimes {
+		b.publishChimeState(name, true)
+	}
+
+	// chimes are removed from the bellpush when they disconnect, so report any that we have previously seen
+	for _, name := range b.knownChimes() {
+		if _, ok := connectedChimes[name]; !ok {
+			b.publishChimeState(name, false)
+		}
+	}
+}
+
+func (b *Bridge) knownChimes() []string {
+	b.chimesLock.Lock()
+	defer b.chimesLock.Unlock()
+	names := make([]string, 0, len(b.chimes))
+	for _, name := range b.chimes {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (b *Bridge) publishChimeState(chimeName string, connected bool) {
+	if !b.client.IsConnected() {
+		return
+	}
+	id := mqttutils.SanitizeID(chimeName)
+	b.chimesLock.Lock()
+	_, announced := b.chimes[id]
+	b.chimes[id] = chimeName
+	b.chimesLock.Unlock()
+	if !announced {
+		b.publishChimeDiscovery(chimeName)
+	}
+
+	connectionPayload := payloadDisconnected
+	if connected {
+		connectionPayload = payloadConnected
+	}
+	b.publish(b.config.ChimeTopic(b.nodeID, chimeName, "connection"), true, connectionPayload)
+	b.publishChimeSnoozed(chimeName)
+}
+
+// publishChimeSnoozed publishes whether the chime is snoozed
+func (b *Bridge) publishChimeSnoozed(chimeName string) {
+	if !b.client.IsConnected() {
+		return
+	}
+	snoozedPayload := payloadOff
+	if chime, ok := b.bellPush.GetChime(chimeName); ok && chime.IsSnoozed() {
+		snoozedPayload = payloadOn
+	}
+	b.publish(b.config.ChimeTopic(b.nodeID, chimeName, "snoozed"), true, snoozedPayload)
+}
+
+func (b *Bridge) cameraLoop() {
+	ticker := time.NewTicker(b.CameraInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stopCamera:
+			return
+		case <-ticker.C:
+			b.publishCameraFrame()
+		}
+	}
+}
+
+func (b *Bridge) publishCameraFrame() {
+	if !b.client.IsConnected() {
+		return
+	}
+	frame := b.bellPush.GetWebcamFrame()
+	if len(frame) == 0 {
+		return
+	}
+	b.publish(b.topic("camera/image"), false, frame)
+}
+
+func (b *Bridge) topic(path string) string {
+	return b.config.BellPushTopic(b.nodeID, path)
+}
+func (b *Bridge) availabilityTopic() string {
+	return b.topic("availability")
+}
+
+func (b *Bridge) publishJSON(topic string, retained bool, value interface{}) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		logger.Error("Error marshalling MQTT payload", "topic", topic, "err", err)
+		return
+	}
+	b.publish(topic, retained, payload)
+}
+
+// publish sends a message without waiting for the broker so that a slow broker doesn't hold up event handling
+func (b *Bridge) publish(topic string, retained bool, payload interface{}) {
+	token := b.client.Publish(topic, 1, retained, payload)
+	go func() {
+		if err := mqttutils.Wait(token, publishTimeout); err != nil {
+			logger.Warn("Error publishing", "topic", topic, "err", err)
+		}
+	}()
+}
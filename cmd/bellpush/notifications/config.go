@@ -0,0 +1,49 @@
+package notifications
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/stuartleeks/pi-bell/internal/pkg/configfile"
+)
+
+// Config holds the notification settings from the config file.
+// These can be changed while the bellpush is running (see Dispatcher.Configure)
+type Config struct {
+	// HomePageURL is the URL of the bellpush home page that notifications link to
+	HomePageURL string `yaml:"homePageUrl" env:"BELLPUSH_URL"`
+	// ChimeDisconnectWarning is how long a chime can be disconnected before a warning is sent (0 to disable).
+	// This can be overridden for each notifier
+	ChimeDisconnectWarning time.Duration `yaml:"chimeDisconnectWarning" env:"CHIME_DISCONNECT_WARNING"`
+
+	SMTP   SMTPConfig `yaml:"smtp" envPrefix:"SMTP_"`
+	Ntfy   PushConfig `yaml:"ntfy" envPrefix:"NTFY_"`
+	Gotify PushConfig `yaml:"gotify" envPrefix:"GOTIFY_"`
+}
+
+// DefaultConfig returns the default notification settings (with all notifiers disabled)
+func DefaultConfig() Config {
+	return Config{
+		ChimeDisconnectWarning: 10 * time.Minute,
+		SMTP: SMTPConfig{
+			MinInterval: 5 * time.Minute,
+		},
+		Ntfy:   DefaultPushConfig(PushServiceNtfy),
+		Gotify: DefaultPushConfig(PushServiceGotify),
+	}
+}
+
+// Validate checks the settings, adding any problems to errs
+func (c Config) Validate(errs *configfile.Errors, path string) {
+	if c.HomePageURL != "" {
+		if u, err := url.Parse(c.HomePageURL); err != nil || u.Scheme == "" || u.Host == "" {
+			errs.Add(configfile.Join(path, "homePageUrl"), "invalid URL %q", c.HomePageURL)
+		}
+	}
+	if c.ChimeDisconnectWarning < 0 {
+		errs.Add(configfile.Join(path, "chimeDisconnectWarning"), "can't be negative")
+	}
+	c.SMTP.Validate(errs, configfile.Join(path, "smtp"))
+	c.Ntfy.Validate(errs, configfile.Join(path, "ntfy"))
+	c.Gotify.Validate(errs, configfile.Join(path, "gotify"))
+}
@@ -0,0 +1,33 @@
+package notifications
+
+import (
+	"encoding/base64"
+	"io"
+	"mime/quotedprintable"
+)
+
+// maxLineLength is the maximum line length for encoded email content (RFC 2045)
+const maxLineLength = 76
+
+func writeQuotedPrintable(w io.Writer, value string) error {
+	qpWriter := quotedprintable.NewWriter(w)
+	if _, err := qpWriter.Write([]byte(value)); err != nil {
+		return err
+	}
+	return qpWriter.Close()
+}
+
+func writeBase64Lines(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 0 {
+		lineLength := maxLineLength
+		if len(encoded) < lineLength {
+			lineLength = len(encoded)
+		}
+		if _, err := io.WriteString(w, encoded[:lineLength]+"\r\n"); err != nil {
+			return err
+		}
+		encoded = encoded[lineLength:]
+	}
+	return nil
+}
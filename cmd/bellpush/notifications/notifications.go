@@ -0,0 +1,290 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/stuartleeks/pi-bell/cmd/bellpush/bellpush"
+	"github.com/stuartleeks/pi-bell/internal/pkg/events"
+	"github.com/stuartleeks/pi-bell/internal/pkg/logging"
+)
+
+var logger = logging.New("component", "notifications")
+
+// testNotificationTimeout is the longest that SendTest waits for a notifier, including its retries
+const testNotificationTimeout = 30 * time.Second
+
+// Kind indicates why a notification is being sent
+type Kind int
+
+const (
+	// KindRing is sent when the bell is rung
+	KindRing Kind = iota
+	// KindWarning is sent when something needs attention, e.g. a chime has been disconnected
+	KindWarning
+)
+
+// Notification is the information sent to notifiers
+type Notification struct {
+	Kind     Kind
+	Title    string
+	Message  string
+	DoorName string
+	Time     time.Time
+	// URL is the bellpush home page (may be empty)
+	URL string
+	// Snapshot is the latest webcam frame as a JPEG (may be empty)
+	Snapshot []byte
+}
+
+// Notifier sends notifications to an external service
+type Notifier interface {
+	Name() string
+	// Notify sends the notification, giving up (including on any retries) when ctx is done
+	Notify(ctx context.Context, notification Notification) error
+}
+
+type registeredNotifier struct {
+	notifier    Notifier
+	minInterval time.Duration
+	lastSent    time.Time
+	// chimeDisconnectWarning overrides Dispatcher.chimeDisconnectWarning for the notifier (if not nil)
+	chimeDisconnectWarning *time.Duration
+}
+
+// disconnectWarning returns how long a chime can be disconnected before the notifier is sent a warning
+// (0 if it shouldn't be sent warnings)
+func (n *registeredNotifier) disconnectWarning(defaultWarning time.Duration) time.Duration {
+	if n.chimeDisconnectWarning != nil {
+		return *n.chimeDisconnectWarning
+	}
+	return defaultWarning
+}
+
+// Dispatcher listens for events on the bellpush and sends notifications to the registered notifiers
+type Dispatcher struct {
+	bellPush *bellpush.BellPush
+	doorName string
+
+	// notifiersLock also guards homePageURL and chimeDisconnectWarning as they can change on config reload
+	notifiersLock          sync.Mutex
+	notifiers              []*registeredNotifier
+	homePageURL            string
+	chimeDisconnectWarning time.Duration
+
+	disconnectTimersLock sync.Mutex
+	// disconnectTimers holds the timers for the disconnect warnings for each chime (one for each notifier)
+	disconnectTimers map[string][]*time.Timer
+}
+
+func NewDispatcher(bellPush *bellpush.BellPush, doorName string) *Dispatcher {
+	return &Dispatcher{
+		bellPush:               bellPush,
+		doorName:               doorName,
+		chimeDisconnectWarning: DefaultConfig().ChimeDisconnectWarning,
+		disconnectTimers:       make(map[string][]*time.Timer),
+	}
+}
+
+// AddNotifier registers a notifier. Ring notifications are not sent to the notifier more often
+// than minInterval, so repeated presses in quick succession only result in a single notification
+func (d *Dispatcher) AddNotifier(notifier Notifier, minInterval time.Duration) {
+	d.notifiersLock.Lock()
+	defer d.notifiersLock.Unlock()
+	d.notifiers = append(d.notifiers, &registeredNotifier{
+		notifier:    notifier,
+		minInterval: minInterval,
+	})
+}
+
+// Configure replaces the notifiers and settings with those from config. This is safe to call while running
+// (e.g. when the config file is reloaded). The time that each notifier last sent a ring notification is kept
+// so that reloading doesn't reset the minimum interval
+func (d *Dispatcher) Configure(config Config) {
+	notifiers := []*registeredNotifier{}
+	if config.SMTP.Enabled() {
+		notifiers = append(notifiers, &registeredNotifier{
+			notifier:               NewSMTPNotifier(config.SMTP),
+			minInterval:            config.SMTP.MinInterval,
+			chimeDisconnectWarning: config.SMTP.ChimeDisconnectWarning,
+		})
+	}
+	for _, pushConfig := range []PushConfig{config.Ntfy, config.Gotify} {
+		if pushConfig.Enabled() {
+			notifiers = append(notifiers, &registeredNotifier{
+				notifier:               NewPushNotifier(pushConfig),
+				minInterval:            pushConfig.MinInterval,
+				chimeDisconnectWarning: pushConfig.ChimeDisconnectWarning,
+			})
+		}
+	}
+
+	d.notifiersLock.Lock()
+	defer d.notifiersLock.Unlock()
+	for _, n := range notifiers {
+		for _, existing := range d.notifiers {
+			if existing.notifier.Name() == n.notifier.Name() {
+				n.lastSent = existing.lastSent
+			}
+		}
+	}
+	d.notifiers = notifiers
+	d.homePageURL = config.HomePageURL
+	d.chimeDisconnectWarning = config.ChimeDisconnectWarning
+}
+
+// NotifierNames returns the names of the registered notifiers
+func (d *Dispatcher) NotifierNames() []string {
+	d.notifiersLock.Lock()
+	defer d.notifiersLock.Unlock()
+	names := make([]string, 0, len(d.notifiers))
+	for _, n := range d.notifiers {
+		names = append(names, n.notifier.Name())
+	}
+	return names
+}
+
+// Start begins listening for events from the bellpush
+func (d *Dispatcher) Start() {
+	d.bellPush.AddEventListener(d.handleEvent)
+}
+
+// SendTest sends a test notification to the named notifier and waits for the result, for up to
+// testNotificationTimeout or until ctx is done
+func (d *Dispatcher) SendTest(ctx context.Context, name string) error {
+	d.notifiersLock.Lock()
+	var notifier Notifier
+	for _, n := range d.notifiers {
+		if n.notifier.Name() == name {
+			notifier = n.notifier
+		}
+	}
+	d.notifiersLock.Unlock()
+	if notifier == nil {
+		return fmt.Errorf("unknown notifier: %q", name)
+	}
+
+	notification := d.newNotification(KindRing, "Doorbell: test notification", fmt.Sprintf("Test notification from the %s doorbell", d.doorName))
+	ctx, cancel := context.WithTimeout(ctx, testNotificationTimeout)
+	defer cancel()
+	return notifier.Notify(ctx, notification)
+}
+
+func (d *Dispatcher) newNotification(kind Kind, title string, message string) Notification {
+	d.notifiersLock.Lock()
+	homePageURL := d.homePageURL
+	d.notifiersLock.Unlock()
+	notification := Notification{
+		Kind:     kind,
+		Title:    title,
+		Message:  message,
+		DoorName: d.doorName,
+		Time:     time.Now(),
+		URL:      homePageURL,
+	}
+	if kind == KindRing {
+		notification.Snapshot = d.bellPush.GetWebcamFrame()
+	}
+	return notification
+}
+
+func (d *Dispatcher) handleEvent(chimeName string, event events.Event) {
+	switch e := event.(type) {
+	case *events.ButtonEvent:
+		if chimeName == "" && e.ButtonEventType == events.ButtonPressed {
+			d.notifyRing(e)
+		}
+		if chimeName == "" && e.ButtonEventType == events.ButtonStuck {
+			d.notifyButtonStuck(e)
+		}
+	case *events.ChimeStatusEvent:
+		d.trackChimeStatus(e.ChimeName, e.Connected)
+	}
+}
+
+func (d *Dispatcher) notifyRing(event *events.ButtonEvent) {
+	ringLogger := logger.WithCorrelationID(event.ID)
+	notification := d.newNotification(
+		KindRing,
+		fmt.Sprintf("Doorbell: %s", d.doorName),
+		fmt.Sprintf("Someone rang the %s doorbell at %s", d.doorName, time.Now().Format("15:04:05 on Mon 2 Jan")),
+	)
+
+	d.notifiersLock.Lock()
+	defer d.notifiersLock.Unlock()
+	for _, n := range d.notifiers {
+		if notification.Time.Sub(n.lastSent) < n.minInterval {
+			ringLogger.Info("Skipping notification - sent too recently", "notifier", n.notifier.Name(), "lastSent", n.lastSent)
+			continue
+		}
+		n.lastSent = notification.Time
+		d.send(ringLogger, n.notifier, notification)
+	}
+}
+
+// notifyButtonStuck sends a warning that the button is stuck, e.g. jammed or with a shorted wire
+func (d *Dispatcher) notifyButtonStuck(event *events.ButtonEvent) {
+	notification := d.newNotification(
+		KindWarning,
+		fmt.Sprintf("Doorbell button stuck: %s", d.doorName),
+		fmt.Sprintf("The %s doorbell button has been held since %s. The bell won't ring again until it is released", d.doorName, event.Time.Format("15:04:05 on Mon 2 Jan")),
+	)
+	d.notifiersLock.Lock()
+	defer d.notifiersLock.Unlock()
+	for _, n := range d.notifiers {
+		d.send(logger.WithCorrelationID(event.ID), n.notifier, notification)
+	}
+}
+
+// trackChimeStatus starts a timer for each notifier when a chime disconnects so that the notifier is sent a
+// warning if the chime doesn't reconnect within the notifier's ChimeDisconnectWarning
+func (d *Dispatcher) trackChimeStatus(chimeName string, connected bool) {
+	type pendingWarning struct {
+		notifier Notifier
+		after    time.Duration
+	}
+	warnings := []pendingWarning{}
+	d.notifiersLock.Lock()
+	for _, n := range d.notifiers {
+		if after := n.disconnectWarning(d.chimeDisconnectWarning); after > 0 {
+			warnings = append(warnings, pendingWarning{notifier: n.notifier, after: after})
+		}
+	}
+	d.notifiersLock.Unlock()
+
+	d.disconnectTimersLock.Lock()
+	defer d.disconnectTimersLock.Unlock()
+
+	for _, timer := range d.disconnectTimers[chimeName] {
+		timer.Stop()
+	}
+	delete(d.disconnectTimers, chimeName)
+	if connected {
+		return
+	}
+	for _, warning := range warnings {
+		warning := warning
+		timer := time.AfterFunc(warning.after, func() {
+			notification := d.newNotification(
+				KindWarning,
+				fmt.Sprintf("Chime disconnected: %s", chimeName),
+				fmt.Sprintf("The %q chime has been disconnected from the %s doorbell for more than %s", chimeName, d.doorName, warning.after),
+			)
+			d.send(logger.With("chime", chimeName), warning.notifier, notification)
+		})
+		d.disconnectTimers[chimeName] = append(d.disconnectTimers[chimeName], timer)
+	}
+}
+
+// send notifies in the background so that a slow notification service doesn't delay event handling
+func (d *Dispatcher) send(sendLogger *logging.Logger, notifier Notifier, notification Notification) {
+	go func() {
+		if err := notifier.Notify(context.Background(), notification); err != nil {
+			sendLogger.Error("Error sending notification", "notifier", notifier.Name(), "err", err)
+			return
+		}
+		sendLogger.Info("Sent notification", "notifier", notifier.Name())
+	}()
+}
@@ -0,0 +1,242 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stuartleeks/pi-bell/internal/pkg/configfile"
+)
+
+// PushService identifies the HTTP push notification service to send to
+type PushService string
+
+const (
+	// PushServiceNtfy sends to an ntfy topic (https://ntfy.sh)
+	PushServiceNtfy PushService = "ntfy"
+	// PushServiceGotify sends to a Gotify application (https://gotify.net)
+	PushServiceGotify PushService = "gotify"
+)
+
+type PushConfig struct {
+	Service PushService `yaml:"-"`
+	// URL is the topic URL for ntfy (e.g. https://ntfy.sh/my-doorbell) or the server URL for Gotify.
+	// Notifications to the service are disabled if this is empty
+	URL string `yaml:"url" env:"URL"`
+	// Token is the access token for ntfy or the application token for Gotify
+	Token           string `yaml:"token" env:"TOKEN"`
+	RingPriority    int    `yaml:"ringPriority" env:"RING_PRIORITY"`
+	WarningPriority int    `yaml:"warningPriority" env:"WARNING_PRIORITY"`
+	// Retries is the number of times to retry a failed send
+	Retries int `yaml:"retries" env:"RETRIES"`
+	// MinInterval is the minimum time between ring notifications
+	MinInterval time.Duration `yaml:"minInterval" env:"MIN_INTERVAL"`
+	// ChimeDisconnectWarning overrides the chimeDisconnectWarning setting for the service (0 to disable)
+	ChimeDisconnectWarning *time.Duration `yaml:"chimeDisconnectWarning" env:"CHIME_DISCONNECT_WARNING"`
+}
+
+// DefaultPushConfig returns the default settings for the service
+func DefaultPushConfig(service PushService) PushConfig {
+	config := PushConfig{
+		Service:     service,
+		Retries:     3,
+		MinInterval: 1 * time.Minute,
+	}
+	switch service {
+	case PushServiceNtfy:
+		// ntfy priorities are 1-5
+		config.RingPriority = 4
+		config.WarningPriority = 3
+	case PushServiceGotify:
+		// Gotify priorities are 0-10
+		config.RingPriority = 8
+		config.WarningPriority = 5
+	}
+	return config
+}
+
+// Enabled returns true if the service URL has been configured
+func (c PushConfig) Enabled() bool {
+	return c.URL != ""
+}
+
+// Validate checks the settings, adding any problems to errs
+func (c PushConfig) Validate(errs *configfile.Errors, path string) {
+	if !c.Enabled() {
+		return
+	}
+	if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs.Add(configfile.Join(path, "url"), "invalid URL %q: expected an http or https URL", c.URL)
+	}
+	minPriority, maxPriority := 1, 5
+	if c.Service == PushServiceGotify {
+		minPriority, maxPriority = 0, 10
+	}
+	if c.RingPriority < minPriority || c.RingPriority > maxPriority {
+		errs.Add(configfile.Join(path, "ringPriority"), "invalid priority %d: %s priorities are %d-%d", c.RingPriority, c.Service, minPriority, maxPriority)
+	}
+	if c.WarningPriority < minPriority || c.WarningPriority > maxPriority {
+		errs.Add(configfile.Join(path, "warningPriority"), "invalid priority %d: %s priorities are %d-%d", c.WarningPriority, c.Service, minPriority, maxPriority)
+	}
+	if c.Retries < 0 {
+		errs.Add(configfile.Join(path, "retries"), "can't be negative")
+	}
+	if c.MinInterval < 0 {
+		errs.Add(configfile.Join(path, "minInterval"), "can't be negative")
+	}
+	if c.ChimeDisconnectWarning != nil && *c.ChimeDisconnectWarning < 0 {
+		errs.Add(configfile.Join(path, "chimeDisconnectWarning"), "can't be negative")
+	}
+}
+
+// PushNotifier sends notifications to self-hosted push services such as ntfy and Gotify
+type PushNotifier struct {
+	config     PushConfig
+	httpClient *http.Client
+	// retryDelay is the delay before the first retry, which increases by the same amount for each retry
+	retryDelay time.Duration
+}
+
+var _ Notifier = &PushNotifier{}
+
+func NewPushNotifier(config PushConfig) *PushNotifier {
+	return &PushNotifier{
+		config:     config,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		retryDelay: 2 * time.Second,
+	}
+}
+
+func (n *PushNotifier) Name() string {
+	return string(n.config.Service)
+}
+
+func (n *PushNotifier) Notify(ctx context.Context, notification Notification) error {
+	var err error
+	for attempt := 0; attempt <= n.config.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Duration(attempt) * n.retryDelay):
+			case <-ctx.Done():
+				return fmt.Errorf("gave up after %d attempts: %w", attempt, err)
+			}
+		}
+		var retry bool
+		retry, err = n.send(ctx, notification)
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return fmt.Errorf("failed after %d retries: %w", n.config.Retries, err)
+}
+
+func (n *PushNotifier) priority(notification Notification) int {
+	if notification.Kind == KindWarning {
+		return n.config.WarningPriority
+	}
+	return n.config.RingPriority
+}
+
+// send sends the notification, returning whether a failure should be retried
+func (n *PushNotifier) send(ctx context.Context, notification Notification) (bool, error) {
+	var request *http.Request
+	var err error
+	switch n.config.Service {
+	case PushServiceNtfy:
+		request, err = n.ntfyRequest(ctx, notification)
+	case PushServiceGotify:
+		request, err = n.gotifyRequest(ctx, notification)
+	default:
+		return false, fmt.Errorf("unsupported push service %q", n.config.Service)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	response, err := n.httpClient.Do(request)
+	if err != nil {
+		return true, fmt.Errorf("error sending request: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+	err = fmt.Errorf("unexpected status %d: %s", response.StatusCode, strings.TrimSpace(string(body)))
+	retry := response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests
+	return retry, err
+}
+
+// ntfyRequest builds a request for ntfy - see https://docs.ntfy.sh/publish/
+// The snapshot is sent as the request body (an attachment) with the message in a header
+func (n *PushNotifier) ntfyRequest(ctx context.Context, notification Notification) (*http.Request, error) {
+	var body io.Reader = strings.NewReader(notification.Message)
+	if len(notification.Snapshot) > 0 {
+		body = bytes.NewReader(notification.Snapshot)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut, n.config.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Title", notification.Title)
+	request.Header.Set("Priority", strconv.Itoa(n.priority(notification)))
+	if notification.Kind == KindWarning {
+		request.Header.Set("Tags", "warning")
+	} else {
+		request.Header.Set("Tags", "bell")
+	}
+	if notification.URL != "" {
+		request.Header.Set("Click", notification.URL)
+	}
+	if len(notification.Snapshot) > 0 {
+		request.Header.Set("Message", notification.Message)
+		request.Header.Set("Filename", fmt.Sprintf("doorbell-%s.jpg", notification.Time.Format("20060102-150405")))
+	}
+	if n.config.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+n.config.Token)
+	}
+	return request, nil
+}
+
+// gotifyRequest builds a request for Gotify - see https://gotify.net/docs/pushmsg
+// Gotify doesn't support attachments so the notification links to the latest webcam image instead
+func (n *PushNotifier) gotifyRequest(ctx context.Context, notification Notification) (*http.Request, error) {
+	extras := map[string]interface{}{}
+	if notification.URL != "" {
+		clientNotification := map[string]interface{}{
+			"click": map[string]string{"url": notification.URL},
+		}
+		if len(notification.Snapshot) > 0 {
+			clientNotification["bigImageUrl"] = strings.TrimSuffix(notification.URL, "/") + "/camera/latest"
+		}
+		extras["client::notification"] = clientNotification
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"title":    notification.Title,
+		"message":  notification.Message,
+		"priority": n.priority(notification),
+		"extras":   extras,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	messageURL, err := url.JoinPath(n.config.URL, "message")
+	if err != nil {
+		return nil, fmt.Errorf("invalid Gotify URL: %w", err)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, messageURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Gotify-Key", n.config.Token)
+	return request, nil
+}
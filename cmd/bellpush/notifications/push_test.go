@@ -0,0 +1,221 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pushRequest is a request received by newPushServer
+type pushRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   []byte
+	time   time.Time
+}
+
+// newPushServer starts a server that records the requests and replies with the next status in statuses
+// (200 once they have been used)
+func newPushServer(t *testing.T, statuses ...int) (*httptest.Server, func() []pushRequest) {
+	t.Helper()
+	var mutex sync.Mutex
+	var requests []pushRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		requests = append(requests, pushRequest{method: r.Method, path: r.URL.Path, header: r.Header, body: body, time: time.Now()})
+		status := http.StatusOK
+		if len(statuses) > 0 {
+			status, statuses = statuses[0], statuses[1:]
+		}
+		mutex.Unlock()
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(http.StatusText(status)))
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []pushRequest {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]pushRequest(nil), requests...)
+	}
+}
+
+// newTestPushNotifier returns a notifier for service at url with a short retry delay
+func newTestPushNotifier(service PushService, url string, update func(config *PushConfig)) *PushNotifier {
+	config := DefaultPushConfig(service)
+	config.URL = url
+	if update != nil {
+		update(&config)
+	}
+	notifier := NewPushNotifier(config)
+	notifier.retryDelay = 20 * time.Millisecond
+	return notifier
+}
+
+var testRingNotification = Notification{
+	Kind:     KindRing,
+	Title:    "Doorbell rang",
+	Message:  "Someone is at the front door",
+	DoorName: "front",
+	Time:     time.Date(2024, 1, 6, 12, 0, 0, 0, time.UTC),
+	URL:      "http://bellpush.local:8080/",
+	Snapshot: []byte{0xff, 0xd8, 0xff, 0xd9},
+}
+
+func TestNtfyRequest(t *testing.T) {
+	server, requests := newPushServer(t)
+	notifier := newTestPushNotifier(PushServiceNtfy, server.URL+"/doorbell", func(config *PushConfig) { config.Token = "tk_test" })
+
+	if err := notifier.Notify(context.Background(), testRingNotification); err != nil {
+		t.Fatalf("Notify returned an error: %v", err)
+	}
+	warning := Notification{Kind: KindWarning, Title: "Chime disconnected", Message: "kitchen has been disconnected for 10m"}
+	if err := notifier.Notify(context.Background(), warning); err != nil {
+		t.Fatalf("Notify returned an error: %v", err)
+	}
+
+	received := requests()
+	if len(received) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(received))
+	}
+	ring := received[0]
+	if ring.method != http.MethodPut || ring.path != "/doorbell" {
+		t.Errorf("expected PUT /doorbell, got %s %s", ring.method, ring.path)
+	}
+	expectedHeaders := map[string]string{
+		"Title":         "Doorbell rang",
+		"Priority":      "4",
+		"Tags":          "bell",
+		"Click":         "http://bellpush.local:8080/",
+		"Message":       "Someone is at the front door",
+		"Filename":      "doorbell-20240106-120000.jpg",
+		"Authorization": "Bearer tk_test",
+	}
+	for name, expected := range expectedHeaders {
+		if actual := ring.header.Get(name); actual != expected {
+			t.Errorf("expected %s header %q, got %q", name, expected, actual)
+		}
+	}
+	if string(ring.body) != string(testRingNotification.Snapshot) {
+		t.Errorf("expected the snapshot as the body, got %q", ring.body)
+	}
+
+	// without a snapshot the message is the body
+	warningRequest := received[1]
+	if warningRequest.header.Get("Priority") != "3" || warningRequest.header.Get("Tags") != "warning" {
+		t.Errorf("expected the warning priority and tag, got %q and %q", warningRequest.header.Get("Priority"), warningRequest.header.Get("Tags"))
+	}
+	if string(warningRequest.body) != warning.Message || warningRequest.header.Get("Filename") != "" {
+		t.Errorf("expected the message as the body without an attachment, got %q", warningRequest.body)
+	}
+}
+
+func TestGotifyRequest(t *testing.T) {
+	server, requests := newPushServer(t)
+	notifier := newTestPushNotifier(PushServiceGotify, server.URL+"/gotify/", func(config *PushConfig) { config.Token = "app-token" })
+
+	if err := notifier.Notify(context.Background(), testRingNotification); err != nil {
+		t.Fatalf("Notify returned an error: %v", err)
+	}
+
+	received := requests()
+	if len(received) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(received))
+	}
+	request := received[0]
+	if request.method != http.MethodPost || request.path != "/gotify/message" {
+		t.Errorf("expected POST /gotify/message, got %s %s", request.method, request.path)
+	}
+	if request.header.Get("X-Gotify-Key") != "app-token" || request.header.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected headers %v", request.header)
+	}
+
+	var payload struct {
+		Title    string `json:"title"`
+		Message  string `json:"message"`
+		Priority int    `json:"priority"`
+		Extras   struct {
+			Notification struct {
+				Click struct {
+					URL string `json:"url"`
+				} `json:"click"`
+				BigImageURL string `json:"bigImageUrl"`
+			} `json:"client::notification"`
+		} `json:"extras"`
+	}
+	if err := json.Unmarshal(request.body, &payload); err != nil {
+		t.Fatalf("error parsing payload %s: %v", request.body, err)
+	}
+	if payload.Title != "Doorbell rang" || payload.Message != "Someone is at the front door" || payload.Priority != 8 {
+		t.Errorf("unexpected payload %s", request.body)
+	}
+	if payload.Extras.Notification.Click.URL != "http://bellpush.local:8080/" || payload.Extras.Notification.BigImageURL != "http://bellpush.local:8080/camera/latest" {
+		t.Errorf("unexpected extras in %s", request.body)
+	}
+}
+
+func TestPushNotifierRetries(t *testing.T) {
+	testCases := []struct {
+		name             string
+		statuses         []int
+		retries          int
+		expectedRequests int
+		expectError      bool
+	}{
+		{name: "succeeds after server errors", statuses: []int{503, 500}, retries: 3, expectedRequests: 3},
+		{name: "retries when rate limited", statuses: []int{429}, retries: 3, expectedRequests: 2},
+		{name: "client errors aren't retried", statuses: []int{401}, retries: 3, expectedRequests: 1, expectError: true},
+		{name: "gives up after the retries", statuses: []int{503, 503, 503}, retries: 2, expectedRequests: 3, expectError: true},
+		{name: "no retries", statuses: []int{503}, retries: 0, expectedRequests: 1, expectError: true},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			server, requests := newPushServer(t, testCase.statuses...)
+			notifier := newTestPushNotifier(PushServiceNtfy, server.URL, func(config *PushConfig) { config.Retries = testCase.retries })
+
+			err := notifier.Notify(context.Background(), testRingNotification)
+			if testCase.expectError && err == nil {
+				t.Error("expected an error")
+			}
+			if !testCase.expectError && err != nil {
+				t.Errorf("Notify returned an error: %v", err)
+			}
+
+			received := requests()
+			if len(received) != testCase.expectedRequests {
+				t.Fatalf("expected %d requests, got %d", testCase.expectedRequests, len(received))
+			}
+			// the delay increases by retryDelay for each retry
+			for i := 1; i < len(received); i++ {
+				if delay := received[i].time.Sub(received[i-1].time); delay < time.Duration(i)*notifier.retryDelay {
+					t.Errorf("expected retry %d after at least %s, got %s", i, time.Duration(i)*notifier.retryDelay, delay)
+				}
+			}
+		})
+	}
+}
+
+func TestPushNotifierStopsRetryingWhenCancelled(t *testing.T) {
+	server, requests := newPushServer(t, 503, 503, 503)
+	notifier := newTestPushNotifier(PushServiceNtfy, server.URL, nil)
+	notifier.retryDelay = time.Minute
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := notifier.Notify(ctx, testRingNotification); err == nil {
+		t.Error("expected an error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected Notify to return when the context was done, took %s", elapsed)
+	}
+	if received := requests(); len(received) != 1 {
+		t.Errorf("expected 1 request, got %d", len(received))
+	}
+}
@@ -0,0 +1,63 @@
+package notifications
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// QuietHours is a daily period during which notifications shouldn't be sent.
+// The period can span midnight, e.g. 22:00-07:00
+type QuietHours struct {
+	// Start and End are offsets from midnight
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseQuietHours parses a value of the form HH:MM-HH:MM
+func ParseQuietHours(value string) (QuietHours, error) {
+	parts := strings.Split(value, "-")
+	if len(parts) != 2 {
+		return QuietHours{}, fmt.Errorf("invalid quiet hours %q: expected HH:MM-HH:MM", value)
+	}
+	start, err := parseTimeOfDay(parts[0])
+	if err != nil {
+		return QuietHours{}, fmt.Errorf("invalid quiet hours %q: %w", value, err)
+	}
+	end, err := parseTimeOfDay(parts[1])
+	if err != nil {
+		return QuietHours{}, fmt.Errorf("invalid quiet hours %q: %w", value, err)
+	}
+	return QuietHours{Start: start, End: end}, nil
+}
+
+// UnmarshalText parses a value of the form HH:MM-HH:MM (e.g. for quietHours in the config file)
+func (q *QuietHours) UnmarshalText(text []byte) error {
+	quietHours, err := ParseQuietHours(string(text))
+	if err != nil {
+		return err
+	}
+	*q = quietHours
+	return nil
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains returns true if t (in local time) falls within the quiet hours
+func (q QuietHours) Contains(t time.Time) bool {
+	if q.Start == q.End {
+		return false
+	}
+	timeOfDay := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if q.Start < q.End {
+		return timeOfDay >= q.Start && timeOfDay < q.End
+	}
+	// spans midnight
+	return timeOfDay >= q.Start || timeOfDay < q.End
+}
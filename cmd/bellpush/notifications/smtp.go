@@ -0,0 +1,348 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/uuid"
+	"github.com/stuartleeks/pi-bell/internal/pkg/configfile"
+	"gopkg.in/yaml.v3"
+)
+
+const smtpTimeout = 30 * time.Second
+
+// SMTPSecurity specifies how the connection to the SMTP server is secured
+type SMTPSecurity string
+
+const (
+	// SMTPSecurityStartTLS connects in plain text and upgrades with STARTTLS (typically port 587)
+	SMTPSecurityStartTLS SMTPSecurity = "starttls"
+	// SMTPSecurityTLS uses implicit TLS (typically port 465)
+	SMTPSecurityTLS SMTPSecurity = "tls"
+	// SMTPSecurityNone doesn't use TLS - only intended for local SMTP servers
+	SMTPSecurityNone SMTPSecurity = "none"
+)
+
+// UnmarshalText parses the security setting (case-insensitive)
+func (s *SMTPSecurity) UnmarshalText(text []byte) error {
+	*s = SMTPSecurity(strings.ToLower(strings.TrimSpace(string(text))))
+	return nil
+}
+
+// SMTPRecipient is an email recipient with optional quiet hours
+type SMTPRecipient struct {
+	Address    string      `yaml:"address"`
+	QuietHours *QuietHours `yaml:"quietHours"`
+}
+
+// SMTPRecipients is a list of email recipients. In the config file this can be a list of
+// address/quietHours objects, a list of strings (e.g. "bob@example.com|22:00-07:00"), or a
+// comma-separated string in the same format as SMTP_TO
+type SMTPRecipients []SMTPRecipient
+
+// UnmarshalText parses a comma-separated list of recipients (see ParseSMTPRecipients)
+func (r *SMTPRecipients) UnmarshalText(text []byte) error {
+	recipients, err := ParseSMTPRecipients(string(text))
+	if err != nil {
+		return err
+	}
+	*r = recipients
+	return nil
+}
+
+// UnmarshalYAML accepts the forms described on SMTPRecipients
+func (r *SMTPRecipients) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return r.UnmarshalText([]byte(node.Value))
+	case yaml.SequenceNode:
+	default:
+		return fmt.Errorf("line %d: expected a list of recipients", node.Line)
+	}
+	recipients := SMTPRecipients{}
+	for _, item := range node.Content {
+		if item.Kind == yaml.ScalarNode {
+			parsed, err := ParseSMTPRecipients(item.Value)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", item.Line, err)
+			}
+			recipients = append(recipients, parsed...)
+			continue
+		}
+		var recipient SMTPRecipient
+		if err := item.Decode(&recipient); err != nil {
+			return err
+		}
+		recipients = append(recipients, recipient)
+	}
+	*r = recipients
+	return nil
+}
+
+type SMTPConfig struct {
+	// Host is the SMTP server. Email notifications are disabled if this is empty
+	Host string `yaml:"host" env:"HOST"`
+	// Port defaults to 587 for starttls, 465 for tls and 25 for none
+	Port       int            `yaml:"port" env:"PORT"`
+	Security   SMTPSecurity   `yaml:"security" env:"SECURITY"`
+	Username   string         `yaml:"username" env:"USERNAME"`
+	Password   string         `yaml:"password" env:"PASSWORD"`
+	From       string         `yaml:"from" env:"FROM"`
+	Recipients SMTPRecipients `yaml:"to" env:"TO"`
+	// MinInterval is the minimum time between ring emails
+	MinInterval time.Duration `yaml:"minInterval" env:"MIN_INTERVAL"`
+	// ChimeDisconnectWarning overrides the chimeDisconnectWarning setting for emails (0 to disable)
+	ChimeDisconnectWarning *time.Duration `yaml:"chimeDisconnectWarning" env:"CHIME_DISCONNECT_WARNING"`
+}
+
+// Enabled returns true if an SMTP server has been configured
+func (c SMTPConfig) Enabled() bool {
+	return c.Host != ""
+}
+
+// Validate checks the settings, adding any problems to errs
+func (c SMTPConfig) Validate(errs *configfile.Errors, path string) {
+	if !c.Enabled() {
+		return
+	}
+	switch c.Security {
+	case "", SMTPSecurityStartTLS, SMTPSecurityTLS, SMTPSecurityNone:
+	default:
+		errs.Add(configfile.Join(path, "security"), "invalid value %q: expected starttls, tls or none", c.Security)
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		errs.Add(configfile.Join(path, "port"), "invalid port %d", c.Port)
+	}
+	if c.From == "" {
+		errs.Add(configfile.Join(path, "from"), "must be set when host is set")
+	} else if _, err := mail.ParseAddress(c.From); err != nil {
+		errs.Add(configfile.Join(path, "from"), "invalid address %q: %v", c.From, err)
+	}
+	if len(c.Recipients) == 0 {
+		errs.Add(configfile.Join(path, "to"), "must list at least one recipient when host is set")
+	}
+	for i, recipient := range c.Recipients {
+		if recipient.Address == "" {
+			errs.Add(fmt.Sprintf("%s[%d]", configfile.Join(path, "to"), i), "address must be set")
+		}
+	}
+	if c.MinInterval < 0 {
+		errs.Add(configfile.Join(path, "minInterval"), "can't be negative")
+	}
+	if c.ChimeDisconnectWarning != nil && *c.ChimeDisconnectWarning < 0 {
+		errs.Add(configfile.Join(path, "chimeDisconnectWarning"), "can't be negative")
+	}
+}
+
+// ParseSMTPRecipients parses a comma-separated list of recipients.
+// Each recipient can specify quiet hours, e.g. "alice@example.com,bob@example.com|22:00-07:00"
+func ParseSMTPRecipients(value string) ([]SMTPRecipient, error) {
+	recipients := []SMTPRecipient{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "|", 2)
+		recipient := SMTPRecipient{Address: strings.TrimSpace(parts[0])}
+		if len(parts) == 2 {
+			quietHours, err := ParseQuietHours(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid recipient %q: %w", item, err)
+			}
+			recipient.QuietHours = &quietHours
+		}
+		recipients = append(recipients, recipient)
+	}
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("no recipients specified")
+	}
+	return recipients, nil
+}
+
+// SMTPNotifier sends notifications as emails with the webcam snapshot attached
+type SMTPNotifier struct {
+	config SMTPConfig
+	// from is the parsed sender, which can include a display name (e.g. "Doorbell <doorbell@example.com>")
+	from *mail.Address
+}
+
+var _ Notifier = &SMTPNotifier{}
+
+func NewSMTPNotifier(config SMTPConfig) *SMTPNotifier {
+	if config.Security == "" {
+		config.Security = SMTPSecurityStartTLS
+	}
+	if config.Port == 0 {
+		switch config.Security {
+		case SMTPSecurityTLS:
+			config.Port = 465
+		case SMTPSecurityNone:
+			config.Port = 25
+		default:
+			config.Port = 587
+		}
+	}
+	from, err := mail.ParseAddress(config.From)
+	if err != nil {
+		// Validate reports invalid addresses, so use the value as it is
+		from = &mail.Address{Address: config.From}
+	}
+	return &SMTPNotifier{config: config, from: from}
+}
+
+func (n *SMTPNotifier) Name() string {
+	return "smtp"
+}
+
+func (n *SMTPNotifier) Notify(ctx context.Context, notification Notification) error {
+	recipients := []string{}
+	for _, recipient := range n.config.Recipients {
+		if recipient.QuietHours != nil && recipient.QuietHours.Contains(notification.Time) {
+			logger.Info("Not emailing recipient - in quiet hours", "recipient", recipient.Address)
+			continue
+		}
+		recipients = append(recipients, recipient.Address)
+	}
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	message, err := n.buildMessage(notification, recipients)
+	if err != nil {
+		return fmt.Errorf("error building email: %w", err)
+	}
+	return n.send(ctx, recipients, message)
+}
+
+func (n *SMTPNotifier) send(ctx context.Context, recipients []string, message []byte) error {
+	addr := net.JoinHostPort(n.config.Host, strconv.Itoa(n.config.Port))
+	tlsConfig := &tls.Config{ServerName: n.config.Host, MinVersion: tls.VersionTLS12}
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+
+	var conn net.Conn
+	var err error
+	if n.config.Security == SMTPSecurityTLS {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: tlsConfig}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %w", addr, err)
+	}
+	deadline := time.Now().Add(smtpTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	_ = conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, n.config.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("error creating SMTP client: %w", err)
+	}
+	defer client.Close()
+
+	if n.config.Security == SMTPSecurityStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("SMTP server %s does not support STARTTLS", addr)
+		}
+		if err = client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("error starting TLS: %w", err)
+		}
+	}
+	if n.config.Username != "" {
+		auth := smtp.PlainAuth("", n.config.Username, n.config.Password, n.config.Host)
+		if err = client.Auth(auth); err != nil {
+			return fmt.Errorf("error authenticating: %w", err)
+		}
+	}
+
+	if err = client.Mail(n.from.Address); err != nil {
+		return fmt.Errorf("error setting sender: %w", err)
+	}
+	for _, recipient := range recipients {
+		if err = client.Rcpt(recipient); err != nil {
+			return fmt.Errorf("error adding recipient %q: %w", recipient, err)
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("error starting message data: %w", err)
+	}
+	if _, err = writer.Write(message); err != nil {
+		return fmt.Errorf("error writing message: %w", err)
+	}
+	if err = writer.Close(); err != nil {
+		return fmt.Errorf("error sending message: %w", err)
+	}
+	return client.Quit()
+}
+
+func (n *SMTPNotifier) buildMessage(notification Notification, recipients []string) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	headers := []string{
+		"From: " + n.from.String(),
+		"To: " + strings.Join(recipients, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", notification.Title),
+		"Date: " + notification.Time.Format(time.RFC1123Z),
+		"Message-ID: " + n.messageID(),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/mixed; boundary=" + writer.Boundary(),
+	}
+	buf.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+
+	textPart, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = writeQuotedPrintable(textPart, notification.Message); err != nil {
+		return nil, err
+	}
+
+	if len(notification.Snapshot) > 0 {
+		filename := fmt.Sprintf("doorbell-%s.jpg", notification.Time.Format("20060102-150405"))
+		imagePart, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"image/jpeg"},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", filename)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err = writeBase64Lines(imagePart, notification.Snapshot); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// messageID returns a unique Message-ID header value using the domain of the sender address
+func (n *SMTPNotifier) messageID() string {
+	domain := n.config.Host
+	if at := strings.LastIndex(n.from.Address, "@"); at >= 0 {
+		domain = n.from.Address[at+1:]
+	}
+	return fmt.Sprintf("<%s@%s>", uuid.Must(uuid.NewV4()), domain)
+}
@@ -0,0 +1,261 @@
+package notifications
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTPMessage is a message received by fakeSMTPServer
+type fakeSMTPMessage struct {
+	from       string
+	recipients []string
+	data       string
+}
+
+// fakeSMTPServer is a minimal plain text SMTP server that accepts every message
+type fakeSMTPServer struct {
+	listener net.Listener
+	messages chan fakeSMTPMessage
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	server := &fakeSMTPServer{listener: listener, messages: make(chan fakeSMTPMessage, 10)}
+	t.Cleanup(func() { listener.Close() })
+	go server.serve()
+	return server
+}
+
+func (s *fakeSMTPServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSMTPServer) handle(netConn net.Conn) {
+	conn := textproto.NewConn(netConn)
+	defer conn.Close()
+
+	message := fakeSMTPMessage{}
+	_ = conn.PrintfLine("220 localhost fake SMTP")
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO", "HELO":
+			_ = conn.PrintfLine("250 localhost")
+		case "MAIL":
+			message.from = line[len("MAIL FROM:"):]
+			_ = conn.PrintfLine("250 OK")
+		case "RCPT":
+			message.recipients = append(message.recipients, line[len("RCPT TO:"):])
+			_ = conn.PrintfLine("250 OK")
+		case "DATA":
+			_ = conn.PrintfLine("354 Go ahead")
+			data, err := io.ReadAll(conn.DotReader())
+			if err != nil {
+				return
+			}
+			message.data = string(data)
+			s.messages <- message
+			_ = conn.PrintfLine("250 OK")
+		case "QUIT":
+			_ = conn.PrintfLine("221 Bye")
+			return
+		default:
+			_ = conn.PrintfLine("502 Not implemented")
+		}
+	}
+}
+
+func TestSMTPNotifierSendsMessage(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	quietHours, err := ParseQuietHours("00:00-23:59")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier := NewSMTPNotifier(SMTPConfig{
+		Host:     "127.0.0.1",
+		Port:     server.port(),
+		Security: SMTPSecurityNone,
+		From:     "doorbell@example.com",
+		Recipients: SMTPRecipients{
+			{Address: "alice@example.com"},
+			{Address: "bob@example.com", QuietHours: &quietHours},
+		},
+	})
+
+	notificationTime := time.Date(2024, 1, 6, 12, 0, 0, 0, time.Local)
+	err = notifier.Notify(context.Background(), Notification{
+		Kind:     KindRing,
+		Title:    "Doorbell rang",
+		Message:  "Someone is at the front door",
+		DoorName: "front",
+		Time:     notificationTime,
+		Snapshot: []byte{0xff, 0xd8, 0xff, 0xd9},
+	})
+	if err != nil {
+		t.Fatalf("Notify returned an error: %v", err)
+	}
+
+	var received fakeSMTPMessage
+	select {
+	case received = <-server.messages:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+
+	if received.from != "<doorbell@example.com>" {
+		t.Errorf("unexpected sender %q", received.from)
+	}
+	if len(received.recipients) != 1 || received.recipients[0] != "<alice@example.com>" {
+		t.Errorf("expected only alice (bob is in quiet hours), got %v", received.recipients)
+	}
+
+	message, err := mail.ReadMessage(strings.NewReader(received.data))
+	if err != nil {
+		t.Fatalf("error parsing message: %v", err)
+	}
+	if subject := message.Header.Get("Subject"); !strings.Contains(subject, "Doorbell") {
+		t.Errorf("unexpected subject %q", subject)
+	}
+	messageID := message.Header.Get("Message-ID")
+	if !strings.HasPrefix(messageID, "<") || !strings.HasSuffix(messageID, "@example.com>") {
+		t.Errorf("unexpected Message-ID %q", messageID)
+	}
+	if !strings.Contains(received.data, "Content-Type: image/jpeg") {
+		t.Error("expected the snapshot to be attached")
+	}
+}
+
+func TestSMTPNotifierSendsFromDisplayName(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	notifier := NewSMTPNotifier(SMTPConfig{
+		Host:       "127.0.0.1",
+		Port:       server.port(),
+		Security:   SMTPSecurityNone,
+		From:       "Front Door Bell <doorbell@example.com>",
+		Recipients: SMTPRecipients{{Address: "alice@example.com"}},
+	})
+
+	err := notifier.Notify(context.Background(), Notification{Kind: KindRing, Title: "Doorbell rang", Time: time.Date(2024, 1, 6, 12, 0, 0, 0, time.Local)})
+	if err != nil {
+		t.Fatalf("Notify returned an error: %v", err)
+	}
+	var received fakeSMTPMessage
+	select {
+	case received = <-server.messages:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+
+	// the envelope sender is only the address, and the From header keeps the display name
+	if received.from != "<doorbell@example.com>" {
+		t.Errorf("unexpected sender %q", received.from)
+	}
+	message, err := mail.ReadMessage(strings.NewReader(received.data))
+	if err != nil {
+		t.Fatalf("error parsing message: %v", err)
+	}
+	from, err := mail.ParseAddress(message.Header.Get("From"))
+	if err != nil {
+		t.Fatalf("error parsing From header %q: %v", message.Header.Get("From"), err)
+	}
+	if from.Name != "Front Door Bell" || from.Address != "doorbell@example.com" {
+		t.Errorf("unexpected From header %q", message.Header.Get("From"))
+	}
+	if messageID := message.Header.Get("Message-ID"); !strings.HasSuffix(messageID, "@example.com>") {
+		t.Errorf("unexpected Message-ID %q", messageID)
+	}
+}
+
+func TestSMTPNotifierSkipsRecipientsInQuietHours(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	quietHours, err := ParseQuietHours("00:00-23:59")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier := NewSMTPNotifier(SMTPConfig{
+		Host:       "127.0.0.1",
+		Port:       server.port(),
+		Security:   SMTPSecurityNone,
+		From:       "doorbell@example.com",
+		Recipients: SMTPRecipients{{Address: "bob@example.com", QuietHours: &quietHours}},
+	})
+
+	err = notifier.Notify(context.Background(), Notification{Kind: KindRing, Title: "Doorbell rang", Time: time.Date(2024, 1, 6, 12, 0, 0, 0, time.Local)})
+	if err != nil {
+		t.Fatalf("Notify returned an error: %v", err)
+	}
+	select {
+	case <-server.messages:
+		t.Fatal("expected no message to be sent")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSMTPNotifierReturnsServerErrors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte("554 No SMTP service here\r\n"))
+	}()
+
+	notifier := NewSMTPNotifier(SMTPConfig{
+		Host:       "127.0.0.1",
+		Port:       listener.Addr().(*net.TCPAddr).Port,
+		Security:   SMTPSecurityNone,
+		From:       "doorbell@example.com",
+		Recipients: SMTPRecipients{{Address: "alice@example.com"}},
+	})
+	if err := notifier.Notify(context.Background(), Notification{Kind: KindRing, Title: "Doorbell rang", Time: time.Now()}); err == nil {
+		t.Fatal("expected an error from a server that rejects the connection")
+	}
+}
+
+func TestNewSMTPNotifierDefaultPorts(t *testing.T) {
+	tests := []struct {
+		security SMTPSecurity
+		port     int
+	}{
+		{"", 587},
+		{SMTPSecurityStartTLS, 587},
+		{SMTPSecurityTLS, 465},
+		{SMTPSecurityNone, 25},
+	}
+	for _, test := range tests {
+		notifier := NewSMTPNotifier(SMTPConfig{Host: "smtp.example.com", Security: test.security})
+		if notifier.config.Port != test.port {
+			t.Errorf("security %q: expected port %d, got %d", test.security, test.port, notifier.config.Port)
+		}
+	}
+}
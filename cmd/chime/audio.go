@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/stuartleeks/pi-bell/internal/pkg/audio"
+	"github.com/stuartleeks/pi-bell/internal/pkg/configfile"
+	"github.com/stuartleeks/pi-bell/internal/pkg/logging"
+)
+
+// DoorSounds selects a sound file by door name. In environment variables this is a comma-separated
+// list of door=file pairs, e.g. AUDIO_DOORS=Front door=/usr/share/sounds/front.wav
+type DoorSounds map[string]string
+
+// UnmarshalText parses a comma-separated list of door=file pairs
+func (d *DoorSounds) UnmarshalText(text []byte) error {
+	doors, err := parseDoorValues(string(text), "sound")
+	if err != nil {
+		return err
+	}
+	*d = doors
+	return nil
+}
+
+// AudioConfig holds the settings for playing a sound when the bell rings, e.g. for rooms without a wired chime
+type AudioConfig struct {
+	// Sink is where sounds are played: aplay (a sound card) or file (raw PCM appended to File). Audio is disabled if empty
+	Sink string `yaml:"sink" env:"AUDIO_SINK"`
+	// Device is the ALSA device for the aplay sink (the default device if empty)
+	Device string `yaml:"device" env:"AUDIO_DEVICE"`
+	// File is the file that the file sink appends PCM to
+	File string `yaml:"file" env:"AUDIO_FILE"`
+	// Sound is the WAV or OGG file to play
+	Sound string `yaml:"sound" env:"AUDIO_SOUND"`
+	// Doors overrides the sound for events from specific doors
+	Doors DoorSounds `yaml:"doors" env:"AUDIO_DOORS"`
+	// Patterns overrides the sound for doors that use specific ring patterns
+	Patterns map[string]string `yaml:"patterns"`
+	// Volume is the playback volume as a percentage
+	Volume int `yaml:"volume" env:"AUDIO_VOLUME"`
+	// Relay switches the relay as well as playing the sound
+	Relay bool `yaml:"relay" env:"AUDIO_RELAY"`
+}
+
+// DefaultAudioConfig returns the default audio settings (with audio disabled)
+func DefaultAudioConfig() AudioConfig {
+	return AudioConfig{
+		Volume: 100,
+		Relay:  true,
+	}
+}
+
+// Enabled returns true if a sink has been configured
+func (c AudioConfig) Enabled() bool {
+	return c.Sink != ""
+}
+
+// Validate checks the settings, adding any problems to errs
+func (c AudioConfig) Validate(errs *configfile.Errors, path string) {
+	if !c.Enabled() {
+		return
+	}
+	knownSink := false
+	for _, name := range audio.SinkNames() {
+		knownSink = knownSink || name == c.Sink
+	}
+	if !knownSink {
+		errs.Add(configfile.Join(path, "sink"), "unknown sink %q (expected %s)", c.Sink, strings.Join(audio.SinkNames(), ", "))
+	}
+	if c.Sink == audio.SinkFile && c.File == "" {
+		errs.Add(configfile.Join(path, "file"), "is required for the %s sink", audio.SinkFile)
+	}
+	if c.Volume < 0 || c.Volume > 100 {
+		errs.Add(configfile.Join(path, "volume"), "must be between 0 and 100")
+	}
+	if c.Sound == "" {
+		errs.Add(configfile.Join(path, "sound"), "is required when audio is enabled")
+	}
+	checkSound := func(soundPath string, sound string) {
+		if sound != "" && !audio.SupportedFormat(sound) {
+			errs.Add(soundPath, "unsupported sound file %q (expected %s)", sound, strings.Join(audio.Formats, " or "))
+		}
+	}
+	checkSound(configfile.Join(path, "sound"), c.Sound)
+	for door, sound := range c.Doors {
+		checkSound(configfile.Join(configfile.Join(path, "doors"), door), sound)
+	}
+	for pattern, sound := range c.Patterns {
+		checkSound(configfile.Join(configfile.Join(path, "patterns"), pattern), sound)
+	}
+}
+
+// soundFor returns the sound file for a ring on door with the named pattern
+func (c AudioConfig) soundFor(door string, pattern string) string {
+	if sound, ok := c.Doors[door]; ok {
+		return sound
+	}
+	if sound, ok := c.Patterns[pattern]; ok {
+		return sound
+	}
+	return c.Sound
+}
+
+// soundFiles returns the distinct sound files in the settings
+func (c AudioConfig) soundFiles() []string {
+	files := map[string]bool{c.Sound: true}
+	for _, sound := range c.Doors {
+		files[sound] = true
+	}
+	for _, sound := range c.Patterns {
+		files[sound] = true
+	}
+	list := make([]string, 0, len(files))
+	for file := range files {
+		list = append(list, file)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// player plays the configured sound for a ring. Only one sound plays at a time
+type player struct {
+	mutex  sync.Mutex
+	config AudioConfig
+	sink   audio.Sink
+	// sounds are the decoded sound files
+	sounds map[string]*audio.Sound
+	// stop cancels the sound that is playing (nil if none)
+	stop context.CancelFunc
+}
+
+func newPlayer() *player {
+	return &player{}
+}
+
+// configure decodes the sound files and creates the sink for config. If this fails then the current settings are kept
+func (p *player) configure(config AudioConfig) error {
+	var sink audio.Sink
+	sounds := map[string]*audio.Sound{}
+	if config.Enabled() {
+		for _, file := range config.soundFiles() {
+			sound, err := audio.Load(file)
+			if err != nil {
+				return err
+			}
+			sounds[file] = sound
+		}
+		var err error
+		if sink, err = audio.NewSink(config.Sink, audio.SinkConfig{Device: config.Device, File: config.File}); err != nil {
+			return fmt.Errorf("failed to create %s sink: %w", config.Sink, err)
+		}
+	}
+
+	p.mutex.Lock()
+	oldSink := p.sink
+	p.config = config
+	p.sink = sink
+	p.sounds = sounds
+	p.mutex.Unlock()
+	if oldSink != nil {
+		// a sound that is still playing on the old sink is allowed to finish
+		if err := oldSink.Close(); err != nil {
+			logger.Warn("Error closing audio sink", "err", err)
+		}
+	}
+	if config.Enabled() {
+		logger.Info("Audio configured", "sink", config.Sink, "sounds", len(sounds), "volume", config.Volume, "relay", config.Relay)
+	}
+	return nil
+}
+
+// switchesRelay returns true if the relay should be switched for button events (i.e. audio is disabled or
+// configured to play as well as the relay)
+func (p *player) switchesRelay() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return !p.config.Enabled() || p.config.Relay
+}
+
+// playsSounds returns true if audio is enabled
+func (p *player) playsSounds() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.sink != nil
+}
+
+// play starts playing the sound for a ring on door with the named pattern. Returns false if audio is disabled
+// or a sound is already playing
+func (p *player) play(eventLogger *logging.Logger, door string, pattern string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.sink == nil {
+		return false
+	}
+	if p.stop != nil {
+		eventLogger.Info("Sound already playing - ignoring press")
+		return false
+	}
+	file := p.config.soundFor(door, pattern)
+	sound := p.sounds[file].WithVolume(p.config.Volume)
+	ctx, cancel := context.WithCancel(context.Background())
+	p.stop = cancel
+	sink := p.sink
+	eventLogger.Info("Playing sound", "file", file, "duration", sound.Duration(), "volume", p.config.Volume)
+	soundsPlayedCounter.Inc()
+	go func() {
+		defer cancel()
+		if err := sink.Play(ctx, sound); err != nil && ctx.Err() == nil {
+			eventLogger.Error("Error playing sound", "file", file, "err", err)
+			audioErrorsCounter.Inc()
+		}
+		p.mutex.Lock()
+		p.stop = nil
+		p.mutex.Unlock()
+	}()
+	return true
+}
+
+// close stops any sound that is playing and closes the sink
+func (p *player) close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.stop != nil {
+		p.stop()
+	}
+	if p.sink == nil {
+		return nil
+	}
+	err := p.sink.Close()
+	p.sink = nil
+	return err
+}
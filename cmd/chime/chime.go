@@ -0,0 +1,353 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gobuffalo/uuid"
+	"github.com/stuartleeks/pi-bell/internal/pkg/events"
+	"gobot.io/x/gobot/drivers/gpio"
+)
+
+const (
+	// dedupeWindow is how long event IDs are remembered so that an event received from more than one
+	// bellpush (or more than once) is only handled once
+	dedupeWindow = 5 * time.Minute
+
+	// heartbeatInterval is how often the connection loops report that they are running
+	heartbeatInterval = 10 * time.Second
+	// heartbeatTimeout is how long a connection loop can go without reporting before the
+	// systemd watchdog stops being pinged
+	heartbeatTimeout = 6 * heartbeatInterval
+)
+
+// chime holds the state shared by the connections to the bellpushes: the events that have been handled,
+// the snooze state sent by each bellpush, the ringer that switches the relay, the player that plays sounds,
+// the desktop notifier and the connection health
+type chime struct {
+	ringer   *ringer
+	player   *player
+	notifier *desktopNotifier
+
+	mutex sync.Mutex
+	// seen is the time that each event ID was received
+	seen map[uuid.UUID]time.Time
+	// snoozes is the snooze expiry for each bellpush. A snooze only applies to events from the bellpush that sent it
+	snoozes map[string]time.Time
+	// connected is the connection state for each bellpush (or broker for MQTT)
+	connected map[string]bool
+	// bellPushURLs is the HTTP address of each connected bellpush (websocket transport only)
+	bellPushURLs map[string]url.URL
+	// heartbeats is the last time that each connection loop reported that it was running
+	heartbeats map[string]time.Time
+	// snoozeSenders send snooze requests over the connection for each bellpush (or broker for MQTT)
+	snoozeSenders map[string]snoozeSender
+	// healthChanged is signalled when the connection or snooze state changes
+	healthChanged chan struct{}
+	// errorFlash is signalled to flash the status LED for an error
+	errorFlash chan struct{}
+	// authFailed is set for the connections whose last attempt was rejected because of authentication
+	authFailed map[string]bool
+	// configError is set when the config failed to load or apply
+	configError bool
+	// missedRingsUntil is when the status LED stops showing that rings were missed while the chime was disconnected
+	missedRingsUntil time.Time
+}
+
+func newChime(name string, relay *gpio.RelayDriver, ringConfig RingConfig) *chime {
+	return &chime{
+		ringer:        newRinger(relay, ringConfig),
+		player:        newPlayer(),
+		notifier:      newDesktopNotifier(name),
+		seen:          map[uuid.UUID]time.Time{},
+		snoozes:       map[string]time.Time{},
+		connected:     map[string]bool{},
+		bellPushURLs:  map[string]url.URL{},
+		heartbeats:    map[string]time.Time{},
+		snoozeSenders: map[string]snoozeSender{},
+		healthChanged: make(chan struct{}, 1),
+		errorFlash:    make(chan struct{}, 1),
+		authFailed:    map[string]bool{},
+	}
+}
+
+// addConnection registers a connection (initially disconnected) so that it is included in the connection health
+func (c *chime) addConnection(name string) {
+	c.mutex.Lock()
+	c.connected[name] = false
+	c.heartbeats[name] = time.Now()
+	c.mutex.Unlock()
+	connectedGauge.WithLabelValues(name).Set(0)
+	c.notifyHealthChanged()
+}
+
+// setConnected records that the connection for name is connected, which clears any authentication failure
+// from an earlier attempt as soon as the connection succeeds (rather than when it later closes)
+func (c *chime) setConnected(name string) {
+	c.mutex.Lock()
+	c.connected[name] = true
+	c.authFailed[name] = false
+	c.mutex.Unlock()
+	connectedGauge.WithLabelValues(name).Set(1)
+	c.notifyHealthChanged()
+}
+
+// setBellPushURL records the websocket URL that the connection for name is connected to, from which the bellpush's
+// HTTP address is derived
+func (c *chime) setBellPushURL(name string, websocketURL url.URL) {
+	bellPushURL := url.URL{Scheme: "http", Host: websocketURL.Host}
+	if websocketURL.Scheme == "wss" {
+		bellPushURL.Scheme = "https"
+	}
+	c.mutex.Lock()
+	c.bellPushURLs[name] = bellPushURL
+	c.mutex.Unlock()
+}
+
+// bellPushURL returns the HTTP address of the bellpush for name, or nil if it isn't known
+func (c *chime) bellPushURL(name string) *url.URL {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	bellPushURL, ok := c.bellPushURLs[name]
+	if !ok {
+		return nil
+	}
+	return &bellPushURL
+}
+
+// setDisconnected records that the connection for name is disconnected. Any button held on the
+// bellpush is released so that the relay isn't left on
+func (c *chime) setDisconnected(name string) error {
+	c.mutex.Lock()
+	c.connected[name] = false
+	delete(c.bellPushURLs, name)
+	delete(c.snoozeSenders, name)
+	c.mutex.Unlock()
+	var err error
+	if c.ringer.isHeld(name) {
+		logger.Warn("Releasing button held on disconnected bellpush", "bellpush", name)
+		err = c.ringer.release(name)
+	}
+	connectedGauge.WithLabelValues(name).Set(0)
+	c.notifyHealthChanged()
+	return err
+}
+
+func (c *chime) notifyHealthChanged() {
+	select {
+	case c.healthChanged <- struct{}{}:
+	default:
+	}
+}
+
+// flashError flashes the status LED to show that something failed
+func (c *chime) flashError() {
+	select {
+	case c.errorFlash <- struct{}{}:
+	default:
+	}
+}
+
+// health returns the number of connections that are connected and the total number of connections
+func (c *chime) health() (connected int, total int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, isConnected := range c.connected {
+		if isConnected {
+			connected++
+		}
+	}
+	return connected, len(c.connected)
+}
+
+// heartbeat records that the connection loop for name is running
+func (c *chime) heartbeat(name string) {
+	c.mutex.Lock()
+	c.heartbeats[name] = time.Now()
+	c.mutex.Unlock()
+}
+
+// stalledConnections returns the names of the connection loops that haven't reported within heartbeatTimeout
+func (c *chime) stalledConnections() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	stalled := []string{}
+	for name, lastHeartbeat := range c.heartbeats {
+		if time.Since(lastHeartbeat) > heartbeatTimeout {
+			stalled = append(stalled, name)
+		}
+	}
+	sort.Strings(stalled)
+	return stalled
+}
+
+// handleEventMessage parses and handles an event message from bellPush.
+// receivedAt is when the message was read and reportLatency (if not nil) is called with the latency
+// report for button pressed events.
+// Events that have already been received (e.g. from another bellpush) are ignored.
+// Returns an error if event handling should stop
+func (c *chime) handleEventMessage(bellPush string, buf []byte, receivedAt time.Time, reportLatency func(*events.LatencyReport)) error {
+	event, err := events.Decode(buf)
+	if err != nil {
+		logger.Error("Error parsing event", "bellpush", bellPush, "err", err)
+		return nil
+	}
+	if c.isDuplicate(event.GetID(), receivedAt) {
+		duplicateEventsCounter.Inc()
+		logger.WithCorrelationID(event.GetID()).Debug("Ignoring duplicate event", "bellpush", bellPush, "eventType", event.GetType())
+		return nil
+	}
+
+	handlers := events.Handlers{
+		events.EventTypeButton: events.On(func(buttonEvent *events.ButtonEvent) error {
+			return c.handleButtonEvent(bellPush, buttonEvent, receivedAt, reportLatency)
+		}),
+		events.EventTypeSnooze: events.On(func(snoozeEvent *events.SnoozeEvent) error {
+			c.handleSnoozeEvent(bellPush, snoozeEvent)
+			return nil
+		}),
+		events.EventTypeUnSnooze: events.On(func(unsnoozeEvent *events.UnSnoozeEvent) error {
+			c.handleUnSnoozeEvent(bellPush, unsnoozeEvent)
+			return nil
+		}),
+		events.EventTypeMissedRings: events.On(func(missedRingsEvent *events.MissedRingsEvent) error {
+			c.handleMissedRingsEvent(bellPush, missedRingsEvent)
+			return nil
+		}),
+	}
+	return handlers.Dispatch(event, func(event events.Event) error {
+		// e.g. a new event type from a newer bellpush
+		logger.WithCorrelationID(event.GetID()).Warn("Unhandled event type", "bellpush", bellPush, "eventType", event.GetType())
+		return nil
+	})
+}
+
+// isDuplicate records id as seen and returns true if it had already been seen within dedupeWindow
+func (c *chime) isDuplicate(id uuid.UUID, receivedAt time.Time) bool {
+	if id == uuid.Nil {
+		return false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for seenID, seenAt := range c.seen {
+		if receivedAt.Sub(seenAt) > dedupeWindow {
+			delete(c.seen, seenID)
+		}
+	}
+	if _, ok := c.seen[id]; ok {
+		return true
+	}
+	c.seen[id] = receivedAt
+	return false
+}
+
+func (c *chime) handleSnoozeEvent(bellPush string, snoozeEvent *events.SnoozeEvent) {
+	telemetryClient.TrackEvent("snooze-event", map[string]string{
+		"id":           fmt.Sprintf("%v", snoozeEvent.ID),
+		"bellpush":     bellPush,
+		"snoozeExpiry": snoozeEvent.SnoozeExpiry.Format(time.RFC3339),
+	})
+
+	logger.WithCorrelationID(snoozeEvent.ID).Info("Setting snooze", "bellpush", bellPush, "snoozeExpiry", snoozeEvent.SnoozeExpiry)
+	c.mutex.Lock()
+	c.snoozes[bellPush] = snoozeEvent.SnoozeExpiry
+	c.mutex.Unlock()
+	c.notifyHealthChanged()
+}
+
+func (c *chime) handleUnSnoozeEvent(bellPush string, unsnoozeEvent *events.UnSnoozeEvent) {
+	telemetryClient.TrackEvent("unsnooze-event", map[string]string{
+		"id":       fmt.Sprintf("%v", unsnoozeEvent.ID),
+		"bellpush": bellPush,
+	})
+
+	logger.WithCorrelationID(unsnoozeEvent.ID).Info("Canceling snooze", "bellpush", bellPush)
+	c.mutex.Lock()
+	delete(c.snoozes, bellPush)
+	c.mutex.Unlock()
+	c.notifyHealthChanged()
+}
+
+func (c *chime) handleButtonEvent(bellPush string, buttonEvent *events.ButtonEvent, receivedAt time.Time, reportLatency func(*events.LatencyReport)) error {
+	buttonEvent.AddHop(events.HopReceive, receivedAt)
+	eventLogger := logger.WithCorrelationID(buttonEvent.ID).With("bellpush", bellPush)
+	eventLogger.Info("Received button event", "type", events.TypeToString(buttonEvent.ButtonEventType), "door", buttonEvent.Door, "source", buttonEvent.Source)
+
+	telemetryClient.TrackEvent("button-event", map[string]string{
+		"id":       fmt.Sprintf("%v", buttonEvent.ID),
+		"type":     events.TypeToString(buttonEvent.ButtonEventType),
+		"source":   buttonEvent.Source,
+		"bellpush": bellPush,
+	})
+
+	switch buttonEvent.ButtonEventType {
+	// NOTE - logic is inverted - see notes in setup
+	case events.ButtonPressed:
+		if reportLatency != nil {
+			// report even if the relay isn't turned on so that the bellpush can still track the network latency
+			defer func() { reportLatency(events.NewLatencyReport(buttonEvent)) }()
+		}
+		// the bell is ringing now, so there is no need to keep showing the rings missed while disconnected
+		c.clearMissedRings()
+		c.mutex.Lock()
+		snoozeExpiry := c.snoozes[bellPush]
+		c.mutex.Unlock()
+		if snoozeExpiry.After(time.Now()) {
+			eventLogger.Info("Snoozed - not turning relay on", "snoozeExpiry", snoozeExpiry)
+			return nil
+		}
+		c.notifier.notify(eventLogger, c.bellPushURL(bellPush), buttonEvent)
+		c.player.play(eventLogger, buttonEvent.Door, c.ringer.patternForDoor(buttonEvent.Door))
+		if !c.player.switchesRelay() {
+			return nil
+		}
+		turnedOn, err := c.ringer.press(eventLogger, bellPush, buttonEvent.Door)
+		if err != nil {
+			eventLogger.Error("Error turning relay on", "err", err)
+			return err
+		}
+		if !turnedOn {
+			return nil
+		}
+		buttonEvent.AddHop(events.HopRelayOn, time.Now())
+		relayActivationsCounter.Inc()
+		if !buttonEvent.Time.IsZero() {
+			eventToRelayLatency.Observe(time.Since(buttonEvent.Time).Seconds())
+		}
+	case events.ButtonReleased:
+		if err := c.ringer.release(bellPush); err != nil {
+			eventLogger.Error("Error turning relay off", "err", err)
+			return err
+		}
+	case events.ButtonStuck:
+		// the bellpush stops sending presses until the button is released
+		eventLogger.Warn("Button stuck on bellpush - releasing")
+		if err := c.ringer.release(bellPush); err != nil {
+			eventLogger.Error("Error turning relay off", "err", err)
+			return err
+		}
+	case events.ButtonShortPress, events.ButtonLongPress, events.ButtonDoublePress:
+		// the pressed and released events have already rung the bell, so this only plays the pattern for the kind
+		// of press (if there is one)
+		kind := events.TypeToString(buttonEvent.ButtonEventType)
+		if !c.player.switchesRelay() {
+			return nil
+		}
+		c.mutex.Lock()
+		snoozeExpiry := c.snoozes[bellPush]
+		c.mutex.Unlock()
+		if snoozeExpiry.After(time.Now()) {
+			return nil
+		}
+		if !c.ringer.pressClassified(eventLogger, kind) {
+			eventLogger.Debug("No pattern for press kind", "press", kind, "heldFor", buttonEvent.HeldFor)
+		}
+	default:
+		eventLogger.Warn("Unhandled ButtonEventType", "buttonEventType", buttonEvent.ButtonEventType)
+	}
+
+	return nil
+}
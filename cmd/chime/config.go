@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/stuartleeks/pi-bell/internal/pkg/configfile"
+	"github.com/stuartleeks/pi-bell/internal/pkg/logging"
+	"github.com/stuartleeks/pi-bell/internal/pkg/mqttutils"
+	"github.com/stuartleeks/pi-bell/internal/pkg/telemetry"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	transportWebsocket = "websocket"
+	transportMQTT      = "mqtt"
+)
+
+// Config holds the chime settings. See scripts/chime.yaml for an example config file
+type Config struct {
+	// BellPushes are the addresses (host:port) of the bellpushes when using the websocket transport.
+	// If empty, the bellpushes are discovered via mDNS
+	BellPushes BellPushAddresses `yaml:"bellpush" env:"BELLPUSH"`
+	// Name identifies the chime to the bellpush (defaults to the hostname)
+	Name string `yaml:"name" env:"CHIME_NAME"`
+	// Transport selects how the chime receives events: "websocket" (default) or "mqtt"
+	Transport   string `yaml:"transport" env:"CHIME_TRANSPORT"`
+	DisableGPIO bool   `yaml:"disableGpio" env:"DISABLE_GPIO"`
+	// MetricsAddress is the address for the Prometheus metrics listener (disabled if empty)
+	MetricsAddress string `yaml:"metricsAddress" env:"METRICS_ADDR"`
+
+	Log       logging.Config   `yaml:"log"`
+	Telemetry telemetry.Config `yaml:"telemetry"`
+	MQTT      MQTTConfig       `yaml:"mqtt"`
+	Ring      RingConfig       `yaml:"ring"`
+	Audio     AudioConfig      `yaml:"audio"`
+	Desktop   DesktopConfig    `yaml:"desktop"`
+	// SnoozeButton is the optional button on the chime for snoozing it
+	SnoozeButton SnoozeButtonConfig `yaml:"snoozeButton"`
+	StatusLed    StatusLedConfig    `yaml:"statusLed"`
+}
+
+// MQTTConfig holds the settings for the MQTT transport
+type MQTTConfig struct {
+	mqttutils.Config `yaml:",inline"`
+	// BellPush is the node ID of the bellpush to receive events from ("+" for all)
+	BellPush string `yaml:"bellpush" env:"MQTT_BELLPUSH"`
+}
+
+// BellPushAddresses is a list of bellpushes. In the config file this can be a list or a
+// comma-separated string in the same format as BELLPUSH and --addr.
+// Each bellpush can have several addresses (e.g. for a failover pair), separated by | or as a nested
+// list in the config file, which are tried in order
+type BellPushAddresses []string
+
+// UnmarshalText parses a comma-separated list of bellpushes
+func (a *BellPushAddresses) UnmarshalText(text []byte) error {
+	addresses := BellPushAddresses{}
+	for _, address := range strings.Split(string(text), ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	*a = addresses
+	return nil
+}
+
+// UnmarshalYAML accepts the forms described on BellPushAddresses
+func (a *BellPushAddresses) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return a.UnmarshalText([]byte(node.Value))
+	case yaml.SequenceNode:
+	default:
+		return fmt.Errorf("line %d: expected a list of bellpush addresses", node.Line)
+	}
+	addresses := BellPushAddresses{}
+	for _, item := range node.Content {
+		if item.Kind == yaml.SequenceNode {
+			var failoverAddresses []string
+			if err := item.Decode(&failoverAddresses); err != nil {
+				return err
+			}
+			addresses = append(addresses, strings.Join(failoverAddresses, failoverSeparator))
+			continue
+		}
+		var address string
+		if err := item.Decode(&address); err != nil {
+			return err
+		}
+		addresses = append(addresses, address)
+	}
+	*a = addresses
+	return nil
+}
+
+// failoverSeparator separates the addresses of a bellpush that are tried in order
+const failoverSeparator = "|"
+
+// failoverAddresses returns the addresses for a bellpush in the order to try them
+func failoverAddresses(bellPush string) []string {
+	addresses := []string{}
+	for _, address := range strings.Split(bellPush, failoverSeparator) {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// reloadableSettings are the settings that are applied when the config is reloaded on SIGHUP
+var reloadableSettings = []string{"log", "ring", "audio", "desktop"}
+
+func defaultConfig() Config {
+	return Config{
+		Transport: transportWebsocket,
+		MQTT: MQTTConfig{
+			Config: mqttutils.Config{
+				TopicPrefix: mqttutils.DefaultTopicPrefix,
+			},
+			BellPush: "+",
+		},
+		Ring:         DefaultRingConfig(),
+		Audio:        DefaultAudioConfig(),
+		Desktop:      DefaultDesktopConfig(),
+		SnoozeButton: DefaultSnoozeButtonConfig(),
+		StatusLed:    DefaultStatusLedConfig(),
+	}
+}
+
+// loadConfig loads the config file at path (if not empty) on top of the defaults and applies
+// environment variable and flag overrides before validating the result
+func loadConfig(path string, addrFlag string) (Config, error) {
+	config := defaultConfig()
+	if path != "" {
+		if err := configfile.Load(path, &config); err != nil {
+			return config, err
+		}
+	}
+	if err := configfile.ApplyEnv(&config); err != nil {
+		return config, err
+	}
+	if addrFlag != "" {
+		if err := config.BellPushes.UnmarshalText([]byte(addrFlag)); err != nil {
+			return config, err
+		}
+	}
+
+	config.Transport = strings.ToLower(config.Transport)
+	if config.Name == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return config, fmt.Errorf("failed to get hostname: %w", err)
+		}
+		config.Name = hostname
+	}
+
+	return config, config.validate()
+}
+
+func (c Config) validate() error {
+	errs := &configfile.Errors{}
+	switch c.Transport {
+	case transportWebsocket:
+		seen := map[string]bool{}
+		for _, bellPush := range c.BellPushes {
+			addresses := failoverAddresses(bellPush)
+			if len(addresses) == 0 {
+				errs.Add("bellpush", "invalid bellpush %q: expected host:port, e.g. bellpush:8080", bellPush)
+			}
+			for _, address := range addresses {
+				if _, _, err := net.SplitHostPort(address); err != nil {
+					errs.Add("bellpush", "invalid address %q: expected host:port, e.g. bellpush:8080", address)
+				}
+				if seen[address] {
+					errs.Add("bellpush", "duplicate address %q", address)
+				}
+				seen[address] = true
+			}
+		}
+	case transportMQTT:
+		if !c.MQTT.Enabled() {
+			errs.Add("mqtt.broker", "required when transport is mqtt")
+		}
+		if c.MQTT.BellPush == "" || strings.Contains(c.MQTT.BellPush, "/") {
+			errs.Add("mqtt.bellpush", "must be a bellpush node ID or + for all")
+		}
+	default:
+		errs.Add("transport", "invalid transport %q (expected websocket or mqtt)", c.Transport)
+	}
+	if c.MetricsAddress != "" {
+		if _, _, err := net.SplitHostPort(c.MetricsAddress); err != nil {
+			errs.Add("metricsAddress", "invalid address %q: expected host:port, e.g. :9100", c.MetricsAddress)
+		}
+	}
+	c.Log.Validate(errs, "log")
+	c.Telemetry.Validate(errs, "telemetry")
+	c.MQTT.Validate(errs, "mqtt")
+	c.Ring.Validate(errs, "ring")
+	c.Audio.Validate(errs, "audio")
+	c.Desktop.Validate(errs, "desktop")
+	c.SnoozeButton.Validate(errs, "snoozeButton")
+	c.StatusLed.Validate(errs, "statusLed")
+	return errs.Err()
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stuartleeks/pi-bell/internal/pkg/configfile"
+	"github.com/stuartleeks/pi-bell/internal/pkg/desktop"
+	"github.com/stuartleeks/pi-bell/internal/pkg/events"
+	"github.com/stuartleeks/pi-bell/internal/pkg/logging"
+)
+
+const (
+	// desktopAppName is the application name shown on desktop notifications
+	desktopAppName = "Pi-Bell"
+	// snoozeActionPrefix prefixes the snooze duration in the keys of the snooze actions
+	snoozeActionPrefix = "snooze:"
+	// bellPushRequestTimeout is the timeout for requests to the bellpush for the thumbnail and snoozing
+	bellPushRequestTimeout = 5 * time.Second
+)
+
+// Durations is a list of durations. In environment variables this is a comma-separated list, e.g. 15m,1h
+type Durations []time.Duration
+
+// UnmarshalText parses a comma-separated list of durations
+func (d *Durations) UnmarshalText(text []byte) error {
+	durations := Durations{}
+	for _, value := range strings.Split(string(text), ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid durations %q: %w", string(text), err)
+		}
+		durations = append(durations, duration)
+	}
+	*d = durations
+	return nil
+}
+
+// DesktopConfig holds the settings for showing desktop notifications when the bell rings, e.g. when running the
+// chime on a laptop
+type DesktopConfig struct {
+	// Notifications shows a notification when the bell rings. The freedesktop notifications service is used if
+	// there is one, otherwise the notification is written to the terminal with a bell
+	Notifications bool `yaml:"notifications" env:"DESKTOP_NOTIFICATIONS"`
+	// Thumbnail shows the latest webcam image from the bellpush on the notification
+	Thumbnail bool `yaml:"thumbnail" env:"DESKTOP_THUMBNAIL"`
+	// SnoozeDurations are the snooze actions on the notification
+	SnoozeDurations Durations `yaml:"snoozeDurations" env:"DESKTOP_SNOOZE_DURATIONS"`
+}
+
+// DefaultDesktopConfig returns the default desktop settings (with notifications disabled)
+func DefaultDesktopConfig() DesktopConfig {
+	return DesktopConfig{
+		Thumbnail:       true,
+		SnoozeDurations: Durations{15 * time.Minute, 1 * time.Hour},
+	}
+}
+
+// Validate checks the settings, adding any problems to errs
+func (c DesktopConfig) Validate(errs *configfile.Errors, path string) {
+	for _, duration := range c.SnoozeDurations {
+		if duration <= 0 {
+			errs.Add(configfile.Join(path, "snoozeDurations"), "must be greater than 0")
+			return
+		}
+	}
+}
+
+// desktopNotifier shows a desktop notification for each ring, with a thumbnail from the bellpush's webcam and
+// actions that ask the bellpush to snooze the chime
+type desktopNotifier struct {
+	chimeName string
+	client    *http.Client
+
+	mutex    sync.Mutex
+	config   DesktopConfig
+	notifier desktop.Notifier
+	// imageDir holds the thumbnails, which are read by the notifications service after Notify returns
+	imageDir string
+	// thumbnail is the path of the latest thumbnail, which is removed when the next ring saves a new one
+	thumbnail string
+}
+
+func newDesktopNotifier(chimeName string) *desktopNotifier {
+	return &desktopNotifier{
+		chimeName: chimeName,
+		client:    &http.Client{Timeout: bellPushRequestTimeout},
+	}
+}
+
+// configure applies config, connecting to the notifications service when notifications are enabled
+func (n *desktopNotifier) configure(config DesktopConfig) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.config = config
+	if !config.Notifications {
+		n.closeLocked()
+		return
+	}
+	if n.notifier != nil {
+		return
+	}
+	dbusNotifier, err := desktop.NewDBusNotifier(desktopAppName)
+	if err != nil {
+		logger.Warn("Desktop notifications unavailable - using the terminal", "err", err)
+		n.notifier = desktop.NewTerminalNotifier(os.Stdout)
+	} else {
+		logger.Info("Desktop notifications enabled", "thumbnail", config.Thumbnail, "snoozeDurations", len(config.SnoozeDurations))
+		n.notifier = dbusNotifier
+	}
+	if n.imageDir, err = os.MkdirTemp("", "pibell-chime-"); err != nil {
+		logger.Warn("Failed to create thumbnail directory - not showing thumbnails", "err", err)
+		n.imageDir = ""
+	}
+}
+
+// enabled returns true if desktop notifications are enabled
+func (n *desktopNotifier) enabled() bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.notifier != nil
+}
+
+// notify shows the notification for a ring in the background. bellPushURL is the bellpush's HTTP
+// address, which is used for the thumbnail and snooze actions (nil if unknown, e.g. with the MQTT transport)
+func (n *desktopNotifier) notify(eventLogger *logging.Logger, bellPushURL *url.URL, buttonEvent *events.ButtonEvent) {
+	n.mutex.Lock()
+	notifier := n.notifier
+	config := n.config
+	imageDir := n.imageDir
+	n.mutex.Unlock()
+	if notifier == nil {
+		return
+	}
+
+	go func() {
+		rungAt := buttonEvent.Time
+		if rungAt.IsZero() {
+			rungAt = time.Now()
+		}
+		notification := desktop.Notification{
+			Summary:  "Doorbell",
+			Body:     fmt.Sprintf("Ring at %s", rungAt.Local().Format("15:04")),
+			Critical: true,
+		}
+		if buttonEvent.Door != "" {
+			notification.Summary = buttonEvent.Door
+		}
+		if bellPushURL != nil {
+			if config.Thumbnail && imageDir != "" {
+				imagePath, err := n.saveThumbnail(*bellPushURL, imageDir)
+				if err != nil {
+					eventLogger.Warn("Failed to get thumbnail", "err", err)
+				}
+				notification.ImagePath = imagePath
+			}
+			for _, duration := range config.SnoozeDurations {
+				notification.Actions = append(notification.Actions, desktop.Action{
+					Key:   snoozeActionPrefix + duration.String(),
+					Label: "Snooze " + formatSnoozeDuration(duration),
+				})
+			}
+			notification.OnAction = func(key string) {
+				n.handleAction(eventLogger, *bellPushURL, key)
+			}
+		}
+		if err := notifier.Notify(notification); err != nil {
+			eventLogger.Error("Failed to show desktop notification - using the terminal", "err", err)
+			desktopErrorsCounter.Inc()
+			_ = desktop.NewTerminalNotifier(os.Stdout).Notify(notification)
+			return
+		}
+		desktopNotificationsCounter.Inc()
+	}()
+}
+
+// notifyMissedRings shows a notification for the rings missed while the chime was disconnected in the background
+func (n *desktopNotifier) notifyMissedRings(eventLogger *logging.Logger, missedRingsEvent *events.MissedRingsEvent) {
+	n.mutex.Lock()
+	notifier := n.notifier
+	n.mutex.Unlock()
+	if notifier == nil {
+		return
+	}
+
+	go func() {
+		summary := "Missed a ring"
+		if missedRingsEvent.Count > 1 {
+			summary = fmt.Sprintf("Missed %d rings", missedRingsEvent.Count)
+		}
+		times := []string{}
+		for _, ring := range missedRingsEvent.Rings {
+			ringTime := ring.Time.Local().Format("15:04")
+			if ring.Door != "" {
+				ringTime = fmt.Sprintf("%s (%s)", ringTime, ring.Door)
+			}
+			times = append(times, ringTime)
+		}
+		notification := desktop.Notification{
+			Summary: summary,
+			Body:    fmt.Sprintf("While the chime was disconnected: %s", strings.Join(times, ", ")),
+		}
+		if err := notifier.Notify(notification); err != nil {
+			eventLogger.Error("Failed to show desktop notification - using the terminal", "err", err)
+			desktopErrorsCounter.Inc()
+			_ = desktop.NewTerminalNotifier(os.Stdout).Notify(notification)
+			return
+		}
+		desktopNotificationsCounter.Inc()
+	}()
+}
+
+// saveThumbnail saves the latest webcam image from the bellpush in imageDir, returning the path (or "" if the
+// bellpush has no image, e.g. with the webcam disabled). The previous thumbnail is removed, so that only the
+// latest is kept until the chime exits
+func (n *desktopNotifier) saveThumbnail(bellPushURL url.URL, imageDir string) (string, error) {
+	bellPushURL.Path = "/camera/latest"
+	response, err := n.client.Get(bellPushURL.String())
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", response.Status)
+	}
+	image, err := io.ReadAll(response.Body)
+	if err != nil || len(image) == 0 {
+		return "", err
+	}
+	file, err := os.CreateTemp(imageDir, "ring-*.jpg")
+	if err != nil {
+		return "", err
+	}
+	_, err = file.Write(image)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return "", err
+	}
+	n.replaceThumbnail(file.Name())
+	return file.Name(), nil
+}
+
+// replaceThumbnail records path as the latest thumbnail and removes the previous one
+func (n *desktopNotifier) replaceThumbnail(path string) {
+	n.mutex.Lock()
+	previous := n.thumbnail
+	n.thumbnail = path
+	n.mutex.Unlock()
+	if previous == "" {
+		return
+	}
+	if err := os.Remove(previous); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logger.Warn("Error removing thumbnail", "path", previous, "err", err)
+	}
+}
+
+// handleAction handles an action invoked on a ring notification. Snoozing is requested from
+// the bellpush so that it remains the source of truth for the snooze state, which it then sends to the chime
+func (n *desktopNotifier) handleAction(eventLogger *logging.Logger, bellPushURL url.URL, key string) {
+	if !strings.HasPrefix(key, snoozeActionPrefix) {
+		eventLogger.Warn("Unknown notification action", "action", key)
+		return
+	}
+	duration := strings.TrimPrefix(key, snoozeActionPrefix)
+	eventLogger.Info("Snoozing from desktop notification", "duration", duration)
+	bellPushURL.Path = "/chime/snooze"
+	bellPushURL.RawQuery = url.Values{"name": {n.chimeName}, "duration": {duration}}.Encode()
+	response, err := n.client.Post(bellPushURL.String(), "", nil)
+	if err != nil {
+		eventLogger.Error("Failed to snooze", "err", err)
+		return
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		eventLogger.Error("Failed to snooze", "status", response.Status, "message", strings.TrimSpace(string(message)))
+	}
+}
+
+// formatSnoozeDuration formats duration for an action label, e.g. 15m or 1h rather than 15m0s or 1h0m0s
+func formatSnoozeDuration(duration time.Duration) string {
+	text := duration.String()
+	if strings.HasSuffix(text, "m0s") {
+		text = strings.TrimSuffix(text, "0s")
+	}
+	if strings.HasSuffix(text, "h0m") {
+		text = strings.TrimSuffix(text, "0m")
+	}
+	return text
+}
+
+// close closes the connection to the notifications service and removes the thumbnails
+func (n *desktopNotifier) close() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.closeLocked()
+}
+
+func (n *desktopNotifier) closeLocked() {
+	if n.notifier != nil {
+		if err := n.notifier.Close(); err != nil {
+			logger.Warn("Error closing desktop notifications", "err", err)
+		}
+		n.notifier = nil
+	}
+	if n.imageDir != "" {
+		if err := os.RemoveAll(n.imageDir); err != nil {
+			logger.Warn("Error removing thumbnails", "err", err)
+		}
+		n.imageDir = ""
+		n.thumbnail = ""
+	}
+}
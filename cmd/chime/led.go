@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/stuartleeks/pi-bell/internal/pkg/configfile"
+	"github.com/stuartleeks/pi-bell/internal/pkg/pi"
+	"github.com/stuartleeks/pi-bell/internal/pkg/sdnotify"
+	"gobot.io/x/gobot/drivers/gpio"
+)
+
+const (
+	// statusLedPinNumber is the pin for the single-colour status LED
+	statusLedPinNumber = pi.GPIO17
+	// the pins for the red, green and blue channels of an RGB status LED
+	statusLedRedPinNumber   = pi.GPIO22
+	statusLedGreenPinNumber = pi.GPIO23
+	statusLedBluePinNumber  = pi.GPIO24
+
+	// ledRecheckInterval is how often the LED state is re-evaluated for changes that aren't signalled,
+	// e.g. a snooze expiring or a connection loop missing its heartbeats
+	ledRecheckInterval = 1 * time.Second
+)
+
+// StatusLedConfig holds the settings for the status LED
+type StatusLedConfig struct {
+	// RGB drives an RGB LED on GPIO 22 (red), 23 (green) and 24 (blue) using PWM (via pi-blaster) rather than the
+	// single-colour LED on GPIO 17
+	RGB bool `yaml:"rgb" env:"STATUS_LED_RGB"`
+	// Brightness is the brightness of the RGB LED as a percentage
+	Brightness int `yaml:"brightness" env:"STATUS_LED_BRIGHTNESS"`
+}
+
+// DefaultStatusLedConfig returns the default status LED settings
+func DefaultStatusLedConfig() StatusLedConfig {
+	return StatusLedConfig{
+		Brightness: 100,
+	}
+}
+
+// Validate checks the settings, adding any problems to errs
+func (c StatusLedConfig) Validate(errs *configfile.Errors, path string) {
+	if c.Brightness < 1 || c.Brightness > 100 {
+		errs.Add(configfile.Join(path, "brightness"), "must be between 1 and 100")
+	}
+}
+
+// ledColor is a colour for the status LED. A single-colour LED is on for any colour other than ledOff
+type ledColor struct {
+	red, green, blue byte
+}
+
+var (
+	ledOff     = ledColor{}
+	ledRed     = ledColor{red: 255}
+	ledGreen   = ledColor{green: 255}
+	ledBlue    = ledColor{blue: 255}
+	ledYellow  = ledColor{red: 255, green: 160}
+	ledOrange  = ledColor{red: 255, green: 64}
+	ledMagenta = ledColor{red: 255, blue: 255}
+	ledCyan    = ledColor{green: 255, blue: 255}
+)
+
+// ledStep shows a colour for a duration
+type ledStep struct {
+	color    ledColor
+	duration time.Duration
+}
+
+// flashes returns the steps for count flashes of color, each on for on and followed by off, with the last
+// followed by pause instead
+func flashes(color ledColor, count int, on time.Duration, off time.Duration, pause time.Duration) []ledStep {
+	steps := []ledStep{}
+	for i := 0; i < count; i++ {
+		gap := off
+		if i == count-1 {
+			gap = pause
+		}
+		steps = append(steps, ledStep{color, on}, ledStep{ledOff, gap})
+	}
+	return steps
+}
+
+// ledState is what the status LED shows
+type ledState int
+
+const (
+	ledConnecting ledState = iota
+	ledConnected
+	ledDegraded
+	ledSnoozed
+	ledMissedRings
+	ledAuthFailure
+	ledConfigError
+)
+
+var ledStateNames = map[ledState]string{
+	ledConnecting:  "connecting",
+	ledConnected:   "connected",
+	ledDegraded:    "degraded",
+	ledSnoozed:     "snoozed",
+	ledMissedRings: "missed-rings",
+	ledAuthFailure: "auth-failure",
+	ledConfigError: "config-error",
+}
+
+func (s ledState) String() string {
+	return ledStateNames[s]
+}
+
+// ledPatterns are the repeating patterns for each state. The timing differs between states as well as the
+// colour so that they can be told apart on a single-colour LED
+var ledPatterns = map[ledState][]ledStep{
+	// a flash every second
+	ledConnecting: flashes(ledYellow, 1, 100*time.Millisecond, 0, 900*time.Millisecond),
+	// a flash every 10 seconds
+	ledConnected: flashes(ledGreen, 1, 100*time.Millisecond, 0, 9900*time.Millisecond),
+	// a flash every 3 seconds
+	ledDegraded: flashes(ledOrange, 1, 100*time.Millisecond, 0, 2900*time.Millisecond),
+	// a double flash every 10 seconds
+	ledSnoozed: flashes(ledBlue, 2, 100*time.Millisecond, 150*time.Millisecond, 9650*time.Millisecond),
+	// a long flash every 2 seconds
+	ledMissedRings: flashes(ledCyan, 1, 500*time.Millisecond, 0, 1500*time.Millisecond),
+	// three quick flashes every 2 seconds
+	ledAuthFailure: flashes(ledRed, 3, 100*time.Millisecond, 100*time.Millisecond, 1500*time.Millisecond),
+	// mostly on
+	ledConfigError: flashes(ledMagenta, 1, 1500*time.Millisecond, 0, 500*time.Millisecond),
+}
+
+// ledRequestFailedSteps are shown once (interrupting the pattern) when a request fails, e.g. a snooze request
+// that couldn't be sent
+var ledRequestFailedSteps = flashes(ledRed, 5, 50*time.Millisecond, 50*time.Millisecond, 500*time.Millisecond)
+
+// statusLed is the status LED hardware
+type statusLed interface {
+	set(color ledColor) error
+}
+
+// singleStatusLed is a single-colour LED
+type singleStatusLed struct {
+	led *gpio.LedDriver
+}
+
+func (l *singleStatusLed) set(color ledColor) error {
+	if color == ledOff {
+		return l.led.Off()
+	}
+	return l.led.On()
+}
+
+// rgbStatusLed is an RGB LED, with the brightness as a percentage
+type rgbStatusLed struct {
+	led        *gpio.RgbLedDriver
+	brightness int
+}
+
+func (l *rgbStatusLed) set(color ledColor) error {
+	scale := func(level byte) byte {
+		return byte(int(level) * l.brightness / 100)
+	}
+	return l.led.SetRGB(scale(color.red), scale(color.green), scale(color.blue))
+}
+
+// noStatusLed is used when GPIO is disabled or the LED failed to start
+type noStatusLed struct{}
+
+func (noStatusLed) set(ledColor) error {
+	return nil
+}
+
+// startStatusLed starts the status LED driver on the Raspberry Pi
+func startStatusLed(writer gpio.DigitalWriter, config StatusLedConfig) (statusLed, error) {
+	if config.RGB {
+		if _, ok := writer.(gpio.PwmWriter); !ok {
+			return nil, fmt.Errorf("the GPIO adaptor doesn't support PWM for an RGB LED")
+		}
+		led := gpio.NewRgbLedDriver(writer, statusLedRedPinNumber, statusLedGreenPinNumber, statusLedBluePinNumber)
+		if err := led.Start(); err != nil {
+			return nil, fmt.Errorf("failed to start RGB LED: %w", err)
+		}
+		return &rgbStatusLed{led: led, brightness: config.Brightness}, nil
+	}
+	led := gpio.NewLedDriver(writer, statusLedPinNumber)
+	if err := led.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start LED: %w", err)
+	}
+	return &singleStatusLed{led: led}, nil
+}
+
+// setConfigError records whether the config (or part of it, e.g. the audio settings) failed to load or apply
+func (c *chime) setConfigError(configError bool) {
+	c.mutex.Lock()
+	c.configError = configError
+	c.mutex.Unlock()
+	c.notifyHealthChanged()
+}
+
+// setAuthFailed records whether the last connection attempt for name was rejected by the bellpush (or broker)
+// because of authentication
+func (c *chime) setAuthFailed(name string, authFailed bool) {
+	c.mutex.Lock()
+	changed := c.authFailed[name] != authFailed
+	c.authFailed[name] = authFailed
+	c.mutex.Unlock()
+	if changed {
+		c.notifyHealthChanged()
+	}
+}
+
+// ledState returns what the status LED should show. When several states apply, the one that most needs
+// attention is shown
+func (c *chime) ledState() ledState {
+	connected, total := c.health()
+	c.mutex.Lock()
+	configError := c.configError
+	authFailed := false
+	for _, failed := range c.authFailed {
+		authFailed = authFailed || failed
+	}
+	c.mutex.Unlock()
+	snoozed, _ := c.isSnoozed()
+	switch {
+	case configError:
+		return ledConfigError
+	case authFailed:
+		return ledAuthFailure
+	case total == 0 || connected == 0:
+		return ledConnecting
+	case connected < total || len(c.stalledConnections()) > 0:
+		return ledDegraded
+	case c.hasMissedRings():
+		return ledMissedRings
+	case snoozed:
+		return ledSnoozed
+	default:
+		return ledConnected
+	}
+}
+
+// updateServiceStatus updates the systemd status with the connection health
+func (c *chime) updateServiceStatus() {
+	connected, total := c.health()
+	switch {
+	case total == 0:
+		sdnotify.Status("Discovering bellpushes")
+	case connected > 0:
+		sdnotify.Status("Connected to %d/%d bellpushes", connected, total)
+	default:
+		sdnotify.Status("Connecting to %d bellpushes", total)
+	}
+	if connected > 0 {
+		// systemd ignores repeated notifications
+		sdnotify.Ready()
+	}
+}
+
+// runStatusLed shows the chime's state on the status LED until ctx is done, using the pattern for the state
+// from ledPatterns. LED errors are logged (once until the LED recovers) rather than stopping the LED.
+// The systemd status is updated when the connection health changes
+func (c *chime) runStatusLed(ctx context.Context, led statusLed) {
+	defer c.ringer.offOnPanic()
+	defer func() {
+		if err := led.set(ledOff); err != nil {
+			logger.Warn("Failed to turn status LED off", "err", err)
+		}
+	}()
+
+	state := c.ledState()
+	steps := ledPatterns[state]
+	step := 0
+	// overlay are the steps shown once before returning to the pattern (nil if none)
+	var overlay []ledStep
+	failing := false
+	logger.Debug("Status LED", "state", state)
+	c.updateServiceStatus()
+
+	stepTimer := time.NewTimer(0)
+	defer stepTimer.Stop()
+	recheck := time.NewTicker(ledRecheckInterval)
+	defer recheck.Stop()
+	// restart shows the first step of the current steps immediately
+	restart := func() {
+		step = 0
+		if !stepTimer.Stop() {
+			select {
+			case <-stepTimer.C:
+			default:
+			}
+		}
+		stepTimer.Reset(0)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.healthChanged:
+			c.updateServiceStatus()
+		case <-recheck.C:
+		case <-c.errorFlash:
+			overlay = ledRequestFailedSteps
+			restart()
+			continue
+		case <-stepTimer.C:
+			current := steps
+			if overlay != nil {
+				current = overlay
+			}
+			if step == len(current) {
+				step = 0
+				if overlay != nil {
+					overlay = nil
+					current = steps
+				}
+			}
+			err := led.set(current[step].color)
+			switch {
+			case err != nil && !failing:
+				logger.Error("Failed to set status LED - will keep retrying", "err", err)
+				failing = true
+			case err == nil && failing:
+				logger.Info("Status LED recovered")
+				failing = false
+			}
+			if err != nil {
+				statusLedErrorsCounter.Inc()
+			}
+			stepTimer.Reset(current[step].duration)
+			step++
+			continue
+		}
+
+		if newState := c.ledState(); newState != state {
+			logger.Debug("Status LED", "state", newState, "previousState", state)
+			state = newState
+			steps = ledPatterns[state]
+			if overlay == nil {
+				restart()
+			}
+		}
+	}
+}
@@ -1,354 +1,422 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
-	"log"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
-	"github.com/microsoft/ApplicationInsights-Go/appinsights"
-	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"github.com/stuartleeks/pi-bell/internal/pkg/configfile"
+	"github.com/stuartleeks/pi-bell/internal/pkg/discovery"
 	"github.com/stuartleeks/pi-bell/internal/pkg/events"
+	"github.com/stuartleeks/pi-bell/internal/pkg/logging"
 	"github.com/stuartleeks/pi-bell/internal/pkg/pi"
-	"github.com/stuartleeks/pi-bell/internal/pkg/timeutils"
+	"github.com/stuartleeks/pi-bell/internal/pkg/sdnotify"
+	"github.com/stuartleeks/pi-bell/internal/pkg/telemetry"
+	"github.com/stuartleeks/pi-bell/internal/pkg/version"
 	"gobot.io/x/gobot/drivers/gpio"
 	"gobot.io/x/gobot/platforms/raspi"
 )
 
-var addr = flag.String("addr", "localhost:8080", "http service address")
+var addr = flag.String("addr", "", "bellpush address(es), e.g. bellpush:8080 or front:8080,garage:8080 (overrides the config file)")
+var configPath = flag.String("config", os.Getenv("CHIME_CONFIG"), "path to the YAML config file (optional)")
+var checkConfig = flag.Bool("check-config", false, "validate the config and exit")
 
-var telemetryClient appinsights.TelemetryClient
+var telemetryClient telemetry.Client
 var disableGpio bool
-var initTime time.Time = timeutils.MustTimeParse(time.RFC3339, "1900-01-01T00:00:00Z")
-var snoozeExpiry = initTime
-
-func _log(level contracts.SeverityLevel, format string, a ...any) {
-	s := fmt.Sprintf(format, a...)
-	trace := appinsights.NewTraceTelemetry(s, level)
-	telemetryClient.Track(trace)
-	telemetryClient.Channel().Flush()
-	log.Println(s)
-}
 
-func logInformation(format string, a ...any) {
-	_log(appinsights.Information, format, a...)
-}
+var logger = logging.New("component", "chime")
 
-//	func logWarning(format string, a ...any) {
-//		_log(appinsights.Warning, format, a...)
-//	}
-func logError(format string, a ...any) {
-	_log(appinsights.Error, format, a...)
+var telemetrySeverities = map[logging.Level]telemetry.Severity{
+	logging.LevelDebug: telemetry.Verbose,
+	logging.LevelInfo:  telemetry.Information,
+	logging.LevelWarn:  telemetry.Warning,
+	logging.LevelError: telemetry.Error,
 }
 
-// CancellableOperation represents an ongoing cancellable operation
-type CancellableOperation interface {
-	IsRunning() bool
-	Cancel() bool
-}
-
-type safeCancellableOperation struct {
-	running     bool
-	innerCancel func()
-}
-
-var _ CancellableOperation = &safeCancellableOperation{}
-
-func (o *safeCancellableOperation) IsRunning() bool {
-	return o.running
-}
-func (o *safeCancellableOperation) Cancel() bool {
-	if o.IsRunning() {
-		o.running = false
-		o.innerCancel()
-		return true
+// traceToTelemetry forwards log entries to telemetry (with their fields as properties)
+func traceToTelemetry(entry logging.Entry) {
+	properties := entry.FieldMap()
+	if properties["component"] == "telemetry" {
+		// don't feed telemetry export failures back into telemetry
+		return
 	}
-	return false
+	telemetryClient.TrackTrace(entry.Message, telemetrySeverities[entry.Level], properties)
 }
 
-// NewSafeCancellableOperation returns a CancellableOperation that prevents Cancel being called multiple times
-func NewSafeCancellableOperation(cancel func()) CancellableOperation {
-	return &safeCancellableOperation{
-		running:     true,
-		innerCancel: cancel,
-	}
+// errAuthFailed is returned when the bellpush (or MQTT broker) rejects the connection because of authentication
+var errAuthFailed = errors.New("authentication failed")
+
+// bellPushTarget is a bellpush that the chime connects to: either configured addresses or a service discovered via mDNS
+type bellPushTarget struct {
+	// name identifies the bellpush in logs, metrics and the snooze state
+	name string
+	// addresses are tried in order (e.g. the primary and standby of a failover pair)
+	addresses []string
+	// instance is the mDNS service name, which is resolved on each connection attempt so that a new address is picked up
+	instance string
 }
 
-func blinkStatusLed(statusLed *gpio.LedDriver, durationBetweenFlashes time.Duration) (CancellableOperation, error) {
-	if statusLed == nil {
-		logInformation("LED blink started")
-		cancelLedBlink := func() {
-			logInformation("LED blink canceled")
+// urls returns the websocket URLs for the bellpush in the order to try them
+func (t bellPushTarget) urls(ctx context.Context) ([]url.URL, error) {
+	if t.instance == "" {
+		urls := make([]url.URL, 0, len(t.addresses))
+		for _, address := range t.addresses {
+			urls = append(urls, url.URL{Scheme: "ws", Host: address, Path: "/doorbell"})
 		}
-		cancellableOperation := NewSafeCancellableOperation(cancelLedBlink)
-		return cancellableOperation, nil
+		return urls, nil
 	}
 
-	logInformation("LED blink started")
-	err := statusLed.Off()
+	resolveCtx, cancel := context.WithTimeout(ctx, discovery.ResolveTimeout)
+	defer cancel()
+	service, err := discovery.Resolve(resolveCtx, t.instance)
 	if err != nil {
-		err = fmt.Errorf("failed to turn led off: %v", err)
-		return nil, err
+		return nil, fmt.Errorf("failed to resolve bellpush: %w", err)
 	}
-	ledStatusCancelChan := make(chan bool, 1)
-	go func() {
-		for {
-			err = statusLed.On() // TODO - report errors from here so that the main loop can be restarted
-			if err != nil {
-				panic(err) // TODO - don't panic!
-			}
-			time.Sleep(100 * time.Millisecond)
-			err = statusLed.Off()
-			if err != nil {
-				panic(err) // TODO - don't panic!
-			}
-
-			waitEnd := time.Now().Add(durationBetweenFlashes)
-			for waiting := true; waiting; {
-				select {
-				case <-ledStatusCancelChan:
-					return
-				default:
-					if time.Now().After(waitEnd) {
-						waiting = false
-						continue
-					}
+	logger.Debug("Resolved bellpush", "bellpush", t.name, "address", service.Address)
+	scheme := "ws"
+	if service.Info.TLS {
+		scheme = "wss"
+	}
+	return []url.URL{{Scheme: scheme, Host: service.Address, Path: "/doorbell"}}, nil
+}
 
-					time.Sleep(500 * time.Millisecond)
-				}
+// dial connects to the first of the bellpush URLs that accepts the connection.
+// A standby bellpush rejects connections, so this fails over to the leader of a failover pair
+func (t bellPushTarget) dial(ctx context.Context) (*websocket.Conn, url.URL, error) {
+	urls, err := t.urls(ctx)
+	if err != nil {
+		return nil, url.URL{}, err
+	}
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 10 * time.Second,
+	}
+	var errs []string
+	var lastErr error
+	for _, u := range urls {
+		logger.Info("Connecting", "bellpush", t.name, "url", u.String())
+		conn, response, dialErr := dialer.DialContext(ctx, u.String(), nil)
+		if dialErr == nil {
+			return conn, u, nil
+		}
+		if response != nil {
+			switch response.StatusCode {
+			case http.StatusServiceUnavailable:
+				dialErr = fmt.Errorf("bellpush is the standby")
+			case http.StatusUnauthorized, http.StatusForbidden:
+				// e.g. from a reverse proxy in front of the bellpush
+				dialErr = fmt.Errorf("%w: %s", errAuthFailed, response.Status)
 			}
 		}
-	}()
-	cancelLedBlink := func() {
-		logInformation("LED blink canceled")
-		ledStatusCancelChan <- true
+		if len(urls) > 1 {
+			logger.Warn("Failed to connect - trying next address", "bellpush", t.name, "url", u.String(), "err", dialErr)
+		}
+		lastErr = fmt.Errorf("dial to %s failed: %w", u.String(), dialErr)
+		errs = append(errs, lastErr.Error())
+	}
+	if len(errs) == 1 {
+		return nil, url.URL{}, lastErr
 	}
-	cancellableOperation := NewSafeCancellableOperation(cancelLedBlink)
-	return cancellableOperation, nil
+	return nil, url.URL{}, errors.New(strings.Join(errs, "; "))
 }
-func connectAndHandleEvents(interruptChan <-chan os.Signal, address *string, statusLed *gpio.LedDriver, relay *gpio.RelayDriver, connectingStatusBlink CancellableOperation) error {
 
-	defer connectingStatusBlink.Cancel() // ensure we cancel the connecting status blink on error etc
-	chimeName := os.Getenv("CHIME_NAME")
-	if chimeName == "" {
-		hostname, err := os.Hostname()
-		if err != nil {
-			logError("failed to get hostname: %v", err)
-			return fmt.Errorf("failed to get hostname: %v", err)
+// runConnection calls connect (which should return when the connection fails or ctx is done) until ctx is done,
+// waiting between attempts. name identifies the connection in the chime's connection health
+func runConnection(ctx context.Context, c *chime, name string, connect func(context.Context) error) {
+	defer c.ringer.offOnPanic()
+	c.addConnection(name)
+	connLogger := logger.With("bellpush", name)
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			reconnectAttemptsCounter.WithLabelValues(name).Inc()
+		}
+		c.heartbeat(name)
+		err := connect(ctx)
+		if relayErr := c.setDisconnected(name); relayErr != nil {
+			connLogger.Error("Error turning relay off", "err", relayErr)
+		}
+		c.setAuthFailed(name, errors.Is(err, errAuthFailed))
+		if ctx.Err() != nil {
+			connLogger.Info("Connection closed")
+			return
 		}
-		chimeName = hostname
-	}
-
-	u := url.URL{Scheme: "ws", Host: *address, Path: "/doorbell"}
-	logInformation("connecting to %s", u.String())
 
-	dialer := &websocket.Dialer{
-		Proxy:            http.ProxyFromEnvironment,
-		HandshakeTimeout: 10 * time.Second,
+		// wait before reconnecting, unless the bellpush closed the connection because it is restarting
+		retryDelay := 5 * time.Second
+		if websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			connLogger.Info("Bellpush going away - reconnecting", "attempt", attempt, "err", err)
+			retryDelay = 1 * time.Second
+		} else if errors.Is(err, errIncompatibleProtocol) {
+			// retrying won't help until the chime or bellpush is updated, so don't fill the logs
+			connLogger.Error("Failed to connect - the chime and bellpush need updating to compatible versions", "attempt", attempt, "err", err)
+			retryDelay = incompatibleRetryDelay
+		} else {
+			connLogger.Error("Failed to connect", "attempt", attempt, "errType", fmt.Sprintf("%T", err), "err", err)
+		}
+		c.heartbeat(name)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryDelay):
+		}
 	}
+}
+
+// connectAndHandleEvents connects to the bellpush and handles its events until the connection fails or ctx is done
+func connectAndHandleEvents(ctx context.Context, config Config, c *chime, target bellPushTarget) error {
+	chimeName := config.Name
 
-	conn, _, err := dialer.Dial(u.String(), nil)
+	conn, u, err := target.dial(ctx)
 	if err != nil {
-		err = fmt.Errorf("dial to %s failed: %v", u.String(), err)
 		return err
 	}
 	defer conn.Close()
+	connLogger := logger.With("bellpush", target.name, "url", u.String())
 
 	resultChan := make(chan error, 1)
-	logInformation("Listening...")
+	// latency reports are written from the main loop below as the websocket doesn't support concurrent writers
+	latencyReports := make(chan *events.LatencyReport, 10)
+	reportLatency := func(report *events.LatencyReport) {
+		select {
+		case latencyReports <- report:
+		default:
+			connLogger.WithCorrelationID(report.EventID).Warn("Latency report queue full - dropping report")
+		}
+	}
+	// snooze requests are also written from the main loop
+	snoozeRequests := make(chan *events.SnoozeRequest, 1)
+	// welcomes receives the welcome message from the bellpush (nil if the first message is something else, i.e. the
+	// bellpush uses protocol version 1)
+	welcomes := make(chan *events.Welcome, 1)
+	// agreed receives the welcome that the handshake below used (nil if it timed out), so that both loops use the
+	// same protocol
+	agreed := make(chan *events.Welcome, 1)
+	connLogger.Info("Listening")
 	go func() {
+		defer c.ringer.offOnPanic()
+		// negotiated is the agreed welcome message (nil for protocol version 1), which is only used by this loop
+		var negotiated *events.Welcome
+		first := true
 		for {
-			var messageType int
-			var buf []byte
-			messageType, buf, err = conn.ReadMessage()
-			if err != nil {
+			messageType, buf, readErr := conn.ReadMessage()
+			receivedAt := time.Now()
+			if readErr != nil {
 				// TODO - check for websocket.CloseError and return to trigger reconnecting?
 				//        (Currently panics for repeated read on failed connection in websocket code)
-				log.Printf("Error reading:  (%T) %v\n", err, err)
+				connLogger.Warn("Error reading", "errType", fmt.Sprintf("%T", readErr), "err", readErr)
 				var closeError *websocket.CloseError
 				var opErr *net.OpError
-				if errors.As(err, &closeError) ||
-					errors.As(err, &opErr) {
-					resultChan <- err
+				if errors.As(readErr, &closeError) ||
+					errors.As(readErr, &opErr) {
+					resultChan <- readErr
 					return
 				}
 				// TODO - are there any errors here that make sense to continue?
 				continue
 			}
-			logInformation("Received: %v: %s\n", messageType, string(buf))
-
-			var event *events.EventCommon
-			event, err = events.ParseEventJSON(buf)
-			if err != nil {
-				logError("Error parsing event: (%T) %v\n", err, err)
-				continue
+			connLogger.Debug("Received message", "websocketMessageType", messageType, "payload", string(buf))
+			if first {
+				first = false
+				var welcome *events.Welcome
+				if parsed, parseErr := events.ParseWelcomeJSON(buf); parseErr == nil {
+					welcome = parsed
+				}
+				welcomes <- welcome
+				select {
+				case negotiated = <-agreed:
+				case <-ctx.Done():
+					return
+				}
+				if welcome != nil && negotiated == nil {
+					resultChan <- errLateWelcome
+					return
+				}
+				if welcome != nil {
+					continue
+				}
 			}
 
-			shouldReturn := false
-			switch event.EventType {
-			case events.EventTypeButton:
-				logInformation("Handling button event")
-				shouldReturn = handleButtonEvent(buf, relay, resultChan)
-			case events.EventTypeSnooze:
-				shouldReturn = handleSnoozeEvent(buf)
-			case events.EventTypeUnSnooze:
-				shouldReturn = handleUnSnoozeEvent(buf)
-			default:
-				logError("Unhandled event type: %v\n", event.EventType)
+			var report func(*events.LatencyReport)
+			if negotiated != nil && negotiated.HasFeature(events.FeatureAck) {
+				report = reportLatency
 			}
-			if shouldReturn {
+			if handleErr := c.handleEventMessage(target.name, buf, receivedAt, report); handleErr != nil {
+				resultChan <- handleErr
 				return
 			}
 		}
 	}()
 
-	// Send hello message with hostname
-	logInformation("Sending hello message")
-	helloMessage := map[string]interface{}{
-		"messageType": "hello",
-		"senderName":  chimeName,
-	}
-	err = conn.WriteJSON(helloMessage)
-	if err != nil {
-		err = fmt.Errorf("failed to send hello message: %v", err)
-		return err
-	}
-
-	// connected to bellpush -> cancel the connecting blink and working blinking
-	connectingStatusBlink.Cancel()
-	runningStatusBlink, err := blinkStatusLed(statusLed, 10*time.Second)
-	if err != nil {
-		err = fmt.Errorf("failed to set status led blinking: %v", err)
-		return err
+	// Send the hello message and wait for the bellpush to reply with the protocol version and features to use
+	hello := events.NewHello(chimeName, version.Version, c.capabilities())
+	connLogger.Info("Sending hello message", "chime", chimeName, "protocolVersion", hello.ProtocolVersion, "capabilities", strings.Join(hello.Capabilities, ","))
+	if err = conn.WriteJSON(hello); err != nil {
+		return fmt.Errorf("failed to send hello message: %v", err)
 	}
-	defer runningStatusBlink.Cancel()
-
+	var welcome *events.Welcome
 	select {
-	case <-interruptChan:
-		logInformation("Returning from connectAndHandleEvents - no error")
+	case <-ctx.Done():
+		connLogger.Info("Returning from connectAndHandleEvents - no error")
 		return nil
 	case err := <-resultChan:
-		logError("Returning from connectAndHandleEvents - error (%T): %s\n", err, err)
-		return err
+		return handshakeError(err)
+	case welcome = <-welcomes:
+	case <-time.After(welcomeTimeout):
 	}
-}
-
-func handleSnoozeEvent(buf []byte) bool {
-	snoozeEvent, err := events.ParseSnoozeEventJSON(buf)
-	if err != nil {
-		logError("Error parsing: (%T) %v\n", err, err)
-		return false
+	agreed <- welcome
+	if welcome == nil {
+		connLogger.Warn("No welcome message from bellpush - using protocol version 1")
+	} else {
+		if err = checkWelcome(welcome); err != nil {
+			message := websocket.FormatCloseMessage(websocket.CloseProtocolError, err.Error())
+			if writeErr := conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second)); writeErr != nil {
+				connLogger.Warn("Error sending close message", "err", writeErr)
+			}
+			return err
+		}
+		connLogger.Info("Negotiated protocol", "protocolVersion", welcome.ProtocolVersion, "features", strings.Join(welcome.Features, ","),
+			"bellpushVersion", welcome.SoftwareVersion, "door", welcome.DoorName)
 	}
+	c.setSnoozeSender(target.name, func(request *events.SnoozeRequest) error {
+		if welcome == nil || !welcome.HasFeature(events.FeatureSnoozeRequest) {
+			return errors.New("the bellpush doesn't support snooze requests")
+		}
+		select {
+		case snoozeRequests <- request:
+			return nil
+		default:
+			return errors.New("a snooze request is already being sent")
+		}
+	})
 
-	eventTelemetry := appinsights.NewEventTelemetry("snooze-event")
-	eventTelemetry.Properties["id"] = fmt.Sprintf("%v", snoozeEvent.ID)
-	eventTelemetry.Properties["snoozeExpiry"] = snoozeEvent.SnoozeExpiry.Format(time.RFC3339)
-	telemetryClient.Track(eventTelemetry)
-	telemetryClient.Channel().Flush()
-
-	logInformation("Setting snooze until %s", snoozeEvent.SnoozeExpiry.Format(time.RFC3339))
-	snoozeExpiry = snoozeEvent.SnoozeExpiry
+	// connected to bellpush -> update the status LED
+	c.setBellPushURL(target.name, u)
+	c.setConnected(target.name)
 
-	return false
-}
-func handleUnSnoozeEvent(buf []byte) bool {
-	unsnoozeEvent, err := events.ParseUnSnoozeEventJSON(buf)
-	if err != nil {
-		logError("Error parsing: (%T) %v\n", err, err)
-		return false
+	heartbeatTicker := time.NewTicker(heartbeatInterval)
+	defer heartbeatTicker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			connLogger.Info("Returning from connectAndHandleEvents - no error")
+			return nil
+		case err := <-resultChan:
+			connLogger.Error("Returning from connectAndHandleEvents - error", "errType", fmt.Sprintf("%T", err), "err", err)
+			return err
+		case <-heartbeatTicker.C:
+			c.heartbeat(target.name)
+		case request := <-snoozeRequests:
+			if writeErr := conn.WriteJSON(request); writeErr != nil {
+				connLogger.WithCorrelationID(request.ID).Warn("Error sending snooze request", "err", writeErr)
+			}
+		case report := <-latencyReports:
+			report.AddHop(events.HopReport, time.Now())
+			if writeErr := conn.WriteJSON(report); writeErr != nil {
+				// a broken connection is picked up by the read loop
+				connLogger.WithCorrelationID(report.EventID).Warn("Error sending latency report", "err", writeErr)
+			}
+		}
 	}
-
-	eventTelemetry := appinsights.NewEventTelemetry("unsnooze-event")
-	eventTelemetry.Properties["id"] = fmt.Sprintf("%v", unsnoozeEvent.ID)
-	telemetryClient.Track(eventTelemetry)
-	telemetryClient.Channel().Flush()
-
-	logInformation("Canceling snooze")
-	snoozeExpiry = initTime
-
-	return false
 }
-func handleButtonEvent(buf []byte, relay *gpio.RelayDriver, resultChan chan error) bool {
-	buttonEvent, err := events.ParseButtonEventJSON(buf)
-	if err != nil {
-		logError("Error parsing: (%T) %v\n", err, err)
-		return false
-	}
 
-	eventTelemetry := appinsights.NewEventTelemetry("button-event")
-	eventTelemetry.Properties["id"] = fmt.Sprintf("%v", buttonEvent.ID)
-	eventTelemetry.Properties["type"] = events.TypeToString(buttonEvent.ButtonEventType)
-	eventTelemetry.Properties["source"] = buttonEvent.Source
-	telemetryClient.Track(eventTelemetry)
-	telemetryClient.Channel().Flush()
-
-	switch buttonEvent.ButtonEventType {
-	// NOTE - logic is inverted - see notes in setup
-	case events.ButtonPressed:
-		if snoozeExpiry.After(time.Now()) {
-			logInformation("Snoozed - not turning relay on. Snooze expires at %s", snoozeExpiry.Format(time.RFC3339))
-			return false
-		}
-		if relay == nil {
-			logInformation("Relay not connected - not turning on")
-			return false
+// discoverBellPushes browses for bellpushes via mDNS until ctx is done, starting a connection to each one that is found.
+// Browsing is repeated so that bellpushes that are added later are picked up
+func discoverBellPushes(ctx context.Context, config Config, c *chime, connections *sync.WaitGroup) {
+	const rediscoverInterval = 1 * time.Minute
+	started := map[string]bool{}
+	for {
+		if len(started) == 0 {
+			logger.Info("Discovering bellpushes", "serviceType", discovery.ServiceType)
 		}
-		logInformation("Turning relay on")
-		if err := relay.On(); err != nil {
-			resultChan <- err
-			return true
+		browseCtx, cancel := context.WithTimeout(ctx, discovery.ResolveTimeout)
+		err := discovery.Browse(browseCtx, func(service discovery.Service) {
+			if started[service.Instance] {
+				return
+			}
+			started[service.Instance] = true
+			logger.Info("Discovered bellpush", "instance", service.Instance, "address", service.Address,
+				"version", service.Info.Version, "doors", strings.Join(service.Info.Doors, ","), "tls", service.Info.TLS)
+			target := bellPushTarget{name: service.Instance, instance: service.Instance}
+			connections.Add(1)
+			go func() {
+				defer connections.Done()
+				runConnection(ctx, c, target.name, func(ctx context.Context) error {
+					return connectAndHandleEvents(ctx, config, c, target)
+				})
+			}()
+		})
+		cancel()
+		if err != nil {
+			logger.Error("Failed to discover bellpushes", "err", err)
 		}
-	case events.ButtonReleased:
-		if relay == nil {
-			logInformation("Relay not connected - not turning off")
-			return false
+
+		wait := rediscoverInterval
+		if len(started) == 0 {
+			// keep looking until we've found a bellpush
+			wait = 1 * time.Second
 		}
-		logInformation("Turning relay off")
-		if err := relay.Off(); err != nil {
-			resultChan <- err
-			return true
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
 		}
-	default:
-		logError("Unhandled ButtonEventType: %v \n", buttonEvent.ButtonEventType)
 	}
-
-	return false
 }
 
 func main() {
 	flag.Parse()
-	address := addr
-
-	key := os.Getenv("APPINSIGHTS_INSTRUMENTATIONKEY")
-	telemetryConfig := appinsights.NewTelemetryConfiguration(key) // seems happy to not not error without a key!
-	// Configure the maximum delay before sending queued telemetry:
-	telemetryConfig.MaxBatchInterval = 2 * time.Second
-	telemetryClient = appinsights.NewTelemetryClientFromConfig(telemetryConfig)
-	telemetryClient.Context().Tags.Cloud().SetRole("chime")
-
-	logInformation("chime starting")
-	disableGpioEnv := os.Getenv("DISABLE_GPIO")
-	disableGpio = strings.ToLower(disableGpioEnv) == "true"
-	var led *gpio.LedDriver
+
+	config, err := loadConfig(*configPath, *addr)
+	if *checkConfig {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("Configuration is valid")
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err = config.Log.Apply(); err != nil {
+		panic(err)
+	}
+
+	telemetryClient, err = telemetry.NewClient(config.Telemetry, "chime")
+	if err != nil {
+		panic(err)
+	}
+	defer telemetryClient.Close(5 * time.Second)
+	logging.SetHook(traceToTelemetry)
+
+	logger.Info("chime starting", "version", version.Version, "logLevel", logging.GetLevel(), "config", *configPath, "transport", config.Transport)
+	sdnotify.Status("Starting")
+
+	disableGpio = config.DisableGPIO
+	var led statusLed = noStatusLed{}
 	var relay *gpio.RelayDriver
+	var snoozeButtonReader gpio.DigitalReader
 	if !disableGpio {
-		logInformation("Connecting to raspberry pi ...")
+		logger.Info("Connecting to raspberry pi")
 		raspberryPi := raspi.NewAdaptor()
 		defer raspberryPi.Finalize() // nolint:errcheck
 
-		led = gpio.NewLedDriver(raspberryPi, pi.GPIO17)
-
-		err := led.Start()
-		if err != nil {
-			panic(err) // TODO - don't panic!
+		// the chime still rings without the status LED
+		if led, err = startStatusLed(raspberryPi, config.StatusLed); err != nil {
+			logger.Error("Failed to start status LED - continuing without it", "err", err)
+			led = noStatusLed{}
 		}
 
 		relay = gpio.NewRelayDriver(raspberryPi, pi.GPIO18)
@@ -362,34 +430,123 @@ func main() {
 		if err != nil {
 			panic(err) // TODO - don't panic!
 		}
+
+		snoozeButtonReader = raspberryPi
+	}
+	if config.MetricsAddress != "" {
+		startMetricsListener(config.MetricsAddress)
 	}
-	interruptChan := make(chan os.Signal, 1)
-	signal.Notify(interruptChan, os.Interrupt)
 
-	for {
-		connecting, err := blinkStatusLed(led, 1*time.Second)
-		if err != nil {
-			panic(err) // TODO - don't panic!
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	c := newChime(config.Name, relay, config.Ring)
+	defer c.ringer.offOnPanic()
+	if err = c.player.configure(config.Audio); err != nil {
+		// the relay still rings
+		logger.Error("Failed to set up audio - continuing without it", "err", err)
+		c.setConfigError(true)
+	}
+	c.notifier.configure(config.Desktop)
+	if config.SnoozeButton.Enabled {
+		if snoozeButtonReader == nil {
+			logger.Warn("GPIO is disabled - not starting the snooze button")
+		} else if _, err = newSnoozeButton(config.SnoozeButton, c).start(snoozeButtonReader); err != nil {
+			logger.Error("Failed to start the snooze button - continuing without it", "err", err)
 		}
-		err = connectAndHandleEvents(interruptChan, address, led, relay, connecting)
-		if err == nil {
-			// handler returned so was interrupted by user
-			logInformation("Exiting")
-			break
+	}
+	go reloadConfigOnSignal(*configPath, *addr, config, c)
+	go c.runStatusLed(ctx, led)
+
+	connections := &sync.WaitGroup{}
+	startConnection := func(name string, connect func(context.Context) error) {
+		connections.Add(1)
+		go func() {
+			defer connections.Done()
+			runConnection(ctx, c, name, connect)
+		}()
+	}
+	switch {
+	case config.Transport == transportMQTT:
+		startConnection(config.MQTT.Broker, func(ctx context.Context) error {
+			return connectAndHandleMqttEvents(ctx, config, c)
+		})
+	case len(config.BellPushes) == 0:
+		connections.Add(1)
+		go func() {
+			defer connections.Done()
+			discoverBellPushes(ctx, config, c, connections)
+		}()
+	default:
+		for _, bellPush := range config.BellPushes {
+			target := bellPushTarget{name: bellPush, addresses: failoverAddresses(bellPush)}
+			startConnection(target.name, func(ctx context.Context) error {
+				return connectAndHandleEvents(ctx, config, c, target)
+			})
 		}
+	}
 
-		logError("Failed to connect: (%T) %v\n", err, err)
-		for i := 0; i < 10; i++ {
-			select {
-			case <-interruptChan:
-				return
-			default:
-				err = led.Toggle()
-				if err != nil {
-					panic(err) // TODO - don't panic!
-				}
-				time.Sleep(500 * time.Millisecond)
+	var watchdogTicks <-chan time.Time
+	if watchdogInterval := sdnotify.WatchdogInterval(); watchdogInterval > 0 {
+		watchdogTicker := time.NewTicker(watchdogInterval)
+		defer watchdogTicker.Stop()
+		watchdogTicks = watchdogTicker.C
+	}
+	for running := true; running; {
+		select {
+		case <-ctx.Done():
+			running = false
+		case <-watchdogTicks:
+			// only ping the watchdog while all of the connection loops are running so that systemd restarts a wedged chime
+			if stalled := c.stalledConnections(); len(stalled) > 0 {
+				logger.Warn("Connection loops not running - not pinging watchdog", "bellpushes", strings.Join(stalled, ","))
+				continue
 			}
+			sdnotify.Watchdog()
+		}
+	}
+
+	logger.Info("Exiting")
+	sdnotify.Stopping()
+	connections.Wait()
+	if err = c.ringer.forceOff("shutdown"); err != nil {
+		logger.Error("Error turning relay off", "err", err)
+	}
+	if err = c.player.close(); err != nil {
+		logger.Error("Error closing audio sink", "err", err)
+	}
+	c.notifier.close()
+}
+
+// reloadConfigOnSignal reloads the config on SIGHUP. The log, ring, audio and desktop settings are applied immediately;
+// changes to other settings (compared with running, the config last loaded) are logged as needing a restart.
+// If the new config is invalid then the current settings are kept
+func reloadConfigOnSignal(path string, addrFlag string, running Config, c *chime) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		logger.Info("Reloading config", "config", path)
+		config, err := loadConfig(path, addrFlag)
+		if err != nil {
+			logger.Error("Failed to reload config - keeping current settings", "error", err)
+			c.setConfigError(true)
+			continue
+		}
+		if err = config.Log.Apply(); err != nil {
+			logger.Error("Failed to apply log settings", "error", err)
+		}
+		c.ringer.configure(config.Ring)
+		err = c.player.configure(config.Audio)
+		if err != nil {
+			logger.Error("Failed to apply audio settings - keeping current audio settings", "error", err)
+		}
+		c.setConfigError(err != nil)
+		c.notifier.configure(config.Desktop)
+		if changed := configfile.ChangedSettings(running, config, reloadableSettings...); len(changed) > 0 {
+			logger.Warn("Some changed settings only take effect after a restart", "settings", strings.Join(changed, ","))
 		}
+		// compare the next reload with this config so that the warnings are only logged once for each change
+		running = config
+		logger.Info("Config reloaded", "logLevel", logging.GetLevel())
 	}
 }
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stuartleeks/pi-bell/internal/pkg/logging"
+)
+
+var (
+	connectedGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pibell_chime_connected",
+		Help: "Whether the chime is connected to the bellpush (1) or not (0)",
+	}, []string{"bellpush"})
+	reconnectAttemptsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pibell_chime_reconnect_attempts_total",
+		Help: "The number of attempts to reconnect to the bellpush",
+	}, []string{"bellpush"})
+	duplicateEventsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pibell_chime_duplicate_events_total",
+		Help: "The number of events ignored because they had already been received (e.g. from another bellpush)",
+	})
+	relayActivationsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pibell_chime_relay_activations_total",
+		Help: "The number of times the relay has been turned on",
+	})
+	maxOnTimeCutoffsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pibell_chime_max_on_time_cutoffs_total",
+		Help: "The number of times the relay was turned off because it had been on for the max on-time (e.g. a lost release or stuck button)",
+	})
+	coolDownPressesCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pibell_chime_cool_down_presses_total",
+		Help: "The number of presses ignored because the relay was cooling down after the previous ring",
+	})
+	soundsPlayedCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pibell_chime_sounds_played_total",
+		Help: "The number of times a sound has been played for a ring",
+	})
+	audioErrorsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pibell_chime_audio_errors_total",
+		Help: "The number of sounds that failed to play",
+	})
+	desktopNotificationsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pibell_chime_desktop_notifications_total",
+		Help: "The number of desktop notifications shown for rings",
+	})
+	desktopErrorsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pibell_chime_desktop_notification_errors_total",
+		Help: "The number of desktop notifications that failed to show",
+	})
+	snoozeRequestsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pibell_chime_snooze_requests_total",
+		Help: "The number of snooze requests from the snooze button by action (snooze or cancel)",
+	}, []string{"action"})
+	statusLedErrorsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pibell_chime_status_led_errors_total",
+		Help: "The number of times setting the status LED failed",
+	})
+	missedRingsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pibell_chime_missed_rings_total",
+		Help: "The number of rings missed while the chime was disconnected (reported by the bellpushes when it reconnects)",
+	})
+	relayForcedOffCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pibell_chime_relay_forced_off_total",
+		Help: "The number of times the relay was forced off (by reason: disconnect, shutdown or panic)",
+	}, []string{"reason"})
+	eventToRelayLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "pibell_chime_event_to_relay_latency_seconds",
+		Help:    "The time from the button event on the bellpush to the relay being turned on (includes any clock skew between the Pis)",
+		Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+	})
+)
+
+// startMetricsListener serves the Prometheus metrics (and the log level endpoint) on addr in the background
+func startMetricsListener(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/log/level", logging.LevelHandler())
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		logger.Info("Starting metrics listener", "addr", addr)
+		if err := server.ListenAndServe(); err != nil {
+			logger.Error("Metrics listener failed", "err", err)
+		}
+	}()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/stuartleeks/pi-bell/internal/pkg/events"
+)
+
+// missedRingsIndicatorDuration is how long the status LED shows that rings were missed while the chime was
+// disconnected (unless the bell rings again first)
+const missedRingsIndicatorDuration = 1 * time.Hour
+
+// handleMissedRingsEvent handles the summary of the rings that the chime missed while it was disconnected
+// from bellPush, which is sent when the chime reconnects
+func (c *chime) handleMissedRingsEvent(bellPush string, missedRingsEvent *events.MissedRingsEvent) {
+	telemetryClient.TrackEvent("missed-rings-event", map[string]string{
+		"id":             fmt.Sprintf("%v", missedRingsEvent.ID),
+		"bellpush":       bellPush,
+		"count":          fmt.Sprintf("%d", missedRingsEvent.Count),
+		"disconnectedAt": missedRingsEvent.DisconnectedAt.Format(time.RFC3339),
+	})
+
+	eventLogger := logger.WithCorrelationID(missedRingsEvent.ID).With("bellpush", bellPush)
+	eventLogger.Info("Missed rings while disconnected", "count", missedRingsEvent.Count, "disconnectedAt", missedRingsEvent.DisconnectedAt)
+	for _, ring := range missedRingsEvent.Rings {
+		eventLogger.Info("Missed ring", "ringID", ring.ID, "time", ring.Time, "door", ring.Door, "source", ring.Source)
+	}
+	missedRingsCounter.Add(float64(missedRingsEvent.Count))
+
+	c.mutex.Lock()
+	c.missedRingsUntil = time.Now().Add(missedRingsIndicatorDuration)
+	c.mutex.Unlock()
+	c.notifyHealthChanged()
+	c.notifier.notifyMissedRings(eventLogger, missedRingsEvent)
+}
+
+// hasMissedRings returns true if the status LED should show that rings were missed
+func (c *chime) hasMissedRings() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.missedRingsUntil.After(time.Now())
+}
+
+// clearMissedRings stops the status LED showing that rings were missed (e.g. when the bell rings again)
+func (c *chime) clearMissedRings() {
+	c.mutex.Lock()
+	cleared := c.missedRingsUntil.After(time.Now())
+	c.missedRingsUntil = time.Time{}
+	c.mutex.Unlock()
+	if cleared {
+		c.notifyHealthChanged()
+	}
+}
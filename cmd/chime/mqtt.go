@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stuartleeks/pi-bell/internal/pkg/events"
+	"github.com/stuartleeks/pi-bell/internal/pkg/mqttutils"
+)
+
+const mqttTimeout = 10 * time.Second
+
+// connectAndHandleMqttEvents uses an MQTT broker as the transport rather than websockets to the bellpushes.
+// Button events are received from all bellpushes (or the one named in mqtt.bellpush) and
+// snooze state is received from retained topics so that it survives a bellpush restart
+func connectAndHandleMqttEvents(ctx context.Context, config Config, c *chime) error {
+	chimeName := config.Name
+	mqttConfig := config.MQTT.Config
+	bellPushNodeID := config.MQTT.BellPush
+
+	statusTopic := mqttConfig.ChimePresenceTopic(chimeName, "status")
+	infoTopic := mqttConfig.ChimePresenceTopic(chimeName, "info")
+	ackTopic := mqttConfig.ChimePresenceTopic(chimeName, "ack")
+	eventsTopic := mqttConfig.BellPushTopic(bellPushNodeID, "events")
+	snoozeTopic := mqttConfig.ChimeTopic(bellPushNodeID, chimeName, "snooze")
+	missedRingsTopic := mqttConfig.ChimeTopic(bellPushNodeID, chimeName, "missed-rings")
+
+	connLogger := logger.With("broker", mqttConfig.Broker)
+	resultChan := make(chan error, 1)
+	handleMessage := func(client mqtt.Client, message mqtt.Message) {
+		defer c.ringer.offOnPanic()
+		receivedAt := time.Now()
+		buf := message.Payload()
+		connLogger.Debug("Received message", "topic", message.Topic(), "payload", string(buf))
+		// snoozes are tracked per bellpush node, as for websocket connections to multiple bellpushes
+		if err := c.handleEventMessage(mqttConfig.NodeID(message.Topic()), buf, receivedAt, nil); err != nil {
+			select {
+			case resultChan <- err:
+			default:
+			}
+			return
+		}
+		publishAck(client, ackTopic, chimeName, buf)
+	}
+
+	options := mqttConfig.NewClientOptions("pibell-chime-"+mqttutils.SanitizeID(chimeName), statusTopic)
+	// the main loop handles reconnecting (and the status LED) in the same way as for websockets
+	options.SetAutoReconnect(false)
+	options.SetConnectRetry(false)
+	options.SetConnectTimeout(mqttTimeout)
+	options.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		select {
+		case resultChan <- fmt.Errorf("mqtt connection lost: %v", err):
+		default:
+		}
+	})
+
+	// buttons are held per bellpush node but the connection is to the broker, so release them all when it drops
+	defer func() {
+		if err := c.ringer.forceOff("disconnect"); err != nil {
+			connLogger.Error("Error turning relay off", "err", err)
+		}
+	}()
+
+	connLogger.Info("Connecting")
+	client := mqtt.NewClient(options)
+	if err := mqttutils.Wait(client.Connect(), mqttTimeout); err != nil {
+		if errors.Is(err, packets.ErrorRefusedBadUsernameOrPassword) || errors.Is(err, packets.ErrorRefusedNotAuthorised) {
+			err = fmt.Errorf("%w: %v", errAuthFailed, err)
+		}
+		return fmt.Errorf("connect to %s failed: %w", mqttConfig.Broker, err)
+	}
+	defer client.Disconnect(250)
+
+	connLogger.Info("Subscribing", "eventsTopic", eventsTopic, "snoozeTopic", snoozeTopic)
+	if err := mqttutils.Wait(client.Subscribe(snoozeTopic, 1, handleMessage), mqttTimeout); err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %v", snoozeTopic, err)
+	}
+	if err := mqttutils.Wait(client.Subscribe(missedRingsTopic, 1, handleMessage), mqttTimeout); err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %v", missedRingsTopic, err)
+	}
+	if err := mqttutils.Wait(client.Subscribe(eventsTopic, 1, handleMessage), mqttTimeout); err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %v", eventsTopic, err)
+	}
+
+	// Publish the chime's name before its presence so that the bellpush registers the chime under that name
+	// rather than the sanitized name in the topics
+	info, err := json.Marshal(mqttutils.ChimeInfo{Name: chimeName})
+	if err != nil {
+		return fmt.Errorf("failed to create info: %v", err)
+	}
+	if err := mqttutils.Wait(client.Publish(infoTopic, 1, true, info), mqttTimeout); err != nil {
+		return fmt.Errorf("failed to publish info: %v", err)
+	}
+
+	// Announce presence - the last will on the connection sets this to offline if we drop off
+	if err := mqttutils.Wait(client.Publish(statusTopic, 1, true, mqttutils.PayloadOnline), mqttTimeout); err != nil {
+		return fmt.Errorf("failed to publish status: %v", err)
+	}
+
+	// snooze requests go to every bellpush node via the chime's presence topics
+	snoozeRequestTopic := mqttConfig.ChimePresenceTopic(chimeName, "snooze-request")
+	c.setSnoozeSender(mqttConfig.Broker, func(request *events.SnoozeRequest) error {
+		payload, err := json.Marshal(request)
+		if err != nil {
+			return err
+		}
+		return mqttutils.Wait(client.Publish(snoozeRequestTopic, 1, false, payload), mqttTimeout)
+	})
+
+	// connected to broker -> update the status LED
+	c.setConnected(mqttConfig.Broker)
+
+	heartbeatTicker := time.NewTicker(heartbeatInterval)
+	defer heartbeatTicker.Stop()
+	for {
+		select {
+		case <-heartbeatTicker.C:
+			c.heartbeat(mqttConfig.Broker)
+		case <-ctx.Done():
+			connLogger.Info("Returning from connectAndHandleMqttEvents - no error")
+			if err := mqttutils.Wait(client.Publish(statusTopic, 1, true, mqttutils.PayloadOffline), mqttTimeout); err != nil {
+				connLogger.Error("Failed to publish offline status", "err", err)
+			}
+			return nil
+		case err := <-resultChan:
+			connLogger.Error("Returning from connectAndHandleMqttEvents - error", "errType", fmt.Sprintf("%T", err), "err", err)
+			return err
+		}
+	}
+}
+
+// publishAck lets the bellpush know that the chime has handled an event
+func publishAck(client mqtt.Client, ackTopic string, chimeName string, buf []byte) {
+	event, err := events.Decode(buf)
+	if err != nil {
+		logger.Error("Error parsing event for ack", "err", err)
+		return
+	}
+	ack, err := json.Marshal(mqttutils.Ack{
+		ID:        event.GetID().String(),
+		EventType: event.GetType(),
+		ChimeName: chimeName,
+		Time:      time.Now(),
+	})
+	if err != nil {
+		logger.WithCorrelationID(event.GetID()).Error("Error creating ack", "err", err)
+		return
+	}
+	// don't wait for the publish to complete as we're in the message handler
+	client.Publish(ackTopic, 1, false, ack)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stuartleeks/pi-bell/internal/pkg/events"
+)
+
+// welcomeTimeout is how long to wait for the welcome message after sending the hello message. A bellpush that
+// doesn't send one is treated as using protocol version 1
+const welcomeTimeout = 5 * time.Second
+
+// incompatibleRetryDelay is how long to wait before reconnecting to a bellpush with an incompatible protocol
+const incompatibleRetryDelay = 1 * time.Minute
+
+// errIncompatibleProtocol is returned when the chime and bellpush don't have a protocol version in common
+var errIncompatibleProtocol = errors.New("incompatible protocol")
+
+// errLateWelcome is returned when the welcome message arrives after welcomeTimeout, by which time the chime is
+// using protocol version 1. The connection is closed so that the chime and bellpush negotiate again on reconnecting
+var errLateWelcome = errors.New("welcome message arrived after the chime fell back to protocol version 1")
+
+// capabilities returns the capabilities to send to the bellpush in the hello message: the chime's hardware and
+// the protocol features that it supports
+func (c *chime) capabilities() []string {
+	capabilities := []string{}
+	if c.ringer.relay != nil {
+		// the status LED is on the GPIO pins with the relay
+		capabilities = append(capabilities, events.CapabilityRelay, events.CapabilityLED)
+	}
+	if c.player.playsSounds() {
+		capabilities = append(capabilities, events.CapabilityAudio)
+	}
+	if c.notifier.enabled() {
+		capabilities = append(capabilities, events.CapabilityDesktop)
+	}
+	return append(capabilities, events.Features...)
+}
+
+// checkWelcome returns an error if the protocol version chosen by the bellpush isn't one that the chime supports
+func checkWelcome(welcome *events.Welcome) error {
+	if welcome.ProtocolVersion < events.MinProtocolVersion || welcome.ProtocolVersion > events.ProtocolVersion {
+		return fmt.Errorf("%w: bellpush chose version %d, chime supports %d-%d", errIncompatibleProtocol,
+			welcome.ProtocolVersion, events.MinProtocolVersion, events.ProtocolVersion)
+	}
+	return nil
+}
+
+// handshakeError returns the error for the connection failing before the welcome message, which includes the
+// bellpush's reason if it rejected the chime's protocol versions
+func handshakeError(err error) error {
+	var closeError *websocket.CloseError
+	if errors.As(err, &closeError) && closeError.Code == websocket.CloseProtocolError {
+		return fmt.Errorf("%w: %s", errIncompatibleProtocol, closeError.Text)
+	}
+	return err
+}
@@ -0,0 +1,558 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stuartleeks/pi-bell/internal/pkg/configfile"
+	"github.com/stuartleeks/pi-bell/internal/pkg/events"
+	"github.com/stuartleeks/pi-bell/internal/pkg/logging"
+	"gobot.io/x/gobot/drivers/gpio"
+)
+
+// patternHold turns the relay on while the button is held (up to the max on-time) rather than playing a pattern
+const patternHold = "hold"
+
+// Pattern is a ring pattern: the durations that the relay is on and off for, alternately, starting with on.
+// In the config file this is a comma-separated list, e.g. "300ms,200ms,300ms" for two pulses
+type Pattern []time.Duration
+
+// UnmarshalText parses a comma-separated list of durations
+func (p *Pattern) UnmarshalText(text []byte) error {
+	pattern := Pattern{}
+	for _, value := range strings.Split(string(text), ",") {
+		duration, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", string(text), err)
+		}
+		pattern = append(pattern, duration)
+	}
+	*p = pattern
+	return nil
+}
+
+func (p Pattern) String() string {
+	values := make([]string, 0, len(p))
+	for _, duration := range p {
+		values = append(values, duration.String())
+	}
+	return strings.Join(values, ",")
+}
+
+// builtInPatterns are the patterns that can be used without defining them in the config file.
+// A door chime solenoid strikes one bar when it is energised and the other when it is released,
+// so each on pulse is a "ding-dong"
+var builtInPatterns = map[string]Pattern{
+	"ding":        {150 * time.Millisecond},
+	"ding-dong":   {500 * time.Millisecond},
+	"double":      {400 * time.Millisecond, 400 * time.Millisecond, 400 * time.Millisecond},
+	"triple":      {300 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond},
+	"westminster": {400 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 200 * time.Millisecond, 900 * time.Millisecond, 700 * time.Millisecond, 400 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 200 * time.Millisecond, 900 * time.Millisecond},
+}
+
+// DoorPatterns selects a pattern by door name. In environment variables this is a comma-separated
+// list of door=pattern pairs, e.g. RING_DOORS=Front door=ding-dong,Garage=triple
+type DoorPatterns map[string]string
+
+// UnmarshalText parses a comma-separated list of door=pattern pairs
+func (d *DoorPatterns) UnmarshalText(text []byte) error {
+	doors, err := parseDoorValues(string(text), "pattern")
+	if err != nil {
+		return err
+	}
+	*d = doors
+	return nil
+}
+
+// parseDoorValues parses a comma-separated list of door=value pairs. kind names the value in errors
+func parseDoorValues(text string, kind string) (map[string]string, error) {
+	return parseKeyValues(text, "door", kind)
+}
+
+// parseKeyValues parses a comma-separated list of key=value pairs. key and kind name the key and value in errors
+func parseKeyValues(text string, key string, kind string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, pair := range strings.Split(text, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		k, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid %s %s %q: expected %s=%s", key, kind, pair, key, kind)
+		}
+		values[strings.TrimSpace(k)] = strings.TrimSpace(value)
+	}
+	return values, nil
+}
+
+// pressKinds are the press classifications sent by the bellpush after a release, which can each have a pattern
+var pressKinds = []string{
+	events.TypeToString(events.ButtonShortPress),
+	events.TypeToString(events.ButtonLongPress),
+	events.TypeToString(events.ButtonDoublePress),
+}
+
+// PressPatterns selects a pattern by press kind (short-press, long-press or double-press). In environment
+// variables this is a comma-separated list of kind=pattern pairs, e.g. RING_PRESSES=long-press=westminster
+type PressPatterns map[string]string
+
+// UnmarshalText parses a comma-separated list of kind=pattern pairs
+func (p *PressPatterns) UnmarshalText(text []byte) error {
+	presses, err := parseKeyValues(string(text), "press", "pattern")
+	if err != nil {
+		return err
+	}
+	*p = presses
+	return nil
+}
+
+// RingConfig holds the settings for how the chime rings
+type RingConfig struct {
+	// Pattern is the name of the pattern to play (hold to turn the relay on while the button is held)
+	Pattern string `yaml:"pattern" env:"RING_PATTERN"`
+	// Doors overrides the pattern for events from specific doors
+	Doors DoorPatterns `yaml:"doors" env:"RING_DOORS"`
+	// Presses selects a pattern to play when the bellpush classifies a press, after the ring for the press
+	Presses PressPatterns `yaml:"presses" env:"RING_PRESSES"`
+	// Patterns defines custom patterns (which can also replace the built-in patterns)
+	Patterns map[string]Pattern `yaml:"patterns"`
+	// MaxOnTime is the longest that the relay is kept on for, whatever the bellpush sends
+	MaxOnTime time.Duration `yaml:"maxOnTime" env:"RING_MAX_ON_TIME"`
+	// CoolDown is the minimum time between the relay turning off and the next ring
+	CoolDown time.Duration `yaml:"coolDown" env:"RING_COOL_DOWN"`
+}
+
+// DefaultRingConfig returns the default ring settings, which mirror the button (up to the max on-time)
+func DefaultRingConfig() RingConfig {
+	return RingConfig{
+		Pattern:   patternHold,
+		MaxOnTime: 3 * time.Second,
+		CoolDown:  1 * time.Second,
+	}
+}
+
+// Validate checks the settings, adding any problems to errs
+func (c RingConfig) Validate(errs *configfile.Errors, path string) {
+	if c.MaxOnTime <= 0 {
+		errs.Add(configfile.Join(path, "maxOnTime"), "must be greater than zero")
+	}
+	if c.CoolDown < 0 {
+		errs.Add(configfile.Join(path, "coolDown"), "must not be negative")
+	}
+	if _, ok := c.pattern(c.Pattern); !ok {
+		errs.Add(configfile.Join(path, "pattern"), "unknown pattern %q (expected %s)", c.Pattern, strings.Join(c.patternNames(), ", "))
+	}
+	for door, name := range c.Doors {
+		if _, ok := c.pattern(name); !ok {
+			errs.Add(configfile.Join(configfile.Join(path, "doors"), door), "unknown pattern %q (expected %s)", name, strings.Join(c.patternNames(), ", "))
+		}
+	}
+	for kind, name := range c.Presses {
+		pressPath := configfile.Join(configfile.Join(path, "presses"), kind)
+		if !containsString(pressKinds, kind) {
+			errs.Add(pressPath, "unknown press kind (expected %s)", strings.Join(pressKinds, ", "))
+		}
+		if name == patternHold {
+			// the button has already been released when the press is classified
+			errs.Add(pressPath, "%s can't be used for a press kind", patternHold)
+		} else if _, ok := c.pattern(name); !ok {
+			errs.Add(pressPath, "unknown pattern %q (expected %s)", name, strings.Join(c.patternNames()[1:], ", "))
+		}
+	}
+	for name, pattern := range c.Patterns {
+		patternPath := configfile.Join(configfile.Join(path, "patterns"), name)
+		if name == patternHold {
+			errs.Add(patternPath, "%s is reserved", patternHold)
+		}
+		if len(pattern) == 0 {
+			errs.Add(patternPath, "must have at least one duration")
+		}
+		for i, duration := range pattern {
+			switch {
+			case duration <= 0:
+				errs.Add(patternPath, "durations must be greater than zero")
+			case i%2 == 0 && c.MaxOnTime > 0 && duration > c.MaxOnTime:
+				errs.Add(patternPath, "on time %s is longer than maxOnTime (%s)", duration, c.MaxOnTime)
+			}
+		}
+	}
+}
+
+// pattern returns the named pattern (nil for hold) and whether it exists
+func (c RingConfig) pattern(name string) (Pattern, bool) {
+	if name == patternHold {
+		return nil, true
+	}
+	if pattern, ok := c.Patterns[name]; ok {
+		return pattern, true
+	}
+	pattern, ok := builtInPatterns[name]
+	return pattern, ok
+}
+
+func (c RingConfig) patternNames() []string {
+	names := []string{patternHold}
+	for name := range builtInPatterns {
+		names = append(names, name)
+	}
+	for name := range c.Patterns {
+		if _, ok := builtInPatterns[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// patternForDoor returns the name of the pattern for events from door
+func (c RingConfig) patternForDoor(door string) string {
+	if name, ok := c.Doors[door]; ok {
+		return name
+	}
+	return c.Pattern
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ringer switches the relay for button events, either holding it on while the button is pressed or
+// playing a pattern. No single on period is longer than the max on-time, and the relay isn't turned on
+// again until the cool-down has passed since it was last turned off
+type ringer struct {
+	relay *gpio.RelayDriver
+
+	mutex  sync.Mutex
+	config RingConfig
+	// held is the set of bellpushes whose button is held with the hold pattern
+	held map[string]bool
+	// holdGeneration is incremented when the relay is turned on for a hold so that stale cut-off timers are ignored
+	holdGeneration int
+	cutoff         *time.Timer
+	// playing is true while a pattern is playing and stopPlaying is closed to stop it
+	playing     bool
+	stopPlaying chan struct{}
+	// lastOff is when the relay was last turned off at the end of a ring
+	lastOff time.Time
+	// pending is the name of the pattern for a classified press, which plays when the relay is free and the
+	// cool-down has passed (see pressClassified)
+	pending       string
+	pendingLogger *logging.Logger
+	pendingTimer  *time.Timer
+}
+
+func newRinger(relay *gpio.RelayDriver, config RingConfig) *ringer {
+	return &ringer{
+		relay:  relay,
+		config: config,
+		held:   map[string]bool{},
+	}
+}
+
+// configure applies new ring settings (e.g. when the config is reloaded). A pattern that is playing isn't affected
+func (r *ringer) configure(config RingConfig) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.config = config
+}
+
+// press handles a button press from bellPush for door. Returns true if the relay was turned on
+func (r *ringer) press(eventLogger *logging.Logger, bellPush string, door string) (bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	name := r.config.patternForDoor(door)
+	pattern, _ := r.config.pattern(name)
+	eventLogger = eventLogger.With("pattern", name)
+	// a new press replaces the pattern for the previous press
+	r.clearPendingLocked()
+	if r.playing {
+		eventLogger.Info("Pattern already playing - ignoring press")
+		return false, nil
+	}
+	if name == patternHold {
+		return r.holdLocked(eventLogger, bellPush)
+	}
+	if len(r.held) > 0 {
+		eventLogger.Info("Button held on another bellpush - ignoring press")
+		return false, nil
+	}
+	return r.startPatternLocked(eventLogger, pattern)
+}
+
+// startPatternLocked turns the relay on and starts playing pattern. Returns true if the relay was turned on
+func (r *ringer) startPatternLocked(eventLogger *logging.Logger, pattern Pattern) (bool, error) {
+	if r.relay == nil {
+		eventLogger.Info("Relay not connected - not playing pattern")
+		return false, nil
+	}
+	if r.coolingDownLocked(eventLogger) {
+		return false, nil
+	}
+
+	eventLogger.Info("Playing pattern", "durations", pattern.String())
+	if err := r.relay.On(); err != nil {
+		return false, err
+	}
+	r.playing = true
+	r.stopPlaying = make(chan struct{})
+	go r.play(eventLogger, pattern, r.stopPlaying)
+	return true, nil
+}
+
+// coolingDownLocked returns true (and logs that the press is ignored) if the relay was turned off less than the cool-down ago
+func (r *ringer) coolingDownLocked(eventLogger *logging.Logger) bool {
+	if r.lastOff.IsZero() {
+		return false
+	}
+	remaining := r.config.CoolDown - time.Since(r.lastOff)
+	if remaining <= 0 {
+		return false
+	}
+	eventLogger.Info("Relay cooling down - ignoring press", "remaining", remaining)
+	coolDownPressesCounter.Inc()
+	return true
+}
+
+func (r *ringer) holdLocked(eventLogger *logging.Logger, bellPush string) (bool, error) {
+	if r.relay == nil {
+		r.held[bellPush] = true
+		eventLogger.Info("Relay not connected - not turning on")
+		return false, nil
+	}
+	if len(r.held) > 0 {
+		r.held[bellPush] = true
+		eventLogger.Info("Button held on another bellpush - relay already on")
+		return false, nil
+	}
+	if r.coolingDownLocked(eventLogger) {
+		return false, nil
+	}
+	r.held[bellPush] = true
+	eventLogger.Info("Turning relay on")
+	if err := r.relay.On(); err != nil {
+		delete(r.held, bellPush)
+		return false, err
+	}
+	// turn the relay off after the max on-time in case the release is lost or the button is stuck
+	r.holdGeneration++
+	generation := r.holdGeneration
+	maxOnTime := r.config.MaxOnTime
+	r.cutoff = time.AfterFunc(maxOnTime, func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		if generation != r.holdGeneration || len(r.held) == 0 {
+			return
+		}
+		logger.Warn("Relay on for max on-time - turning off", "maxOnTime", maxOnTime)
+		r.held = map[string]bool{}
+		maxOnTimeCutoffsCounter.Inc()
+		r.lastOff = time.Now()
+		if err := r.relay.Off(); err != nil {
+			logger.Error("Error turning relay off", "err", err)
+		}
+		r.schedulePendingLocked()
+	})
+	return true, nil
+}
+
+// play plays the rest of pattern after the relay has been turned on for the first pulse.
+// It returns early (with the relay off) when stop is closed
+func (r *ringer) play(eventLogger *logging.Logger, pattern Pattern, stop <-chan struct{}) {
+	defer r.offOnPanic()
+	defer func() {
+		r.mutex.Lock()
+		r.playing = false
+		r.lastOff = time.Now()
+		r.schedulePendingLocked()
+		r.mutex.Unlock()
+	}()
+	r.mutex.Lock()
+	maxOnTime := r.config.MaxOnTime
+	r.mutex.Unlock()
+
+	for i, duration := range pattern {
+		on := i%2 == 0
+		if on && i > 0 {
+			if err := r.relay.On(); err != nil {
+				eventLogger.Error("Error turning relay on", "err", err)
+				return
+			}
+		}
+		if on && duration > maxOnTime {
+			duration = maxOnTime
+		}
+		timer := time.NewTimer(duration)
+		select {
+		case <-timer.C:
+		case <-stop:
+			// the relay has been forced off
+			timer.Stop()
+			eventLogger.Info("Pattern stopped")
+			return
+		}
+		if on {
+			if err := r.relay.Off(); err != nil {
+				// keep going so that the next off step retries
+				eventLogger.Error("Error turning relay off", "err", err)
+			}
+		}
+	}
+	eventLogger.Debug("Pattern finished")
+}
+
+// release handles a button release (or disconnect) from bellPush. The relay is turned off when no button is held.
+// Patterns play to the end regardless of the release
+func (r *ringer) release(bellPush string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.held[bellPush] {
+		return nil
+	}
+	delete(r.held, bellPush)
+	if r.relay == nil {
+		logger.Info("Relay not connected - not turning off", "bellpush", bellPush)
+		return nil
+	}
+	if len(r.held) > 0 {
+		logger.Info("Button still held on another bellpush - leaving relay on", "bellpush", bellPush)
+		return nil
+	}
+	r.holdGeneration++
+	if r.cutoff != nil {
+		r.cutoff.Stop()
+	}
+	logger.Info("Turning relay off", "bellpush", bellPush)
+	r.lastOff = time.Now()
+	err := r.relay.Off()
+	r.schedulePendingLocked()
+	return err
+}
+
+// pressClassified handles the classification of a press by the bellpush (e.g. long-press). If there is a pattern
+// for the kind of press then it is played once the ring for the press has finished and the cool-down has passed.
+// Returns false if there isn't a pattern for the kind
+func (r *ringer) pressClassified(eventLogger *logging.Logger, kind string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	name, ok := r.config.Presses[kind]
+	if !ok {
+		return false
+	}
+	r.clearPendingLocked()
+	r.pending = name
+	r.pendingLogger = eventLogger.With("pattern", name, "press", kind)
+	r.schedulePendingLocked()
+	return true
+}
+
+// schedulePendingLocked starts a timer to play the pending press pattern when the cool-down has passed. While the
+// relay is in use it does nothing, as it is called again when the relay is turned off
+func (r *ringer) schedulePendingLocked() {
+	if r.pending == "" || r.playing || len(r.held) > 0 {
+		return
+	}
+	if r.pendingTimer != nil {
+		r.pendingTimer.Stop()
+	}
+	wait := time.Duration(0)
+	if !r.lastOff.IsZero() {
+		wait = r.config.CoolDown - time.Since(r.lastOff)
+	}
+	if wait > 0 {
+		r.pendingLogger.Debug("Waiting for cool-down before playing press pattern", "wait", wait)
+	}
+	r.pendingTimer = time.AfterFunc(wait, r.playPending)
+}
+
+// playPending plays the pending press pattern (see pressClassified)
+func (r *ringer) playPending() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	name, eventLogger := r.pending, r.pendingLogger
+	r.pending, r.pendingLogger, r.pendingTimer = "", nil, nil
+	if name == "" || r.playing || len(r.held) > 0 {
+		// the pattern was replaced or the relay was turned on again in the meantime
+		return
+	}
+	pattern, ok := r.config.pattern(name)
+	if !ok {
+		// removed when the config was reloaded
+		eventLogger.Warn("Press pattern no longer exists - not playing")
+		return
+	}
+	if _, err := r.startPatternLocked(eventLogger, pattern); err != nil {
+		eventLogger.Error("Error turning relay on", "err", err)
+	}
+}
+
+// clearPendingLocked forgets the pending press pattern
+func (r *ringer) clearPendingLocked() {
+	if r.pendingTimer != nil {
+		r.pendingTimer.Stop()
+	}
+	r.pending, r.pendingLogger, r.pendingTimer = "", nil, nil
+}
+
+// forceOff turns the relay off whatever the buttons are doing, e.g. on shutdown. Any held buttons are
+// forgotten and a playing pattern is stopped. reason is used in the log and metrics
+func (r *ringer) forceOff(reason string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	ringing := len(r.held) > 0 || r.playing
+	r.held = map[string]bool{}
+	r.clearPendingLocked()
+	r.holdGeneration++
+	if r.cutoff != nil {
+		r.cutoff.Stop()
+	}
+	if r.playing && r.stopPlaying != nil {
+		close(r.stopPlaying)
+		r.stopPlaying = nil
+	}
+	if r.relay == nil {
+		return nil
+	}
+	if ringing {
+		logger.Warn("Forcing relay off", "reason", reason)
+		relayForcedOffCounter.WithLabelValues(reason).Inc()
+		r.lastOff = time.Now()
+	}
+	// turn the relay off even if it should already be off
+	return r.relay.Off()
+}
+
+// offOnPanic forces the relay off if the goroutine is panicking and then continues the panic,
+// so that the relay isn't left on when the chime crashes. Call it with defer
+func (r *ringer) offOnPanic() {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+	logger.Error("Panic - forcing relay off", "panic", recovered)
+	if err := r.forceOff("panic"); err != nil {
+		logger.Error("Error turning relay off", "err", err)
+	}
+	panic(recovered)
+}
+
+// patternForDoor returns the name of the pattern for events from door
+func (r *ringer) patternForDoor(door string) string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.config.patternForDoor(door)
+}
+
+// isHeld returns true if the button on bellPush is held with the hold pattern
+func (r *ringer) isHeld(bellPush string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.held[bellPush]
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stuartleeks/pi-bell/internal/pkg/configfile"
+	"gobot.io/x/gobot/drivers/gpio"
+)
+
+// fakeRelay records the level written to the relay pin
+type fakeRelay struct {
+	mutex    sync.Mutex
+	on       bool
+	switches int
+}
+
+func (f *fakeRelay) DigitalWrite(_ string, level byte) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	on := level == 1
+	if on != f.on {
+		f.switches++
+	}
+	f.on = on
+	return nil
+}
+
+// state returns whether the relay is on and how many times it has switched
+func (f *fakeRelay) state() (bool, int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.on, f.switches
+}
+
+// newTestRinger returns a ringer with config that switches a fake relay
+func newTestRinger(t *testing.T, config RingConfig) (*ringer, *fakeRelay) {
+	relay := &fakeRelay{}
+	r := newRinger(gpio.NewRelayDriver(relay, "test"), config)
+	t.Cleanup(func() {
+		if err := r.forceOff("test"); err != nil {
+			t.Errorf("forceOff returned an error: %v", err)
+		}
+	})
+	return r, relay
+}
+
+func TestRingerIgnoresPressDuringCoolDown(t *testing.T) {
+	r, relay := newTestRinger(t, RingConfig{Pattern: patternHold, MaxOnTime: time.Second, CoolDown: 100 * time.Millisecond})
+
+	if on, err := r.press(logger, "front", ""); err != nil || !on {
+		t.Fatalf("expected the first press to turn the relay on, got %v, %v", on, err)
+	}
+	if err := r.release("front"); err != nil {
+		t.Fatalf("release returned an error: %v", err)
+	}
+	if on, err := r.press(logger, "front", ""); err != nil || on {
+		t.Errorf("expected a press during the cool-down to be ignored, got %v, %v", on, err)
+	}
+	if on, _ := relay.state(); on {
+		t.Error("expected the relay to be off during the cool-down")
+	}
+	if err := r.release("front"); err != nil {
+		t.Fatalf("release returned an error: %v", err)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if on, err := r.press(logger, "front", ""); err != nil || !on {
+		t.Errorf("expected a press after the cool-down to turn the relay on, got %v, %v", on, err)
+	}
+}
+
+func TestRingerForceOffStopsPattern(t *testing.T) {
+	r, relay := newTestRinger(t, RingConfig{
+		Pattern:   "slow",
+		Patterns:  map[string]Pattern{"slow": {200 * time.Millisecond, 50 * time.Millisecond, 200 * time.Millisecond}},
+		MaxOnTime: time.Second,
+	})
+
+	if on, err := r.press(logger, "front", ""); err != nil || !on {
+		t.Fatalf("expected the press to start the pattern, got %v, %v", on, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := r.forceOff("disconnect"); err != nil {
+		t.Fatalf("forceOff returned an error: %v", err)
+	}
+	if on, _ := relay.state(); on {
+		t.Error("expected forceOff to turn the relay off")
+	}
+
+	// the pattern doesn't turn the relay back on for its second pulse
+	time.Sleep(400 * time.Millisecond)
+	if on, switches := relay.state(); on || switches != 2 {
+		t.Errorf("expected the relay to stay off after switching twice, got on=%v after %d switches", on, switches)
+	}
+}
+
+func TestPatternUnmarshalText(t *testing.T) {
+	testCases := []struct {
+		text        string
+		expected    Pattern
+		expectError bool
+	}{
+		{text: "300ms", expected: Pattern{300 * time.Millisecond}},
+		{text: "300ms, 200ms,1s", expected: Pattern{300 * time.Millisecond, 200 * time.Millisecond, time.Second}},
+		{text: "", expectError: true},
+		{text: "300ms,", expectError: true},
+		{text: "300", expectError: true},
+		{text: "ding", expectError: true},
+	}
+	for _, testCase := range testCases {
+		var pattern Pattern
+		err := pattern.UnmarshalText([]byte(testCase.text))
+		if testCase.expectError {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", testCase.text, pattern)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: UnmarshalText returned an error: %v", testCase.text, err)
+			continue
+		}
+		if pattern.String() != testCase.expected.String() {
+			t.Errorf("%q: expected %s, got %s", testCase.text, testCase.expected, pattern)
+		}
+	}
+}
+
+func TestRingConfigValidate(t *testing.T) {
+	withConfig := func(update func(config *RingConfig)) RingConfig {
+		config := DefaultRingConfig()
+		update(&config)
+		return config
+	}
+	testCases := []struct {
+		name           string
+		config         RingConfig
+		expectedErrors []string
+	}{
+		{name: "default", config: DefaultRingConfig()},
+		{
+			name: "custom patterns",
+			config: withConfig(func(config *RingConfig) {
+				config.Pattern = "custom"
+				config.Doors = DoorPatterns{"garage": "triple"}
+				config.Presses = PressPatterns{"long-press": "westminster"}
+				config.Patterns = map[string]Pattern{"custom": {100 * time.Millisecond, 100 * time.Millisecond}}
+			}),
+		},
+		{
+			name:           "unknown pattern",
+			config:         withConfig(func(config *RingConfig) { config.Pattern = "klaxon" }),
+			expectedErrors: []string{"ring.pattern"},
+		},
+		{
+			name:           "unknown door pattern",
+			config:         withConfig(func(config *RingConfig) { config.Doors = DoorPatterns{"garage": "klaxon"} }),
+			expectedErrors: []string{"ring.doors.garage"},
+		},
+		{
+			name:           "hold for a press kind",
+			config:         withConfig(func(config *RingConfig) { config.Presses = PressPatterns{"long-press": patternHold} }),
+			expectedErrors: []string{"ring.presses.long-press"},
+		},
+		{
+			name:           "unknown press kind",
+			config:         withConfig(func(config *RingConfig) { config.Presses = PressPatterns{"triple-press": "ding"} }),
+			expectedErrors: []string{"ring.presses.triple-press"},
+		},
+		{
+			name:           "empty pattern",
+			config:         withConfig(func(config *RingConfig) { config.Patterns = map[string]Pattern{"empty": {}} }),
+			expectedErrors: []string{"ring.patterns.empty"},
+		},
+		{
+			name:           "zero duration",
+			config:         withConfig(func(config *RingConfig) { config.Patterns = map[string]Pattern{"zero": {100 * time.Millisecond, 0}} }),
+			expectedErrors: []string{"ring.patterns.zero"},
+		},
+		{
+			name:           "on time longer than the max on-time",
+			config:         withConfig(func(config *RingConfig) { config.Patterns = map[string]Pattern{"long": {time.Minute}} }),
+			expectedErrors: []string{"ring.patterns.long"},
+		},
+		{
+			name:           "hold is reserved",
+			config:         withConfig(func(config *RingConfig) { config.Patterns = map[string]Pattern{patternHold: {time.Second}} }),
+			expectedErrors: []string{"ring.patterns.hold"},
+		},
+		{
+			name: "invalid max on-time and cool-down",
+			config: withConfig(func(config *RingConfig) {
+				config.MaxOnTime = 0
+				config.CoolDown = -time.Second
+			}),
+			expectedErrors: []string{"ring.maxOnTime", "ring.coolDown"},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var errs configfile.Errors
+			testCase.config.Validate(&errs, "ring")
+			message := ""
+			if err := errs.Err(); err != nil {
+				message = err.Error()
+			}
+			if len(testCase.expectedErrors) == 0 && message != "" {
+				t.Errorf("expected no errors, got %s", message)
+			}
+			if len(testCase.expectedErrors) > 0 && message == "" {
+				t.Errorf("expected errors for %v, got none", testCase.expectedErrors)
+			}
+			for _, path := range testCase.expectedErrors {
+				if !strings.Contains(message, path+": ") {
+					t.Errorf("expected an error for %s, got %s", path, message)
+				}
+			}
+		})
+	}
+}
+
+func TestRingerMaxOnTimeCutoff(t *testing.T) {
+	r, relay := newTestRinger(t, RingConfig{Pattern: patternHold, MaxOnTime: 50 * time.Millisecond})
+
+	if on, err := r.press(logger, "front", ""); err != nil || !on {
+		t.Fatalf("expected the press to turn the relay on, got %v, %v", on, err)
+	}
+	// the release is lost, so the cut-off turns the relay off
+	time.Sleep(150 * time.Millisecond)
+	if on, _ := relay.state(); on {
+		t.Error("expected the relay to be turned off after the max on-time")
+	}
+	if r.isHeld("front") {
+		t.Error("expected the cut-off to forget the held button")
+	}
+}
+
+func TestRingerMaxOnTimeLimitsPatternPulses(t *testing.T) {
+	r, relay := newTestRinger(t, RingConfig{
+		Pattern:   "long",
+		Patterns:  map[string]Pattern{"long": {time.Second}},
+		MaxOnTime: 50 * time.Millisecond,
+	})
+
+	if on, err := r.press(logger, "front", ""); err != nil || !on {
+		t.Fatalf("expected the press to start the pattern, got %v, %v", on, err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if on, _ := relay.state(); on {
+		t.Error("expected the pulse to be cut off at the max on-time")
+	}
+}
+
+func TestRingerReleaseCancelsHold(t *testing.T) {
+	r, relay := newTestRinger(t, RingConfig{Pattern: patternHold, MaxOnTime: 100 * time.Millisecond})
+
+	if on, err := r.press(logger, "front", ""); err != nil || !on {
+		t.Fatalf("expected the press to turn the relay on, got %v, %v", on, err)
+	}
+	if err := r.release("front"); err != nil {
+		t.Fatalf("release returned an error: %v", err)
+	}
+	if on, _ := relay.state(); on {
+		t.Error("expected the release to turn the relay off")
+	}
+
+	// the cut-off for the first hold is cancelled, so it doesn't turn off the relay during a second hold
+	time.Sleep(60 * time.Millisecond)
+	if on, err := r.press(logger, "front", ""); err != nil || !on {
+		t.Fatalf("expected the second press to turn the relay on, got %v, %v", on, err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if on, _ := relay.state(); !on {
+		t.Error("expected the relay to stay on until the second hold's max on-time")
+	}
+}
+
+func TestRingerReleaseDoesNotCutPatternShort(t *testing.T) {
+	r, relay := newTestRinger(t, RingConfig{
+		Pattern:   "pulse",
+		Patterns:  map[string]Pattern{"pulse": {100 * time.Millisecond}},
+		MaxOnTime: time.Second,
+	})
+
+	if on, err := r.press(logger, "front", ""); err != nil || !on {
+		t.Fatalf("expected the press to start the pattern, got %v, %v", on, err)
+	}
+	if err := r.release("front"); err != nil {
+		t.Fatalf("release returned an error: %v", err)
+	}
+	if on, _ := relay.state(); !on {
+		t.Error("expected a quick release to leave the pattern playing")
+	}
+	time.Sleep(200 * time.Millisecond)
+	if on, switches := relay.state(); on || switches != 2 {
+		t.Errorf("expected the pattern to finish with the relay off after switching twice, got on=%v after %d switches", on, switches)
+	}
+}
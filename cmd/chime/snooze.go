@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stuartleeks/pi-bell/internal/pkg/configfile"
+	"github.com/stuartleeks/pi-bell/internal/pkg/events"
+	"github.com/stuartleeks/pi-bell/internal/pkg/pi"
+	"gobot.io/x/gobot/drivers/gpio"
+)
+
+// snoozeButtonPinNumber is the pin for the optional snooze button, which is wired in the same way as the bell push
+const snoozeButtonPinNumber = pi.GPIO27
+
+// SnoozeButtonConfig holds the settings for the optional snooze button on the chime
+type SnoozeButtonConfig struct {
+	// Enabled turns on the snooze button on GPIO27
+	Enabled bool `yaml:"enabled" env:"SNOOZE_BUTTON"`
+	// Duration is how long a short press snoozes the chime for
+	Duration time.Duration `yaml:"duration" env:"SNOOZE_BUTTON_DURATION"`
+	// LongPress is how long the button must be held to cancel the snooze
+	LongPress time.Duration `yaml:"longPress" env:"SNOOZE_BUTTON_LONG_PRESS"`
+}
+
+// DefaultSnoozeButtonConfig returns the default snooze button settings (with the button disabled)
+func DefaultSnoozeButtonConfig() SnoozeButtonConfig {
+	return SnoozeButtonConfig{
+		Duration:  1 * time.Hour,
+		LongPress: 1 * time.Second,
+	}
+}
+
+// Validate checks the settings, adding any problems to errs
+func (c SnoozeButtonConfig) Validate(errs *configfile.Errors, path string) {
+	if c.Duration <= 0 {
+		errs.Add(configfile.Join(path, "duration"), "must be greater than 0")
+	}
+	if c.LongPress <= 0 {
+		errs.Add(configfile.Join(path, "longPress"), "must be greater than 0")
+	}
+}
+
+// snoozeSender sends a snooze request to a bellpush (or to all bellpushes via the MQTT broker)
+type snoozeSender func(request *events.SnoozeRequest) error
+
+// setSnoozeSender registers the function that sends snooze requests over the connection for name
+func (c *chime) setSnoozeSender(name string, sender snoozeSender) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.snoozeSenders[name] = sender
+}
+
+// requestSnooze asks each connected bellpush to snooze the chime for duration (or to cancel the snooze if
+// duration is zero). The bellpushes hold the snooze state, which they send back to the chime.
+// Returns an error if the request couldn't be sent to any bellpush
+func (c *chime) requestSnooze(duration time.Duration) error {
+	c.mutex.Lock()
+	senders := map[string]snoozeSender{}
+	names := []string{}
+	for name, sender := range c.snoozeSenders {
+		if c.connected[name] {
+			senders[name] = sender
+			names = append(names, name)
+		}
+	}
+	c.mutex.Unlock()
+	sort.Strings(names)
+
+	request := events.NewSnoozeRequest(duration)
+	action := "snooze"
+	if request.IsCancel() {
+		action = "cancel"
+	}
+	requestLogger := logger.WithCorrelationID(request.ID).With("action", action)
+	if duration > 0 {
+		requestLogger = requestLogger.With("duration", duration)
+	}
+	var errs []string
+	sent := 0
+	for _, name := range names {
+		if err := senders[name](request); err != nil {
+			requestLogger.Warn("Failed to send snooze request", "bellpush", name, "err", err)
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		sent++
+	}
+	snoozeRequestsCounter.WithLabelValues(action).Inc()
+	if sent == 0 {
+		if len(errs) == 0 {
+			return fmt.Errorf("not connected to a bellpush")
+		}
+		return fmt.Errorf("failed to send snooze request: %s", strings.Join(errs, "; "))
+	}
+	requestLogger.Info("Sent snooze request", "bellpushes", strings.Join(names, ","))
+	return nil
+}
+
+// isSnoozed returns true if any bellpush has snoozed the chime, and the earliest snooze expiry
+func (c *chime) isSnoozed() (bool, time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var earliest time.Time
+	now := time.Now()
+	for _, expiry := range c.snoozes {
+		if expiry.After(now) && (earliest.IsZero() || expiry.Before(earliest)) {
+			earliest = expiry
+		}
+	}
+	return !earliest.IsZero(), earliest
+}
+
+// snoozeButton turns presses of the snooze button into snooze requests: a short press snoozes the chime
+// and a long press cancels the snooze. The long press is acted on when the button has been held for
+// the long press time rather than on release
+type snoozeButton struct {
+	config SnoozeButtonConfig
+	c      *chime
+
+	mutex sync.Mutex
+	// longPress fires when the button has been held for the long press time (nil when the button is up)
+	longPress *time.Timer
+}
+
+func newSnoozeButton(config SnoozeButtonConfig, c *chime) *snoozeButton {
+	return &snoozeButton{config: config, c: c}
+}
+
+// start handles the button on the pin via the GPIO driver
+func (s *snoozeButton) start(reader gpio.DigitalReader) (*gpio.ButtonDriver, error) {
+	button := gpio.NewButtonDriver(reader, snoozeButtonPinNumber)
+	if err := button.On(gpio.ButtonPush, func(interface{}) { s.changed(true) }); err != nil {
+		return nil, fmt.Errorf("error setting up snooze button push handler: %w", err)
+	}
+	if err := button.On(gpio.ButtonRelease, func(interface{}) { s.changed(false) }); err != nil {
+		return nil, fmt.Errorf("error setting up snooze button release handler: %w", err)
+	}
+	if err := button.Start(); err != nil {
+		return nil, fmt.Errorf("error starting snooze button driver: %w", err)
+	}
+	logger.Info("Snooze button enabled", "duration", s.config.Duration, "longPress", s.config.LongPress)
+	return button, nil
+}
+
+// changed handles the button being pressed or released
+func (s *snoozeButton) changed(pressed bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if pressed {
+		if s.longPress == nil {
+			s.longPress = time.AfterFunc(s.config.LongPress, s.longPressed)
+		}
+		return
+	}
+	if s.longPress == nil {
+		return
+	}
+	// a long press has already been handled if the timer has fired
+	if s.longPress.Stop() {
+		go s.request(s.config.Duration)
+	}
+	s.longPress = nil
+}
+
+func (s *snoozeButton) longPressed() {
+	s.request(0)
+}
+
+func (s *snoozeButton) request(duration time.Duration) {
+	defer s.c.ringer.offOnPanic()
+	if err := s.c.requestSnooze(duration); err != nil {
+		logger.Warn("Snooze button pressed but the snooze request wasn't sent", "err", err)
+		s.c.flashError()
+	}
+}
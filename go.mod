@@ -3,25 +3,40 @@ module github.com/stuartleeks/pi-bell
 go 1.19
 
 require (
+	github.com/eclipse/paho.mqtt.golang v1.4.3
 	github.com/gobuffalo/uuid v2.0.5+incompatible
-	github.com/gorilla/websocket v1.4.1
+	github.com/gorilla/websocket v1.5.0
+	github.com/grandcat/zeroconf v1.0.0
 	github.com/microsoft/ApplicationInsights-Go v0.4.4
+	github.com/prometheus/client_golang v1.17.0
+	github.com/vladimirvivien/go4vl v0.0.5
 	gobot.io/x/gobot v1.14.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	code.cloudfoundry.org/clock v0.0.0-20180518195852-02e53af36e6c // indirect
-	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cenkalti/backoff v2.2.1+incompatible // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/gofrs/uuid v3.3.0+incompatible // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
 	github.com/hashicorp/errwrap v1.0.0 // indirect
 	github.com/hashicorp/go-multierror v1.0.0 // indirect
-	github.com/pkg/errors v0.8.1 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.4 // indirect
+	github.com/miekg/dns v1.1.27 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/prometheus/client_model v0.4.1-0.20230718164431-9a2bf3000d16 // indirect
+	github.com/prometheus/common v0.44.0 // indirect
+	github.com/prometheus/procfs v0.11.1 // indirect
 	github.com/sigurn/crc8 v0.0.0-20160107002456-e55481d6f45c // indirect
 	github.com/sigurn/utils v0.0.0-20190728110027-e1fefb11a144 // indirect
 	github.com/stretchr/testify v1.5.1 // indirect
-	github.com/vladimirvivien/go4vl v0.0.5 // indirect
-	golang.org/x/sys v0.0.0-20210630005230-0f9fa26af87c // indirect
-	gopkg.in/yaml.v2 v2.2.8 // indirect
+	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550 // indirect
+	golang.org/x/net v0.10.0 // indirect
+	golang.org/x/sync v0.3.0 // indirect
+	golang.org/x/sys v0.11.0 // indirect
+	google.golang.org/protobuf v1.31.0 // indirect
 	periph.io/x/periph v3.6.2+incompatible // indirect
 )
 
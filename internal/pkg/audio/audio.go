@@ -0,0 +1,125 @@
+// Package audio decodes sound files (WAV, or OGG Vorbis using oggdec) and plays them on a Sink.
+//
+// Sinks are registered by name so that the chime config can select one: "aplay" plays on a sound card
+// using ALSA's aplay and "file" appends the raw PCM to a file so that playback can be checked without one
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sound is decoded audio
+type Sound struct {
+	SampleRate int
+	Channels   int
+	// Samples are the interleaved signed 16-bit samples
+	Samples []int16
+}
+
+// Duration returns how long the sound plays for
+func (s *Sound) Duration() time.Duration {
+	if s.SampleRate == 0 || s.Channels == 0 {
+		return 0
+	}
+	frames := len(s.Samples) / s.Channels
+	return time.Duration(frames) * time.Second / time.Duration(s.SampleRate)
+}
+
+// WithVolume returns a copy of the sound scaled to volume (as a percentage)
+func (s *Sound) WithVolume(volume int) *Sound {
+	if volume >= 100 {
+		return s
+	}
+	scaled := &Sound{SampleRate: s.SampleRate, Channels: s.Channels, Samples: make([]int16, len(s.Samples))}
+	for i, sample := range s.Samples {
+		scaled.Samples[i] = int16(int32(sample) * int32(volume) / 100)
+	}
+	return scaled
+}
+
+// PCM returns the samples as signed 16-bit little-endian PCM
+func (s *Sound) PCM() []byte {
+	pcm := make([]byte, 2*len(s.Samples))
+	for i, sample := range s.Samples {
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(sample))
+	}
+	return pcm
+}
+
+// Formats are the file extensions that Load supports
+var Formats = []string{".wav", ".ogg"}
+
+// Load decodes the sound file at path, using the extension to select the format
+func Load(path string) (*Sound, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".wav":
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		sound, err := DecodeWAV(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+		}
+		return sound, nil
+	case ".ogg":
+		return DecodeOggFile(path)
+	default:
+		return nil, fmt.Errorf("unsupported sound file %s (expected %s)", path, strings.Join(Formats, " or "))
+	}
+}
+
+// SupportedFormat returns true if Load supports the extension of path
+func SupportedFormat(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, format := range Formats {
+		if ext == format {
+			return true
+		}
+	}
+	return false
+}
+
+// SinkConfig holds the settings used to create a sink
+type SinkConfig struct {
+	// Device is the output device for the aplay sink (e.g. plughw:1,0). The default device is used if empty
+	Device string
+	// File is the path that the file sink appends PCM to
+	File string
+}
+
+// SinkFactory creates a sink
+type SinkFactory func(config SinkConfig) (Sink, error)
+
+var sinkFactories = map[string]SinkFactory{}
+
+// RegisterSink makes a sink available to NewSink under name
+func RegisterSink(name string, factory SinkFactory) {
+	sinkFactories[name] = factory
+}
+
+// NewSink creates the sink registered as name
+func NewSink(name string, config SinkConfig) (Sink, error) {
+	factory, ok := sinkFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown audio sink %q (expected %s)", name, strings.Join(SinkNames(), ", "))
+	}
+	return factory(config)
+}
+
+// SinkNames returns the names of the registered sinks
+func SinkNames() []string {
+	names := make([]string, 0, len(sinkFactories))
+	for name := range sinkFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,35 @@
+package audio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// oggDecoder is the command used to decode OGG Vorbis files to WAV (from the vorbis-tools package)
+const oggDecoder = "oggdec"
+
+// DecodeOggFile decodes an OGG Vorbis file by running oggdec to convert it to WAV
+func DecodeOggFile(path string) (*Sound, error) {
+	if _, err := exec.LookPath(oggDecoder); err != nil {
+		return nil, fmt.Errorf("decoding %s needs %s (sudo apt install vorbis-tools): %w", path, oggDecoder, err)
+	}
+	var stdout, stderr bytes.Buffer
+	command := exec.Command(oggDecoder, "--quiet", "--output", "-", path)
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && stderr.Len() > 0 {
+			return nil, fmt.Errorf("failed to decode %s: %s", path, strings.TrimSpace(stderr.String()))
+		}
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	sound, err := DecodeWAV(&stdout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return sound, nil
+}
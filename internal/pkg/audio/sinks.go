@@ -0,0 +1,99 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// SinkAplay plays sounds on a sound card using ALSA's aplay
+	SinkAplay = "aplay"
+	// SinkFile appends the raw PCM of each sound to a file (e.g. for checking playback without a sound card)
+	SinkFile = "file"
+)
+
+// Sink plays sounds
+type Sink interface {
+	// Play plays sound, returning when it has finished or ctx is done
+	Play(ctx context.Context, sound *Sound) error
+	Close() error
+}
+
+func init() {
+	RegisterSink(SinkAplay, newAplaySink)
+	RegisterSink(SinkFile, newFileSink)
+}
+
+type aplaySink struct {
+	device string
+}
+
+func newAplaySink(config SinkConfig) (Sink, error) {
+	if _, err := exec.LookPath("aplay"); err != nil {
+		return nil, fmt.Errorf("the aplay sink needs aplay (sudo apt install alsa-utils): %w", err)
+	}
+	return &aplaySink{device: config.Device}, nil
+}
+
+func (s *aplaySink) Play(ctx context.Context, sound *Sound) error {
+	args := []string{"--quiet", "--file-type", "raw", "--format", "S16_LE",
+		"--rate", strconv.Itoa(sound.SampleRate), "--channels", strconv.Itoa(sound.Channels)}
+	if s.device != "" {
+		args = append(args, "--device", s.device)
+	}
+	var stderr bytes.Buffer
+	command := exec.CommandContext(ctx, "aplay", args...)
+	command.Stdin = bytes.NewReader(sound.PCM())
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && stderr.Len() > 0 {
+			return fmt.Errorf("aplay failed: %s", strings.TrimSpace(stderr.String()))
+		}
+		return fmt.Errorf("aplay failed: %w", err)
+	}
+	return nil
+}
+
+func (s *aplaySink) Close() error {
+	return nil
+}
+
+type fileSink struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func newFileSink(config SinkConfig) (Sink, error) {
+	if config.File == "" {
+		return nil, errors.New("the file sink needs a file")
+	}
+	file, err := os.OpenFile(config.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{file: file}, nil
+}
+
+func (s *fileSink) Play(_ context.Context, sound *Sound) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, err := s.file.Write(sound.PCM())
+	return err
+}
+
+func (s *fileSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.file.Close()
+}
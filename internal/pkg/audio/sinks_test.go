@@ -0,0 +1,117 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileSinkAppendsPCM(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chime.pcm")
+	sink, err := NewSink(SinkFile, SinkConfig{File: path})
+	if err != nil {
+		t.Fatalf("NewSink returned an error: %v", err)
+	}
+
+	sound := &Sound{SampleRate: 8000, Channels: 1, Samples: []int16{1, -2, 0x1234}}
+	for i := 0; i < 2; i++ {
+		if err := sink.Play(context.Background(), sound); err != nil {
+			t.Fatalf("Play returned an error: %v", err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+
+	pcm, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{0x01, 0x00, 0xfe, 0xff, 0x34, 0x12}
+	if !bytes.Equal(pcm, append(expected, expected...)) {
+		t.Errorf("unexpected PCM % x", pcm)
+	}
+}
+
+func TestFileSinkPlaysDecodedWAV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chime.pcm")
+	sink, err := NewSink(SinkFile, SinkConfig{File: path})
+	if err != nil {
+		t.Fatalf("NewSink returned an error: %v", err)
+	}
+	defer sink.Close()
+
+	// 8-bit samples are converted to 16-bit and scaled by the volume
+	file := wavFile(
+		chunk("fmt ", formatChunk(wavFormatPCM, 1, 8000, 8)),
+		chunk("data", []byte{128, 192, 64}),
+	)
+	sound, err := DecodeWAV(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("DecodeWAV returned an error: %v", err)
+	}
+	if err := sink.Play(context.Background(), sound.WithVolume(50)); err != nil {
+		t.Fatalf("Play returned an error: %v", err)
+	}
+
+	pcm, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{0x00, 0x00, 0x00, 0x20, 0x00, 0xe0}
+	if !bytes.Equal(pcm, expected) {
+		t.Errorf("expected PCM % x, got % x", expected, pcm)
+	}
+}
+
+func TestNewSinkErrors(t *testing.T) {
+	if _, err := NewSink("speaker", SinkConfig{}); err == nil {
+		t.Error("expected an error for an unknown sink")
+	}
+	if _, err := NewSink(SinkFile, SinkConfig{}); err == nil {
+		t.Error("expected an error for a file sink without a file")
+	}
+}
+
+func TestSoundDuration(t *testing.T) {
+	sound := &Sound{SampleRate: 8000, Channels: 2, Samples: make([]int16, 16000)}
+	if duration := sound.Duration(); duration != time.Second {
+		t.Errorf("expected 1s, got %s", duration)
+	}
+	if duration := (&Sound{}).Duration(); duration != 0 {
+		t.Errorf("expected 0 for an empty sound, got %s", duration)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ding.WAV")
+	file := wavFile(chunk("fmt ", formatChunk(wavFormatPCM, 1, 8000, 16)), chunk("data", []byte{1, 0}))
+	if err := os.WriteFile(path, file, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sound, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned an error: %v", err)
+	}
+	if !equalSamples(sound.Samples, []int16{1}) {
+		t.Errorf("unexpected samples %v", sound.Samples)
+	}
+
+	if _, err := Load(filepath.Join(dir, "ding.mp3")); err == nil {
+		t.Error("expected an error for an unsupported format")
+	}
+	if SupportedFormat("ding.mp3") || !SupportedFormat("ding.ogg") {
+		t.Error("unexpected result from SupportedFormat")
+	}
+}
+
+func TestDecodeOggFileWithoutOggdec(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	if _, err := DecodeOggFile("ding.ogg"); err == nil {
+		t.Error("expected an error when oggdec isn't installed")
+	}
+}
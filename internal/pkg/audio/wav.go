@@ -0,0 +1,146 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	wavFormatPCM        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xFFFE
+
+	// maxFormatChunkSize is larger than any valid format chunk (which is 16, 18 or 40 bytes)
+	maxFormatChunkSize = 1024
+	// MaxDataSize is the largest sample data that DecodeWAV reads, so that a corrupt file can't use up the memory.
+	// This is about three minutes of 16-bit stereo at 44.1kHz
+	MaxDataSize = 32 << 20
+)
+
+// DecodeWAV decodes a RIFF WAVE stream with 8, 16, 24 or 32-bit integer or 32-bit float samples
+func DecodeWAV(r io.Reader) (*Sound, error) {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return nil, errors.New("not a WAV file")
+	}
+
+	var format, channels, bitsPerSample uint16
+	var sampleRate uint32
+	haveFormat := false
+	for {
+		var chunkHeader [8]byte
+		if _, err := io.ReadFull(r, chunkHeader[:]); err != nil {
+			return nil, fmt.Errorf("no data chunk: %w", err)
+		}
+		id := string(chunkHeader[0:4])
+		size := binary.LittleEndian.Uint32(chunkHeader[4:8])
+
+		switch id {
+		case "fmt ":
+			if size > maxFormatChunkSize {
+				return nil, fmt.Errorf("format chunk too large (%d bytes)", size)
+			}
+			chunk := make([]byte, size)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return nil, fmt.Errorf("failed to read format: %w", err)
+			}
+			if len(chunk) < 16 {
+				return nil, errors.New("format chunk too short")
+			}
+			format = binary.LittleEndian.Uint16(chunk[0:2])
+			channels = binary.LittleEndian.Uint16(chunk[2:4])
+			sampleRate = binary.LittleEndian.Uint32(chunk[4:8])
+			bitsPerSample = binary.LittleEndian.Uint16(chunk[14:16])
+			if format == wavFormatExtensible && len(chunk) >= 26 {
+				// the sub-format GUID starts with the format code
+				format = binary.LittleEndian.Uint16(chunk[24:26])
+			}
+			haveFormat = true
+			if size%2 == 1 {
+				if _, err := io.CopyN(io.Discard, r, 1); err != nil {
+					return nil, fmt.Errorf("failed to read format: %w", err)
+				}
+			}
+		case "data":
+			if !haveFormat {
+				return nil, errors.New("data chunk before format chunk")
+			}
+			if channels == 0 || sampleRate == 0 {
+				return nil, errors.New("invalid format: no channels or sample rate")
+			}
+			// streamed WAVs (e.g. from oggdec writing to stdout) may not have the data size, so read to the end
+			var data []byte
+			var err error
+			if size == 0 || size == math.MaxUint32 {
+				data, err = io.ReadAll(io.LimitReader(r, MaxDataSize+1))
+				if err == nil && len(data) > MaxDataSize {
+					return nil, fmt.Errorf("data larger than %d bytes", MaxDataSize)
+				}
+			} else {
+				if size > MaxDataSize {
+					return nil, fmt.Errorf("data chunk too large (%d bytes, the maximum is %d)", size, MaxDataSize)
+				}
+				data = make([]byte, size)
+				_, err = io.ReadFull(r, data)
+				if errors.Is(err, io.ErrUnexpectedEOF) {
+					err = nil
+				}
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read data: %w", err)
+			}
+			samples, err := decodeSamples(data, format, bitsPerSample)
+			if err != nil {
+				return nil, err
+			}
+			return &Sound{SampleRate: int(sampleRate), Channels: int(channels), Samples: samples}, nil
+		default:
+			// skip other chunks (e.g. LIST metadata), which are padded to an even size
+			if _, err := io.CopyN(io.Discard, r, int64(size)+int64(size%2)); err != nil {
+				return nil, fmt.Errorf("failed to skip %q chunk: %w", id, err)
+			}
+		}
+	}
+}
+
+// decodeSamples converts the sample data to signed 16-bit samples
+func decodeSamples(data []byte, format uint16, bitsPerSample uint16) ([]int16, error) {
+	bytesPerSample := int(bitsPerSample) / 8
+	if bytesPerSample == 0 {
+		return nil, fmt.Errorf("unsupported bits per sample: %d", bitsPerSample)
+	}
+	samples := make([]int16, len(data)/bytesPerSample)
+	switch {
+	case format == wavFormatPCM && bitsPerSample == 8:
+		// 8-bit samples are unsigned
+		for i := range samples {
+			samples[i] = int16(int(data[i])-128) << 8
+		}
+	case format == wavFormatPCM && bitsPerSample == 16:
+		for i := range samples {
+			samples[i] = int16(binary.LittleEndian.Uint16(data[2*i:]))
+		}
+	case format == wavFormatPCM && bitsPerSample == 24:
+		for i := range samples {
+			samples[i] = int16(uint16(data[3*i+1]) | uint16(data[3*i+2])<<8)
+		}
+	case format == wavFormatPCM && bitsPerSample == 32:
+		for i := range samples {
+			samples[i] = int16(binary.LittleEndian.Uint32(data[4*i:]) >> 16)
+		}
+	case format == wavFormatFloat && bitsPerSample == 32:
+		for i := range samples {
+			value := math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+			samples[i] = int16(math.Max(-1, math.Min(1, float64(value))) * math.MaxInt16)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported WAV format %d with %d bits per sample", format, bitsPerSample)
+	}
+	return samples, nil
+}
@@ -0,0 +1,199 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+)
+
+// chunk returns a RIFF chunk with id and data, padded to an even size
+func chunk(id string, data []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(id)
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(len(data)))
+	buf.Write(data)
+	if len(data)%2 == 1 {
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+// formatChunk returns the data for a format chunk
+func formatChunk(format uint16, channels uint16, sampleRate uint32, bitsPerSample uint16) []byte {
+	var buf bytes.Buffer
+	blockAlign := channels * bitsPerSample / 8
+	for _, value := range []any{format, channels, sampleRate, sampleRate * uint32(blockAlign), blockAlign, bitsPerSample} {
+		_ = binary.Write(&buf, binary.LittleEndian, value)
+	}
+	return buf.Bytes()
+}
+
+// wavFile returns a WAV file with the chunks
+func wavFile(chunks ...[]byte) []byte {
+	body := bytes.Join(chunks, nil)
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(4+len(body)))
+	buf.WriteString("WAVE")
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+func TestDecodeWAVFormats(t *testing.T) {
+	float32Bytes := func(values ...float32) []byte {
+		var buf bytes.Buffer
+		for _, value := range values {
+			_ = binary.Write(&buf, binary.LittleEndian, math.Float32bits(value))
+		}
+		return buf.Bytes()
+	}
+	tests := []struct {
+		name          string
+		format        uint16
+		bitsPerSample uint16
+		data          []byte
+		expected      []int16
+	}{
+		{"8-bit", wavFormatPCM, 8, []byte{0, 128, 255}, []int16{-32768, 0, 32512}},
+		{"16-bit", wavFormatPCM, 16, []byte{0x00, 0x80, 0x00, 0x00, 0xff, 0x7f}, []int16{-32768, 0, 32767}},
+		{"24-bit", wavFormatPCM, 24, []byte{0xff, 0x00, 0x80, 0xff, 0xff, 0x7f}, []int16{-32768, 32767}},
+		{"32-bit", wavFormatPCM, 32, []byte{0x00, 0x00, 0x00, 0x80, 0xff, 0xff, 0xff, 0x7f}, []int16{-32768, 32767}},
+		{"float", wavFormatFloat, 32, float32Bytes(-1, 0, 2), []int16{-32767, 0, 32767}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := wavFile(
+				chunk("fmt ", formatChunk(test.format, 1, 8000, test.bitsPerSample)),
+				chunk("data", test.data),
+			)
+			sound, err := DecodeWAV(bytes.NewReader(file))
+			if err != nil {
+				t.Fatalf("DecodeWAV returned an error: %v", err)
+			}
+			if sound.SampleRate != 8000 || sound.Channels != 1 {
+				t.Errorf("expected 8000Hz mono, got %dHz with %d channels", sound.SampleRate, sound.Channels)
+			}
+			if !equalSamples(sound.Samples, test.expected) {
+				t.Errorf("expected samples %v, got %v", test.expected, sound.Samples)
+			}
+		})
+	}
+}
+
+func TestDecodeWAVExtensibleFormat(t *testing.T) {
+	format := formatChunk(wavFormatExtensible, 2, 44100, 16)
+	// extension size, valid bits, channel mask and the sub-format GUID (which starts with the format code)
+	extension := make([]byte, 24)
+	binary.LittleEndian.PutUint16(extension[0:2], 22)
+	binary.LittleEndian.PutUint16(extension[8:10], wavFormatPCM)
+	file := wavFile(
+		chunk("fmt ", append(format, extension...)),
+		chunk("data", []byte{0x01, 0x00, 0x02, 0x00}),
+	)
+	sound, err := DecodeWAV(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("DecodeWAV returned an error: %v", err)
+	}
+	if sound.Channels != 2 || !equalSamples(sound.Samples, []int16{1, 2}) {
+		t.Errorf("unexpected sound: %d channels, samples %v", sound.Channels, sound.Samples)
+	}
+}
+
+func TestDecodeWAVSkipsOtherChunks(t *testing.T) {
+	file := wavFile(
+		chunk("LIST", []byte("odd")),
+		chunk("fmt ", formatChunk(wavFormatPCM, 1, 8000, 16)),
+		chunk("fact", []byte{1, 2, 3, 4}),
+		chunk("data", []byte{0x05, 0x00}),
+	)
+	sound, err := DecodeWAV(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("DecodeWAV returned an error: %v", err)
+	}
+	if !equalSamples(sound.Samples, []int16{5}) {
+		t.Errorf("unexpected samples %v", sound.Samples)
+	}
+}
+
+func TestDecodeWAVStreamedDataSize(t *testing.T) {
+	// oggdec writing to stdout doesn't know the data size, so it is 0 or 0xFFFFFFFF
+	for _, size := range []uint32{0, math.MaxUint32} {
+		data := make([]byte, 8)
+		binary.LittleEndian.PutUint32(data[4:8], size)
+		copy(data, "data")
+		file := wavFile(chunk("fmt ", formatChunk(wavFormatPCM, 1, 8000, 16)))
+		file = append(file, data...)
+		file = append(file, 0x01, 0x00, 0x02, 0x00)
+		sound, err := DecodeWAV(bytes.NewReader(file))
+		if err != nil {
+			t.Fatalf("size %d: DecodeWAV returned an error: %v", size, err)
+		}
+		if !equalSamples(sound.Samples, []int16{1, 2}) {
+			t.Errorf("size %d: unexpected samples %v", size, sound.Samples)
+		}
+	}
+}
+
+func TestDecodeWAVErrors(t *testing.T) {
+	hugeChunk := func(id string) []byte {
+		header := make([]byte, 8)
+		copy(header, id)
+		binary.LittleEndian.PutUint32(header[4:8], math.MaxUint32-1)
+		return header
+	}
+	tests := []struct {
+		name     string
+		file     []byte
+		expected string
+	}{
+		{"empty", nil, "failed to read header"},
+		{"not a WAV", []byte("RIFF\x00\x00\x00\x00AVI "), "not a WAV file"},
+		{"no data chunk", wavFile(chunk("fmt ", formatChunk(wavFormatPCM, 1, 8000, 16))), "no data chunk"},
+		{"data before format", wavFile(chunk("data", []byte{0, 0})), "data chunk before format chunk"},
+		{"short format", wavFile(chunk("fmt ", []byte{1, 0}), chunk("data", []byte{0, 0})), "format chunk too short"},
+		{"no channels", wavFile(chunk("fmt ", formatChunk(wavFormatPCM, 0, 8000, 16)), chunk("data", []byte{0, 0})), "no channels"},
+		{"unsupported format", wavFile(chunk("fmt ", formatChunk(2, 1, 8000, 4)), chunk("data", []byte{0, 0})), "unsupported"},
+		{"huge format chunk", wavFile(hugeChunk("fmt ")), "format chunk too large"},
+		{"huge data chunk", wavFile(chunk("fmt ", formatChunk(wavFormatPCM, 1, 8000, 16)), hugeChunk("data")), "data chunk too large"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DecodeWAV(bytes.NewReader(test.file))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), test.expected) {
+				t.Errorf("expected an error containing %q, got %q", test.expected, err)
+			}
+		})
+	}
+}
+
+func FuzzDecodeWAV(f *testing.F) {
+	f.Add(wavFile(chunk("fmt ", formatChunk(wavFormatPCM, 1, 8000, 16)), chunk("data", []byte{1, 0, 2, 0})))
+	f.Add(wavFile(chunk("fmt ", formatChunk(wavFormatPCM, 2, 44100, 24)), chunk("data", []byte{1, 2, 3, 4, 5, 6})))
+	f.Add(wavFile(chunk("fmt ", formatChunk(wavFormatFloat, 1, 8000, 32)), chunk("data", []byte{0, 0, 128, 63})))
+	f.Fuzz(func(t *testing.T, file []byte) {
+		sound, err := DecodeWAV(bytes.NewReader(file))
+		if err != nil {
+			return
+		}
+		if sound.Channels <= 0 || sound.SampleRate <= 0 {
+			t.Errorf("decoded a sound with %d channels at %dHz", sound.Channels, sound.SampleRate)
+		}
+	})
+}
+
+func equalSamples(a []int16, b []int16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
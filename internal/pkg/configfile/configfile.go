@@ -0,0 +1,179 @@
+// Package configfile loads the YAML configuration files for the bellpush and chime.
+//
+// Settings are applied in order of precedence: defaults, then the config file, then environment
+// variables (named by `env` struct tags), then command line flags (handled by the caller).
+// This means that the existing .env files continue to work alongside a config file.
+package configfile
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Load reads the YAML file at path into config, which should already contain the defaults.
+// Keys that don't match a setting are reported as errors to catch typos
+func Load(path string, config any) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// ApplyEnv overrides settings in config (a pointer to a struct) from the environment variables
+// named by `env` struct tags. Nested structs can add a prefix for their fields with an `envPrefix` tag.
+// Empty environment variables are ignored so that blank entries in .env files don't override the config file
+func ApplyEnv(config any) error {
+	value := reflect.ValueOf(config)
+	if value.Kind() != reflect.Pointer || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config must be a pointer to a struct, got %T", config)
+	}
+	return applyEnv(value.Elem(), "")
+}
+
+func applyEnv(value reflect.Value, prefix string) error {
+	valueType := value.Type()
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fieldValue := value.Field(i)
+		if name, ok := field.Tag.Lookup("env"); ok {
+			envName := prefix + name
+			envValue := os.Getenv(envName)
+			if envValue == "" {
+				continue
+			}
+			if err := setValue(fieldValue, envValue); err != nil {
+				return fmt.Errorf("invalid %s: %w", envName, err)
+			}
+			continue
+		}
+		if fieldValue.Kind() == reflect.Struct {
+			if err := applyEnv(fieldValue, prefix+field.Tag.Get("envPrefix")); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setValue(value reflect.Value, s string) error {
+	if value.Kind() == reflect.Pointer {
+		// optional settings are pointers so that they can be told apart from the zero value
+		if value.IsNil() {
+			value.Set(reflect.New(value.Type().Elem()))
+		}
+		return setValue(value.Elem(), s)
+	}
+	if unmarshaler, ok := value.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(s))
+	}
+	if value.Type() == durationType {
+		duration, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(duration))
+		return nil
+	}
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", s)
+		}
+		value.SetBool(b)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("expected a whole number, got %q", s)
+		}
+		value.SetInt(i)
+	default:
+		return fmt.Errorf("unsupported setting type %s", value.Type())
+	}
+	return nil
+}
+
+// Errors collects validation problems so that they can all be reported at once
+type Errors struct {
+	problems []string
+}
+
+// Add records a problem with the setting at path (e.g. "notifications.smtp.from")
+func (e *Errors) Add(path string, format string, a ...any) {
+	e.problems = append(e.problems, fmt.Sprintf("%s: %s", path, fmt.Sprintf(format, a...)))
+}
+
+// Err returns an error listing the problems, or nil if there are none
+func (e *Errors) Err() error {
+	if len(e.problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: e.problems}
+}
+
+// ValidationError lists the problems found when validating a config
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Join returns a path for a nested setting, e.g. Join("notifications", "smtp") returns "notifications.smtp"
+func Join(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// ChangedSettings compares two configs of the same struct type and returns the names (from the yaml tags)
+// of the top-level settings that differ, excluding those listed in ignore.
+// This is used on reload to report settings that only take effect after a restart
+func ChangedSettings(previous any, current any, ignore ...string) []string {
+	previousValue := reflect.Indirect(reflect.ValueOf(previous))
+	currentValue := reflect.Indirect(reflect.ValueOf(current))
+	if previousValue.Type() != currentValue.Type() || previousValue.Kind() != reflect.Struct {
+		return nil
+	}
+	ignored := make(map[string]bool, len(ignore))
+	for _, name := range ignore {
+		ignored[name] = true
+	}
+	changed := []string{}
+	for i := 0; i < previousValue.NumField(); i++ {
+		field := previousValue.Type().Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if !field.IsExported() || name == "" || name == "-" || ignored[name] {
+			continue
+		}
+		if !reflect.DeepEqual(previousValue.Field(i).Interface(), currentValue.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
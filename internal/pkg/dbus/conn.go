@@ -0,0 +1,413 @@
+// Package dbus is a minimal D-Bus client: enough to call methods and receive signals on the session bus
+// (e.g. for desktop notifications) without depending on a D-Bus library.
+//
+// Only the unix socket transport and EXTERNAL authentication are supported
+package dbus
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	typeMethodCall   = 1
+	typeMethodReturn = 2
+	typeError        = 3
+	typeSignal       = 4
+
+	fieldPath        = 1
+	fieldInterface   = 2
+	fieldMember      = 3
+	fieldErrorName   = 4
+	fieldReplySerial = 5
+	fieldDestination = 6
+	fieldSender      = 7
+	fieldSignature   = 8
+
+	// maxMessageSize is the largest message that the specification allows
+	maxMessageSize = 128 * 1024 * 1024
+)
+
+// CallTimeout is how long Call waits for a reply
+var CallTimeout = 10 * time.Second
+
+// Signal is a signal received from the bus
+type Signal struct {
+	Sender    string
+	Path      ObjectPath
+	Interface string
+	Member    string
+	Body      []interface{}
+}
+
+// Error is an error reply to a method call
+type Error struct {
+	Name    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Name
+	}
+	return fmt.Sprintf("%s: %s", e.Name, e.Message)
+}
+
+type reply struct {
+	body []interface{}
+	err  error
+}
+
+// Conn is a connection to a message bus
+type Conn struct {
+	conn net.Conn
+	// name is the unique name that the bus assigned to the connection
+	name string
+
+	writeLock sync.Mutex
+	mutex     sync.Mutex
+	serial    uint32
+	pending   map[uint32]chan reply
+	handlers  []func(*Signal)
+	closed    chan struct{}
+	err       error
+}
+
+// SessionBusAddress returns the address of the session bus from DBUS_SESSION_BUS_ADDRESS, falling back to
+// the user's bus in XDG_RUNTIME_DIR
+func SessionBusAddress() string {
+	if address := os.Getenv("DBUS_SESSION_BUS_ADDRESS"); address != "" {
+		return address
+	}
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = fmt.Sprintf("/run/user/%d", os.Getuid())
+	}
+	return "unix:path=" + runtimeDir + "/bus"
+}
+
+// ConnectSessionBus connects to the session bus
+func ConnectSessionBus() (*Conn, error) {
+	return Connect(SessionBusAddress())
+}
+
+// Connect connects to the bus at address (e.g. unix:path=/run/user/1000/bus), authenticates and registers
+// with the bus. Addresses separated by ; are tried in order
+func Connect(address string) (*Conn, error) {
+	var errs []string
+	for _, entry := range strings.Split(address, ";") {
+		if entry == "" {
+			continue
+		}
+		netConn, err := dial(entry)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		c, err := newConn(netConn)
+		if err != nil {
+			netConn.Close()
+			errs = append(errs, err.Error())
+			continue
+		}
+		return c, nil
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("dbus: no bus address")
+	}
+	return nil, fmt.Errorf("dbus: failed to connect: %s", strings.Join(errs, "; "))
+}
+
+// dial opens the socket for a single bus address
+func dial(address string) (net.Conn, error) {
+	transport, params, ok := strings.Cut(address, ":")
+	if !ok || transport != "unix" {
+		return nil, fmt.Errorf("unsupported bus address %q", address)
+	}
+	for _, param := range strings.Split(params, ",") {
+		key, value, _ := strings.Cut(param, "=")
+		switch key {
+		case "path":
+			return net.Dial("unix", unescape(value))
+		case "abstract":
+			return net.Dial("unix", "@"+unescape(value))
+		}
+	}
+	return nil, fmt.Errorf("no path in bus address %q", address)
+}
+
+// unescape decodes the %xx escapes in a bus address value
+func unescape(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '%' && i+3 <= len(value) {
+			if decoded, err := hex.DecodeString(value[i+1 : i+3]); err == nil {
+				b.Write(decoded)
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
+
+func newConn(netConn net.Conn) (*Conn, error) {
+	reader := bufio.NewReader(netConn)
+	if err := authenticate(netConn, reader); err != nil {
+		return nil, err
+	}
+	c := &Conn{
+		conn:    netConn,
+		pending: map[uint32]chan reply{},
+		closed:  make(chan struct{}),
+	}
+	go c.readMessages(reader)
+
+	body, err := c.Call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Hello", "")
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("hello failed: %w", err)
+	}
+	if len(body) > 0 {
+		c.name, _ = body[0].(string)
+	}
+	return c, nil
+}
+
+// authenticate runs the EXTERNAL authentication handshake, which identifies the client by its uid
+func authenticate(w io.Writer, r *bufio.Reader) error {
+	if err := netConnDeadline(w, time.Now().Add(CallTimeout)); err != nil {
+		return err
+	}
+	defer netConnDeadline(w, time.Time{}) // nolint:errcheck
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := fmt.Fprintf(w, "\x00AUTH EXTERNAL %s\r\n", uid); err != nil {
+		return err
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("authentication failed: %w", err)
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return fmt.Errorf("authentication rejected: %s", strings.TrimSpace(line))
+	}
+	_, err = io.WriteString(w, "BEGIN\r\n")
+	return err
+}
+
+func netConnDeadline(w io.Writer, deadline time.Time) error {
+	if conn, ok := w.(net.Conn); ok {
+		return conn.SetDeadline(deadline)
+	}
+	return nil
+}
+
+// Name returns the unique name that the bus assigned to the connection
+func (c *Conn) Name() string {
+	return c.name
+}
+
+// Done is closed when the connection closes
+func (c *Conn) Done() <-chan struct{} {
+	return c.closed
+}
+
+// Err returns the error that closed the connection
+func (c *Conn) Err() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.err
+}
+
+// Close closes the connection
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// OnSignal registers handler to be called (on the connection's read goroutine) for each signal received.
+// Use AddMatch to receive signals that aren't sent to this connection
+func (c *Conn) OnSignal(handler func(*Signal)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.handlers = append(c.handlers, handler)
+}
+
+// AddMatch asks the bus to send the signals that match rule, e.g. type='signal',interface='org.example.Foo'
+func (c *Conn) AddMatch(rule string) error {
+	_, err := c.Call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "AddMatch", "s", rule)
+	return err
+}
+
+// Call calls a method and waits (up to CallTimeout) for the reply, returning the reply's values.
+// signature describes args, e.g. "su" for a string and a uint32
+func (c *Conn) Call(destination string, path ObjectPath, iface string, member string, signature string, args ...interface{}) ([]interface{}, error) {
+	replies := make(chan reply, 1)
+	c.mutex.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mutex.Unlock()
+		return nil, err
+	}
+	c.serial++
+	serial := c.serial
+	c.pending[serial] = replies
+	c.mutex.Unlock()
+	defer func() {
+		c.mutex.Lock()
+		delete(c.pending, serial)
+		c.mutex.Unlock()
+	}()
+
+	fields := []interface{}{
+		[]interface{}{byte(fieldPath), MakeVariant(path)},
+		[]interface{}{byte(fieldInterface), MakeVariant(iface)},
+		[]interface{}{byte(fieldMember), MakeVariant(member)},
+		[]interface{}{byte(fieldDestination), MakeVariant(destination)},
+	}
+	if signature != "" {
+		fields = append(fields, []interface{}{byte(fieldSignature), Variant{Signature: "g", Value: Signature(signature)}})
+	}
+	message, err := encodeMessage(typeMethodCall, serial, fields, signature, args)
+	if err != nil {
+		return nil, err
+	}
+	c.writeLock.Lock()
+	_, err = c.conn.Write(message)
+	c.writeLock.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("dbus: failed to send %s.%s: %w", iface, member, err)
+	}
+
+	timer := time.NewTimer(CallTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-replies:
+		return r.body, r.err
+	case <-c.closed:
+		return nil, c.Err()
+	case <-timer.C:
+		return nil, fmt.Errorf("dbus: timed out waiting for reply to %s.%s", iface, member)
+	}
+}
+
+// encodeMessage builds a message with the header fields and body
+func encodeMessage(messageType byte, serial uint32, fields []interface{}, signature string, args []interface{}) ([]byte, error) {
+	body := &encoder{}
+	if err := body.encode(signature, args...); err != nil {
+		return nil, err
+	}
+	header := &encoder{data: []byte{'l', messageType, 0, 1}}
+	header.uint32(uint32(len(body.data)))
+	header.uint32(serial)
+	if err := header.encode("a(yv)", fields); err != nil {
+		return nil, err
+	}
+	header.align(8)
+	return append(header.data, body.data...), nil
+}
+
+// readMessages reads messages until the connection fails, delivering replies and signals
+func (c *Conn) readMessages(r *bufio.Reader) {
+	var err error
+	for {
+		var messageType byte
+		var fields map[byte]interface{}
+		var body []interface{}
+		messageType, fields, body, err = readMessage(r)
+		if err != nil {
+			break
+		}
+		switch messageType {
+		case typeMethodReturn, typeError:
+			replySerial, _ := fields[fieldReplySerial].(uint32)
+			c.mutex.Lock()
+			replies, ok := c.pending[replySerial]
+			c.mutex.Unlock()
+			if !ok {
+				continue
+			}
+			result := reply{body: body}
+			if messageType == typeError {
+				callErr := &Error{}
+				callErr.Name, _ = fields[fieldErrorName].(string)
+				if len(body) > 0 {
+					callErr.Message, _ = body[0].(string)
+				}
+				result.err = callErr
+			}
+			replies <- result
+		case typeSignal:
+			signal := &Signal{Body: body}
+			signal.Sender, _ = fields[fieldSender].(string)
+			signal.Path, _ = fields[fieldPath].(ObjectPath)
+			signal.Interface, _ = fields[fieldInterface].(string)
+			signal.Member, _ = fields[fieldMember].(string)
+			c.mutex.Lock()
+			handlers := c.handlers
+			c.mutex.Unlock()
+			for _, handler := range handlers {
+				handler(signal)
+			}
+		}
+	}
+	if errors.Is(err, net.ErrClosed) {
+		err = errors.New("dbus: connection closed")
+	}
+	c.mutex.Lock()
+	c.err = err
+	c.mutex.Unlock()
+	close(c.closed)
+}
+
+// readMessage reads a message, returning its type, header fields and body
+func readMessage(r io.Reader) (byte, map[byte]interface{}, []interface{}, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return 0, nil, nil, err
+	}
+	if fixed[0] != 'l' {
+		return 0, nil, nil, errors.New("dbus: big-endian messages are not supported")
+	}
+	bodyLength := binary.LittleEndian.Uint32(fixed[4:8])
+	fieldsLength := binary.LittleEndian.Uint32(fixed[12:16])
+	headerLength := 16 + int(fieldsLength)
+	headerLength += (8 - headerLength%8) % 8
+	if headerLength+int(bodyLength) > maxMessageSize {
+		return 0, nil, nil, errors.New("dbus: message too large")
+	}
+	data := make([]byte, headerLength+int(bodyLength))
+	copy(data, fixed[:])
+	if _, err := io.ReadFull(r, data[16:]); err != nil {
+		return 0, nil, nil, err
+	}
+
+	d := &decoder{data: data, offset: 12}
+	values, err := d.decode("a(yv)")
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	fields := map[byte]interface{}{}
+	for _, item := range values[0].([]interface{}) {
+		field := item.([]interface{})
+		fields[field[0].(byte)] = field[1].(Variant).Value
+	}
+	signature, _ := fields[fieldSignature].(Signature)
+	d = &decoder{data: data[:headerLength+int(bodyLength)], offset: headerLength}
+	// alignment in the body is relative to the start of the body, which is 8-byte aligned
+	body, err := d.decode(string(signature))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return fixed[1], fields, body, nil
+}
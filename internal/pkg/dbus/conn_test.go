@@ -0,0 +1,141 @@
+package dbus
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestUnescape(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"/run/user/1000/bus", "/run/user/1000/bus"},
+		{"/tmp/dbus%2dtest", "/tmp/dbus-test"},
+		{"/tmp/dbus%2d", "/tmp/dbus-"},
+		{"%2f", "/"},
+		{"/tmp/100%", "/tmp/100%"},
+		{"/tmp/100%2", "/tmp/100%2"},
+		{"/tmp/%zz", "/tmp/%zz"},
+	}
+	for _, test := range tests {
+		if actual := unescape(test.value); actual != test.expected {
+			t.Errorf("unescape(%q): expected %q, got %q", test.value, test.expected, actual)
+		}
+	}
+}
+
+func TestDialUnsupportedAddress(t *testing.T) {
+	for _, address := range []string{"tcp:host=localhost,port=1234", "unix:guid=1234", "nonsense"} {
+		if _, err := dial(address); err == nil {
+			t.Errorf("%q: expected an error", address)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	var written bytes.Buffer
+	err := authenticate(&written, bufio.NewReader(strings.NewReader("OK 1234deadbeef\r\n")))
+	if err != nil {
+		t.Fatalf("authenticate returned an error: %v", err)
+	}
+	if !strings.HasPrefix(written.String(), "\x00AUTH EXTERNAL ") || !strings.HasSuffix(written.String(), "\r\nBEGIN\r\n") {
+		t.Errorf("unexpected handshake %q", written.String())
+	}
+
+	err = authenticate(&bytes.Buffer{}, bufio.NewReader(strings.NewReader("REJECTED EXTERNAL\r\n")))
+	if err == nil || !strings.Contains(err.Error(), "REJECTED") {
+		t.Errorf("expected a rejected error, got %v", err)
+	}
+}
+
+// fakeBus accepts a connection on server, replying to each method call with the result of reply
+func fakeBus(t *testing.T, server net.Conn, reply func(member string, body []interface{}) (byte, []interface{}, string, []interface{})) {
+	t.Helper()
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		if _, err := r.ReadString('\n'); err != nil {
+			return
+		}
+		if _, err := server.Write([]byte("OK 1234\r\n")); err != nil {
+			return
+		}
+		if _, err := r.ReadString('\n'); err != nil {
+			return
+		}
+		for {
+			header, err := r.Peek(12)
+			if err != nil {
+				return
+			}
+			serial := binary.LittleEndian.Uint32(header[8:12])
+			_, fields, body, err := readMessage(r)
+			if err != nil {
+				return
+			}
+			member, _ := fields[fieldMember].(string)
+			messageType, extraFields, signature, replyBody := reply(member, body)
+			replyFields := append([]interface{}{[]interface{}{byte(fieldReplySerial), MakeVariant(serial)}}, extraFields...)
+			if signature != "" {
+				replyFields = append(replyFields, []interface{}{byte(fieldSignature), Variant{Signature: "g", Value: Signature(signature)}})
+			}
+			message, err := encodeMessage(messageType, serial+100, replyFields, signature, replyBody)
+			if err != nil {
+				t.Errorf("failed to encode reply: %v", err)
+				return
+			}
+			if _, err := server.Write(message); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func TestConnCall(t *testing.T) {
+	client, server := net.Pipe()
+	fakeBus(t, server, func(member string, body []interface{}) (byte, []interface{}, string, []interface{}) {
+		switch member {
+		case "Hello":
+			return typeMethodReturn, nil, "s", []interface{}{":1.42"}
+		case "Notify":
+			return typeMethodReturn, nil, "u", []interface{}{uint32(len(body))}
+		default:
+			return typeError, []interface{}{[]interface{}{byte(fieldErrorName), MakeVariant("org.freedesktop.DBus.Error.UnknownMethod")}}, "s", []interface{}{"no " + member}
+		}
+	})
+
+	conn, err := newConn(client)
+	if err != nil {
+		t.Fatalf("newConn returned an error: %v", err)
+	}
+	defer conn.Close()
+	if conn.Name() != ":1.42" {
+		t.Errorf("expected the name :1.42, got %q", conn.Name())
+	}
+
+	reply, err := conn.Call("org.freedesktop.Notifications", "/org/freedesktop/Notifications", "org.freedesktop.Notifications", "Notify", "su", "a", uint32(1))
+	if err != nil {
+		t.Fatalf("Call returned an error: %v", err)
+	}
+	if len(reply) != 1 || reply[0] != uint32(2) {
+		t.Errorf("unexpected reply %#v", reply)
+	}
+
+	_, err = conn.Call("org.freedesktop.Notifications", "/org/freedesktop/Notifications", "org.freedesktop.Notifications", "Missing", "")
+	var callErr *Error
+	if !errors.As(err, &callErr) || callErr.Name != "org.freedesktop.DBus.Error.UnknownMethod" || callErr.Message != "no Missing" {
+		t.Errorf("expected an UnknownMethod error, got %v", err)
+	}
+
+	conn.Close()
+	<-conn.Done()
+	if _, err := conn.Call("org.freedesktop.Notifications", "/", "org.freedesktop.Notifications", "Notify", ""); err == nil {
+		t.Error("expected an error calling on a closed connection")
+	}
+}
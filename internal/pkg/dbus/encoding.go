@@ -0,0 +1,476 @@
+package dbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ObjectPath is a D-Bus object path (type code o)
+type ObjectPath string
+
+// Signature is a D-Bus type signature (type code g)
+type Signature string
+
+// Variant is a value together with its signature (type code v)
+type Variant struct {
+	Signature Signature
+	Value     interface{}
+}
+
+// MakeVariant returns a variant for value, which must be a string, bool, byte, int32, uint32, int64, uint64,
+// float64, ObjectPath or []string
+func MakeVariant(value interface{}) Variant {
+	var signature Signature
+	switch value.(type) {
+	case string:
+		signature = "s"
+	case bool:
+		signature = "b"
+	case byte:
+		signature = "y"
+	case int32:
+		signature = "i"
+	case uint32:
+		signature = "u"
+	case int64:
+		signature = "x"
+	case uint64:
+		signature = "t"
+	case float64:
+		signature = "d"
+	case ObjectPath:
+		signature = "o"
+	case []string:
+		signature = "as"
+	default:
+		panic(fmt.Sprintf("dbus: no variant signature for %T", value))
+	}
+	return Variant{Signature: signature, Value: value}
+}
+
+// splitSignature splits a signature into its complete types, e.g. "sa{sv}i" into "s", "a{sv}" and "i"
+func splitSignature(signature string) ([]string, error) {
+	types := []string{}
+	for len(signature) > 0 {
+		length, err := completeTypeLength(signature)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, signature[:length])
+		signature = signature[length:]
+	}
+	return types, nil
+}
+
+// completeTypeLength returns the length of the complete type at the start of signature
+func completeTypeLength(signature string) (int, error) {
+	if signature == "" {
+		return 0, errors.New("dbus: empty signature")
+	}
+	switch signature[0] {
+	case 'y', 'b', 'n', 'q', 'i', 'u', 'x', 't', 'd', 's', 'o', 'g', 'v', 'h':
+		return 1, nil
+	case 'a':
+		length, err := completeTypeLength(signature[1:])
+		return 1 + length, err
+	case '(', '{':
+		closing := byte(')')
+		if signature[0] == '{' {
+			closing = '}'
+		}
+		length := 1
+		for {
+			if length >= len(signature) {
+				return 0, fmt.Errorf("dbus: unterminated signature %q", signature)
+			}
+			if signature[length] == closing {
+				return length + 1, nil
+			}
+			memberLength, err := completeTypeLength(signature[length:])
+			if err != nil {
+				return 0, err
+			}
+			length += memberLength
+		}
+	default:
+		return 0, fmt.Errorf("dbus: unsupported type %q in signature", signature[0])
+	}
+}
+
+// alignment returns the alignment of the type that starts signature
+func alignment(signature string) int {
+	switch signature[0] {
+	case 'n', 'q':
+		return 2
+	case 'b', 'i', 'u', 's', 'o', 'a', 'h':
+		return 4
+	case 'x', 't', 'd', '(', '{':
+		return 8
+	default:
+		return 1
+	}
+}
+
+// encoder writes values in the little-endian wire format. Alignment is relative to the start of the message
+type encoder struct {
+	data []byte
+}
+
+func (e *encoder) align(n int) {
+	for len(e.data)%n != 0 {
+		e.data = append(e.data, 0)
+	}
+}
+
+func (e *encoder) uint32(value uint32) {
+	e.align(4)
+	e.data = binary.LittleEndian.AppendUint32(e.data, value)
+}
+
+func (e *encoder) string(value string) {
+	e.uint32(uint32(len(value)))
+	e.data = append(e.data, value...)
+	e.data = append(e.data, 0)
+}
+
+func (e *encoder) signature(value string) {
+	e.data = append(e.data, byte(len(value)))
+	e.data = append(e.data, value...)
+	e.data = append(e.data, 0)
+}
+
+// encode writes the values for signature, which must contain one complete type per value
+func (e *encoder) encode(signature string, values ...interface{}) error {
+	types, err := splitSignature(signature)
+	if err != nil {
+		return err
+	}
+	if len(types) != len(values) {
+		return fmt.Errorf("dbus: signature %q has %d types but %d values were given", signature, len(types), len(values))
+	}
+	for i, value := range values {
+		if err := e.value(types[i], value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) value(signature string, value interface{}) error {
+	mismatch := fmt.Errorf("dbus: cannot encode %T as %q", value, signature)
+	switch signature[0] {
+	case 'y':
+		v, ok := value.(byte)
+		if !ok {
+			return mismatch
+		}
+		e.data = append(e.data, v)
+	case 'b':
+		v, ok := value.(bool)
+		if !ok {
+			return mismatch
+		}
+		var b uint32
+		if v {
+			b = 1
+		}
+		e.uint32(b)
+	case 'i':
+		v, ok := value.(int32)
+		if !ok {
+			return mismatch
+		}
+		e.uint32(uint32(v))
+	case 'u':
+		v, ok := value.(uint32)
+		if !ok {
+			return mismatch
+		}
+		e.uint32(v)
+	case 'x', 't', 'd':
+		var v uint64
+		switch typed := value.(type) {
+		case int64:
+			v = uint64(typed)
+		case uint64:
+			v = typed
+		case float64:
+			v = math.Float64bits(typed)
+		default:
+			return mismatch
+		}
+		e.align(8)
+		e.data = binary.LittleEndian.AppendUint64(e.data, v)
+	case 's':
+		v, ok := value.(string)
+		if !ok {
+			return mismatch
+		}
+		e.string(v)
+	case 'o':
+		v, ok := value.(ObjectPath)
+		if !ok {
+			return mismatch
+		}
+		e.string(string(v))
+	case 'g':
+		v, ok := value.(Signature)
+		if !ok {
+			return mismatch
+		}
+		e.signature(string(v))
+	case 'v':
+		v, ok := value.(Variant)
+		if !ok {
+			return mismatch
+		}
+		e.signature(string(v.Signature))
+		return e.value(string(v.Signature), v.Value)
+	case 'a':
+		return e.array(signature[1:], value, mismatch)
+	case '(':
+		v, ok := value.([]interface{})
+		if !ok {
+			return mismatch
+		}
+		e.align(8)
+		return e.encode(signature[1:len(signature)-1], v...)
+	default:
+		return mismatch
+	}
+	return nil
+}
+
+func (e *encoder) array(elementSignature string, value interface{}, mismatch error) error {
+	e.uint32(0)
+	lengthOffset := len(e.data) - 4
+	e.align(alignment(elementSignature))
+	start := len(e.data)
+	switch v := value.(type) {
+	case []string:
+		for _, item := range v {
+			if err := e.value(elementSignature, item); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := e.value(elementSignature, item); err != nil {
+				return err
+			}
+		}
+	case map[string]Variant:
+		if elementSignature != "{sv}" {
+			return mismatch
+		}
+		for key, item := range v {
+			e.align(8)
+			e.string(key)
+			if err := e.value("v", item); err != nil {
+				return err
+			}
+		}
+	default:
+		return mismatch
+	}
+	binary.LittleEndian.PutUint32(e.data[lengthOffset:], uint32(len(e.data)-start))
+	return nil
+}
+
+// decoder reads values in the little-endian wire format
+type decoder struct {
+	data   []byte
+	offset int
+}
+
+var errShortMessage = errors.New("dbus: message too short")
+
+func (d *decoder) align(n int) error {
+	for d.offset%n != 0 {
+		d.offset++
+	}
+	if d.offset > len(d.data) {
+		return errShortMessage
+	}
+	return nil
+}
+
+func (d *decoder) read(n int) ([]byte, error) {
+	if d.offset+n > len(d.data) {
+		return nil, errShortMessage
+	}
+	b := d.data[d.offset : d.offset+n]
+	d.offset += n
+	return b, nil
+}
+
+func (d *decoder) uint32() (uint32, error) {
+	if err := d.align(4); err != nil {
+		return 0, err
+	}
+	b, err := d.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (d *decoder) string() (string, error) {
+	length, err := d.uint32()
+	if err != nil {
+		return "", err
+	}
+	b, err := d.read(int(length) + 1)
+	if err != nil {
+		return "", err
+	}
+	return string(b[:length]), nil
+}
+
+func (d *decoder) signature() (string, error) {
+	length, err := d.read(1)
+	if err != nil {
+		return "", err
+	}
+	b, err := d.read(int(length[0]) + 1)
+	if err != nil {
+		return "", err
+	}
+	return string(b[:length[0]]), nil
+}
+
+// decode reads the values for signature. Arrays are decoded as []interface{} (or map[interface{}]interface{}
+// for dictionaries) and structs as []interface{}
+func (d *decoder) decode(signature string) ([]interface{}, error) {
+	types, err := splitSignature(signature)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, 0, len(types))
+	for _, t := range types {
+		value, err := d.value(t)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func (d *decoder) value(signature string) (interface{}, error) {
+	switch signature[0] {
+	case 'y':
+		b, err := d.read(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0], nil
+	case 'b':
+		v, err := d.uint32()
+		return v != 0, err
+	case 'n', 'q':
+		if err := d.align(2); err != nil {
+			return nil, err
+		}
+		b, err := d.read(2)
+		if err != nil {
+			return nil, err
+		}
+		v := binary.LittleEndian.Uint16(b)
+		if signature[0] == 'n' {
+			return int16(v), nil
+		}
+		return v, nil
+	case 'i':
+		v, err := d.uint32()
+		return int32(v), err
+	case 'u', 'h':
+		return d.uint32()
+	case 'x', 't', 'd':
+		if err := d.align(8); err != nil {
+			return nil, err
+		}
+		b, err := d.read(8)
+		if err != nil {
+			return nil, err
+		}
+		v := binary.LittleEndian.Uint64(b)
+		switch signature[0] {
+		case 'x':
+			return int64(v), nil
+		case 'd':
+			return math.Float64frombits(v), nil
+		}
+		return v, nil
+	case 's':
+		return d.string()
+	case 'o':
+		v, err := d.string()
+		return ObjectPath(v), err
+	case 'g':
+		v, err := d.signature()
+		return Signature(v), err
+	case 'v':
+		variantSignature, err := d.signature()
+		if err != nil {
+			return nil, err
+		}
+		if length, err := completeTypeLength(variantSignature); err != nil || length != len(variantSignature) {
+			return nil, fmt.Errorf("dbus: invalid variant signature %q", variantSignature)
+		}
+		v, err := d.value(variantSignature)
+		return Variant{Signature: Signature(variantSignature), Value: v}, err
+	case 'a':
+		return d.array(signature[1:])
+	case '(':
+		if err := d.align(8); err != nil {
+			return nil, err
+		}
+		return d.decode(signature[1 : len(signature)-1])
+	default:
+		return nil, fmt.Errorf("dbus: cannot decode %q", signature)
+	}
+}
+
+func (d *decoder) array(elementSignature string) (interface{}, error) {
+	length, err := d.uint32()
+	if err != nil {
+		return nil, err
+	}
+	if err = d.align(alignment(elementSignature)); err != nil {
+		return nil, err
+	}
+	end := d.offset + int(length)
+	if end > len(d.data) {
+		return nil, errShortMessage
+	}
+	if elementSignature[0] == '{' {
+		entry := elementSignature[1 : len(elementSignature)-1]
+		items := map[interface{}]interface{}{}
+		for d.offset < end {
+			if err := d.align(8); err != nil {
+				return nil, err
+			}
+			values, err := d.decode(entry)
+			if err != nil {
+				return nil, err
+			}
+			if len(values) != 2 {
+				return nil, fmt.Errorf("dbus: invalid dictionary entry %q", elementSignature)
+			}
+			items[values[0]] = values[1]
+		}
+		return items, nil
+	}
+	items := []interface{}{}
+	for d.offset < end {
+		v, err := d.value(elementSignature)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+	}
+	return items, nil
+}
@@ -0,0 +1,180 @@
+package dbus
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestSplitSignature(t *testing.T) {
+	tests := []struct {
+		signature string
+		expected  []string
+	}{
+		{"", []string{}},
+		{"s", []string{"s"}},
+		{"susssasa{sv}i", []string{"s", "u", "s", "s", "s", "as", "a{sv}", "i"}},
+		{"a(yv)", []string{"a(yv)"}},
+		{"(sa{s(ii)})ay", []string{"(sa{s(ii)})", "ay"}},
+	}
+	for _, test := range tests {
+		types, err := splitSignature(test.signature)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.signature, err)
+			continue
+		}
+		if !reflect.DeepEqual(types, test.expected) {
+			t.Errorf("%q: expected %q, got %q", test.signature, test.expected, types)
+		}
+	}
+
+	for _, signature := range []string{"a", "(s", "s)", "z"} {
+		if _, err := splitSignature(signature); err == nil {
+			t.Errorf("%q: expected an error", signature)
+		}
+	}
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		signature string
+		values    []interface{}
+		// expected is the decoded values, where they differ from values
+		expected []interface{}
+	}{
+		{"byte", "y", []interface{}{byte(7)}, nil},
+		{"bool", "b", []interface{}{true}, nil},
+		{"int32", "i", []interface{}{int32(-1)}, nil},
+		{"uint32", "u", []interface{}{uint32(42)}, nil},
+		{"int64", "x", []interface{}{int64(-5)}, nil},
+		{"uint64", "t", []interface{}{uint64(1) << 40}, nil},
+		{"float64", "d", []interface{}{1.5}, nil},
+		{"string", "s", []interface{}{"hello"}, nil},
+		{"object path", "o", []interface{}{ObjectPath("/org/freedesktop/Notifications")}, nil},
+		{"signature", "g", []interface{}{Signature("a{sv}")}, nil},
+		{"variant", "v", []interface{}{MakeVariant("x")}, nil},
+		{"mixed alignment", "yxyi", []interface{}{byte(1), int64(2), byte(3), int32(4)}, nil},
+		{
+			"string array", "as",
+			[]interface{}{[]string{"default", "Default"}},
+			[]interface{}{[]interface{}{"default", "Default"}},
+		},
+		{
+			"empty array of structs", "a(yv)",
+			[]interface{}{[]interface{}{}},
+			nil,
+		},
+		{
+			"dictionary", "a{sv}",
+			[]interface{}{map[string]Variant{"urgency": MakeVariant(byte(2)), "image-path": MakeVariant("/tmp/ring.jpg")}},
+			[]interface{}{map[interface{}]interface{}{"urgency": MakeVariant(byte(2)), "image-path": MakeVariant("/tmp/ring.jpg")}},
+		},
+		{
+			"struct", "(sui)",
+			[]interface{}{[]interface{}{"a", uint32(1), int32(-1)}},
+			nil,
+		},
+		{
+			"notify", "susssasa{sv}i",
+			[]interface{}{"Pi-Bell", uint32(0), "", "Doorbell", "Ring at 10:00", []string{"snooze:15m0s", "Snooze 15 minutes"}, map[string]Variant{}, int32(-1)},
+			[]interface{}{"Pi-Bell", uint32(0), "", "Doorbell", "Ring at 10:00", []interface{}{"snooze:15m0s", "Snooze 15 minutes"}, map[interface{}]interface{}{}, int32(-1)},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// start at an odd offset to check that alignment is relative to the start of the data
+			e := &encoder{data: []byte{0}}
+			if err := e.encode(test.signature, test.values...); err != nil {
+				t.Fatalf("encode returned an error: %v", err)
+			}
+			d := &decoder{data: e.data, offset: 1}
+			values, err := d.decode(test.signature)
+			if err != nil {
+				t.Fatalf("decode returned an error: %v", err)
+			}
+			expected := test.expected
+			if expected == nil {
+				expected = test.values
+			}
+			if !reflect.DeepEqual(values, expected) {
+				t.Errorf("expected %#v, got %#v", expected, values)
+			}
+			if d.offset != len(e.data) {
+				t.Errorf("decoded %d of %d bytes", d.offset, len(e.data))
+			}
+		})
+	}
+}
+
+func TestEncodeMismatch(t *testing.T) {
+	tests := []struct {
+		signature string
+		values    []interface{}
+	}{
+		{"s", []interface{}{1}},
+		{"u", []interface{}{int32(1)}},
+		{"a{sv}", []interface{}{map[string]string{}}},
+		{"ss", []interface{}{"one"}},
+	}
+	for _, test := range tests {
+		e := &encoder{}
+		if err := e.encode(test.signature, test.values...); err == nil {
+			t.Errorf("%q with %#v: expected an error", test.signature, test.values)
+		}
+	}
+}
+
+func TestDecodeShortData(t *testing.T) {
+	e := &encoder{}
+	if err := e.encode("sa{sv}", "hello", map[string]Variant{"key": MakeVariant("value")}); err != nil {
+		t.Fatal(err)
+	}
+	for length := 0; length < len(e.data); length++ {
+		d := &decoder{data: e.data[:length]}
+		if _, err := d.decode("sa{sv}"); err == nil {
+			t.Errorf("length %d: expected an error", length)
+		}
+	}
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	fields := []interface{}{
+		[]interface{}{byte(fieldPath), MakeVariant(ObjectPath("/org/freedesktop/Notifications"))},
+		[]interface{}{byte(fieldMember), MakeVariant("ActionInvoked")},
+		[]interface{}{byte(fieldSignature), Variant{Signature: "g", Value: Signature("us")}},
+	}
+	message, err := encodeMessage(typeSignal, 3, fields, "us", []interface{}{uint32(9), "snooze:1h0m0s"})
+	if err != nil {
+		t.Fatalf("encodeMessage returned an error: %v", err)
+	}
+
+	messageType, decodedFields, body, err := readMessage(bytes.NewReader(message))
+	if err != nil {
+		t.Fatalf("readMessage returned an error: %v", err)
+	}
+	if messageType != typeSignal {
+		t.Errorf("expected a signal, got type %d", messageType)
+	}
+	if decodedFields[fieldPath] != ObjectPath("/org/freedesktop/Notifications") || decodedFields[fieldMember] != "ActionInvoked" {
+		t.Errorf("unexpected fields %#v", decodedFields)
+	}
+	if !reflect.DeepEqual(body, []interface{}{uint32(9), "snooze:1h0m0s"}) {
+		t.Errorf("unexpected body %#v", body)
+	}
+}
+
+func FuzzReadMessage(f *testing.F) {
+	fields := []interface{}{
+		[]interface{}{byte(fieldMember), MakeVariant("Notify")},
+		[]interface{}{byte(fieldSignature), Variant{Signature: "g", Value: Signature("sa{sv}")}},
+	}
+	message, err := encodeMessage(typeMethodCall, 1, fields, "sa{sv}", []interface{}{"a", map[string]Variant{"b": MakeVariant(int32(1))}})
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(message)
+	f.Fuzz(func(t *testing.T, message []byte) {
+		_, _, _, _ = readMessage(bytes.NewReader(message))
+	})
+}
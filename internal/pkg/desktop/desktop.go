@@ -0,0 +1,171 @@
+// Package desktop shows desktop notifications using the freedesktop notifications service
+// (org.freedesktop.Notifications on the session bus), with a terminal fallback for when there is no desktop session
+package desktop
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/stuartleeks/pi-bell/internal/pkg/dbus"
+)
+
+// Action is a button on a notification
+type Action struct {
+	// Key is passed to the notification's OnAction when the action is invoked
+	Key   string
+	Label string
+}
+
+// Notification is a notification to show
+type Notification struct {
+	Summary string
+	Body    string
+	// ImagePath is an image file to show with the notification (optional)
+	ImagePath string
+	Actions   []Action
+	// OnAction is called with the key of the action that the user invokes
+	OnAction func(key string)
+	// Critical notifications stay until they are dismissed
+	Critical bool
+}
+
+// Notifier shows notifications
+type Notifier interface {
+	Notify(notification Notification) error
+	Close() error
+}
+
+const (
+	notificationsName      = "org.freedesktop.Notifications"
+	notificationsPath      = dbus.ObjectPath("/org/freedesktop/Notifications")
+	notificationsInterface = "org.freedesktop.Notifications"
+
+	urgencyNormal   = byte(1)
+	urgencyCritical = byte(2)
+)
+
+// DBusNotifier shows notifications via the notifications service on the session bus
+type DBusNotifier struct {
+	appName string
+	conn    *dbus.Conn
+
+	mutex sync.Mutex
+	// onAction are the action handlers for the notifications that are showing, by notification ID
+	onAction map[uint32]func(string)
+}
+
+// NewDBusNotifier connects to the session bus. Notifications are shown as coming from appName
+func NewDBusNotifier(appName string) (*DBusNotifier, error) {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, err
+	}
+	n := &DBusNotifier{
+		appName:  appName,
+		conn:     conn,
+		onAction: map[uint32]func(string){},
+	}
+	conn.OnSignal(n.handleSignal)
+	if err = conn.AddMatch(fmt.Sprintf("type='signal',interface='%s'", notificationsInterface)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to subscribe to notification signals: %w", err)
+	}
+	// check that there is a notifications service
+	if _, err = conn.Call(notificationsName, notificationsPath, notificationsInterface, "GetServerInformation", ""); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("no notifications service: %w", err)
+	}
+	return n, nil
+}
+
+// Notify shows notification
+func (n *DBusNotifier) Notify(notification Notification) error {
+	actions := []string{}
+	for _, action := range notification.Actions {
+		actions = append(actions, action.Key, action.Label)
+	}
+	urgency := urgencyNormal
+	if notification.Critical {
+		urgency = urgencyCritical
+	}
+	hints := map[string]dbus.Variant{
+		"urgency":  dbus.MakeVariant(urgency),
+		"category": dbus.MakeVariant("device"),
+	}
+	if notification.ImagePath != "" {
+		hints["image-path"] = dbus.MakeVariant("file://" + notification.ImagePath)
+	}
+	reply, err := n.conn.Call(notificationsName, notificationsPath, notificationsInterface, "Notify", "susssasa{sv}i",
+		n.appName, uint32(0), "", notification.Summary, notification.Body, actions, hints, int32(-1))
+	if err != nil {
+		return fmt.Errorf("failed to show notification: %w", err)
+	}
+	// the lock isn't held during the call as signals are handled on the connection's read loop, which delivers the reply
+	if notification.OnAction == nil || len(reply) == 0 {
+		return nil
+	}
+	if id, ok := reply[0].(uint32); ok {
+		n.mutex.Lock()
+		n.onAction[id] = notification.OnAction
+		n.mutex.Unlock()
+	}
+	return nil
+}
+
+func (n *DBusNotifier) handleSignal(signal *dbus.Signal) {
+	if signal.Interface != notificationsInterface || len(signal.Body) < 2 {
+		return
+	}
+	id, _ := signal.Body[0].(uint32)
+	n.mutex.Lock()
+	onAction := n.onAction[id]
+	if signal.Member == "NotificationClosed" {
+		delete(n.onAction, id)
+	}
+	n.mutex.Unlock()
+	if signal.Member == "ActionInvoked" && onAction != nil {
+		key, _ := signal.Body[1].(string)
+		// don't block the connection's read loop
+		go onAction(key)
+	}
+}
+
+// Done is closed if the connection to the session bus closes
+func (n *DBusNotifier) Done() <-chan struct{} {
+	return n.conn.Done()
+}
+
+// Close closes the connection to the session bus
+func (n *DBusNotifier) Close() error {
+	return n.conn.Close()
+}
+
+// TerminalNotifier writes notifications to a terminal, ringing the terminal bell. Actions aren't supported
+type TerminalNotifier struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+// NewTerminalNotifier returns a notifier that writes to writer (e.g. os.Stdout)
+func NewTerminalNotifier(writer io.Writer) *TerminalNotifier {
+	return &TerminalNotifier{writer: writer}
+}
+
+// Notify writes the notification with a terminal bell
+func (n *TerminalNotifier) Notify(notification Notification) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	text := fmt.Sprintf("\a%s %s", time.Now().Format("15:04:05"), notification.Summary)
+	if notification.Body != "" {
+		text += ": " + notification.Body
+	}
+	_, err := fmt.Fprintln(n.writer, text)
+	return err
+}
+
+// Close does nothing
+func (n *TerminalNotifier) Close() error {
+	return nil
+}
@@ -0,0 +1,195 @@
+// Package discovery advertises the bellpush on the local network using mDNS/DNS-SD
+// so that chimes can find it without being configured with its address.
+//
+// The bellpush registers a _pibell._tcp service with TXT records describing it:
+//
+//	version=v0.3.0
+//	doors=Front door
+//	tls=false
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/stuartleeks/pi-bell/internal/pkg/logging"
+)
+
+const (
+	// ServiceType is the DNS-SD service type advertised by the bellpush
+	ServiceType = "_pibell._tcp"
+	domain      = "local."
+
+	txtVersion = "version"
+	txtDoors   = "doors"
+	txtTLS     = "tls"
+
+	// ResolveTimeout is how long to browse for the bellpush before giving up (and retrying)
+	ResolveTimeout = 10 * time.Second
+)
+
+var logger = logging.New("component", "discovery")
+
+// Info is the bellpush information published in the TXT records
+type Info struct {
+	Version string
+	// Doors are the names of the doors that the bellpush rings for
+	Doors []string
+	// TLS is true if the bellpush serves HTTPS/WSS rather than HTTP/WS
+	TLS bool
+}
+
+func (i Info) text() []string {
+	return []string{
+		txtVersion + "=" + i.Version,
+		txtDoors + "=" + strings.Join(i.Doors, ","),
+		txtTLS + "=" + strconv.FormatBool(i.TLS),
+	}
+}
+
+func parseText(text []string) Info {
+	info := Info{}
+	for _, record := range text {
+		key, value, _ := strings.Cut(record, "=")
+		switch key {
+		case txtVersion:
+			info.Version = value
+		case txtDoors:
+			if value != "" {
+				info.Doors = strings.Split(value, ",")
+			}
+		case txtTLS:
+			info.TLS, _ = strconv.ParseBool(value)
+		}
+	}
+	return info
+}
+
+// Advertisement is a registered service. Call Shutdown to withdraw it
+type Advertisement struct {
+	server *zeroconf.Server
+}
+
+// Advertise registers the bellpush service on all interfaces. instance is the name of the
+// service (e.g. the door name) and port is the bellpush HTTP port
+func Advertise(instance string, port int, info Info) (*Advertisement, error) {
+	server, err := zeroconf.Register(instance, ServiceType, domain, port, info.text(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register mDNS service: %w", err)
+	}
+	logger.Info("Advertising service", "instance", instance, "serviceType", ServiceType, "port", port, "txt", strings.Join(info.text(), " "))
+	return &Advertisement{server: server}, nil
+}
+
+// Shutdown withdraws the service (sending a goodbye packet so that chimes stop using it)
+func (a *Advertisement) Shutdown() {
+	a.server.Shutdown()
+}
+
+// Service is a bellpush found by Resolve or Browse
+type Service struct {
+	Instance string
+	// Address is the host:port to connect to
+	Address string
+	Info    Info
+}
+
+// Resolve browses for bellpush services until one is found or ctx is done. If instance is not empty then
+// only the service with that name is returned.
+// The IPv4 address is preferred, so that a bellpush whose IP changes is found at its new address
+// when Resolve is called again (e.g. on reconnect)
+func Resolve(ctx context.Context, instance string) (Service, error) {
+	browseCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var found *Service
+	err := Browse(browseCtx, func(service Service) {
+		if found == nil && (instance == "" || service.Instance == instance) {
+			found = &service
+			cancel()
+		}
+	})
+	if err != nil {
+		return Service{}, err
+	}
+	if found == nil {
+		if instance != "" {
+			return Service{}, fmt.Errorf("%s service %q not found: %w", ServiceType, instance, ctx.Err())
+		}
+		return Service{}, fmt.Errorf("no %s service found: %w", ServiceType, ctx.Err())
+	}
+	return *found, nil
+}
+
+// Browse calls found for each bellpush service (with an address) that is seen until ctx is done.
+// Each instance is only reported once per call
+func Browse(ctx context.Context, found func(Service)) error {
+	resolver, err := zeroconf.NewResolver(nil)
+	if err != nil {
+		return fmt.Errorf("failed to create mDNS resolver: %w", err)
+	}
+
+	browseCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// zeroconf sends entries without checking for cancellation, so buffer them to avoid
+	// blocking its goroutine once we've returned
+	entries := make(chan *zeroconf.ServiceEntry, 16)
+	if err = resolver.Browse(browseCtx, ServiceType, domain, entries); err != nil {
+		return fmt.Errorf("failed to browse for %s: %w", ServiceType, err)
+	}
+	seen := map[string]bool{}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case entry, ok := <-entries:
+			if !ok {
+				return nil
+			}
+			ip := entryIP(entry)
+			instance := unescapeInstance(entry.Instance)
+			if ip == nil || seen[instance] {
+				// wait for an entry with an address, and only report each instance once
+				continue
+			}
+			seen[instance] = true
+			found(Service{
+				Instance: instance,
+				Address:  net.JoinHostPort(ip.String(), strconv.Itoa(entry.Port)),
+				Info:     parseText(entry.Text),
+			})
+		}
+	}
+}
+
+func entryIP(entry *zeroconf.ServiceEntry) net.IP {
+	if len(entry.AddrIPv4) > 0 {
+		return entry.AddrIPv4[0]
+	}
+	// skip link-local IPv6 addresses as they need a zone to connect to
+	for _, ip := range entry.AddrIPv6 {
+		if !ip.IsLinkLocalUnicast() {
+			return ip
+		}
+	}
+	return nil
+}
+
+// unescapeInstance removes the DNS escaping (e.g. `Front\ door`) from an instance name
+func unescapeInstance(instance string) string {
+	var builder strings.Builder
+	escaped := false
+	for _, r := range instance {
+		if r == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
@@ -1,10 +1,8 @@
 package events
 
 import (
-	"encoding/json"
 	"fmt"
-
-	"github.com/gobuffalo/uuid"
+	"time"
 )
 
 // ButtonEventType indicates the type of button event
@@ -15,65 +13,74 @@ const (
 	ButtonPressed ButtonEventType = iota
 	// ButtonReleased occurs when a button is released after being pressed
 	ButtonReleased
+	// ButtonStuck occurs when a button has been held for longer than the bellpush's stuck button timeout.
+	// Chimes treat it as a release, and the bellpush doesn't send presses for the button until it is released
+	ButtonStuck
+	// ButtonShortPress follows the release of a press that was shorter than a long press and wasn't followed by another press
+	ButtonShortPress
+	// ButtonLongPress follows the release of a press that was held for at least the long press duration
+	ButtonLongPress
+	// ButtonDoublePress follows the release of a second press that started soon after a short press
+	ButtonDoublePress
 )
 
 // ButtonEvent represents an event for a button
 type ButtonEvent struct {
 	EventCommon
-	ID              uuid.UUID       `json:"id"`
 	ButtonEventType ButtonEventType `json:"buttonEventType"`
 	Source          string          `json:"source"`
+	// Door is the name of the door that the bell push is for
+	Door string `json:"door,omitempty"`
+	// HeldFor is how long the button was held for short and long presses
+	HeldFor time.Duration `json:"heldFor,omitempty"`
+	// Time is when the event occurred on the bellpush (e.g. the GPIO callback) and is the origin for latency measurements
+	Time time.Time `json:"time"`
+	// Hops records when the event reached each point on its way to a chime (see latency.go)
+	Hops []Hop `json:"hops,omitempty"`
 }
 
 func NewButtonEvent(buttonEventType ButtonEventType, source string) *ButtonEvent {
 	return &ButtonEvent{
-		EventCommon: EventCommon{
-			EventType: EventTypeButton,
-		},
-		ID:              uuid.Must(uuid.NewV4()),
+		EventCommon:     newEventCommon(EventTypeButton),
 		ButtonEventType: buttonEventType,
 		Source:          source,
+		Time:            time.Now(),
 	}
 }
 
 var _ Event = ButtonEvent{}
 
+func init() {
+	Register(EventTypeButton, func() Event { return &ButtonEvent{} })
+}
+
 func TypeToString(eventType ButtonEventType) string {
 	switch eventType {
 	case ButtonPressed:
 		return "pressed"
 	case ButtonReleased:
 		return "released"
+	case ButtonStuck:
+		return "stuck"
+	case ButtonShortPress:
+		return "short-press"
+	case ButtonLongPress:
+		return "long-press"
+	case ButtonDoublePress:
+		return "double-press"
 	default:
 		return fmt.Sprintf("%d", eventType)
 	}
 }
 
-// ToJSON converts the event to JSON
-func (e ButtonEvent) ToJSON() (string, error) {
-	jsonValue, err := json.Marshal(e)
-	return string(jsonValue), err
-}
-func (e ButtonEvent) GetType() string {
-	return e.EventType
-}
-
-// ParseButtonEventJSON parses the JSON representation of a ButtonEvent
-func ParseButtonEventJSON(jsonValue []byte) (*ButtonEvent, error) {
-
-	var buttonEvent ButtonEvent
-	err := json.Unmarshal(jsonValue, &buttonEvent)
-	if err != nil {
-		return nil, err
-	}
-	return &buttonEvent, nil
-}
-
 func (e ButtonEvent) GetProperties() map[string]string {
 	return map[string]string{
 		"type":            e.EventType,
 		"id":              e.ID.String(),
 		"buttonEventType": TypeToString(e.ButtonEventType),
 		"source":          e.Source,
+		"door":            e.Door,
+		"heldFor":         e.HeldFor.String(),
+		"time":            e.Time.Format(time.RFC3339Nano),
 	}
 }
@@ -0,0 +1,35 @@
+package events
+
+import (
+	"strconv"
+)
+
+// ChimeStatusEvent is raised by the bellpush when a chime connects or disconnects
+type ChimeStatusEvent struct {
+	EventCommon
+	ChimeName string `json:"chimeName"`
+	Connected bool   `json:"connected"`
+}
+
+var _ Event = ChimeStatusEvent{}
+
+func init() {
+	Register(EventTypeChimeStatus, func() Event { return &ChimeStatusEvent{} })
+}
+
+func NewChimeStatusEvent(chimeName string, connected bool) *ChimeStatusEvent {
+	return &ChimeStatusEvent{
+		EventCommon: newEventCommon(EventTypeChimeStatus),
+		ChimeName:   chimeName,
+		Connected:   connected,
+	}
+}
+
+func (e ChimeStatusEvent) GetProperties() map[string]string {
+	return map[string]string{
+		"type":      e.EventType,
+		"id":        e.ID.String(),
+		"chimeName": e.ChimeName,
+		"connected": strconv.FormatBool(e.Connected),
+	}
+}
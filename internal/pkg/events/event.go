@@ -2,6 +2,8 @@ package events
 
 import (
 	"encoding/json"
+
+	"github.com/gobuffalo/uuid"
 )
 
 const (
@@ -9,24 +11,44 @@ const (
 	EventTypeSnooze         = "snooze-event"
 	EventTypeUnSnooze       = "unsnooze-event"
 	EventTypeStopProcessing = "stop-processing-event"
+	EventTypeChimeStatus    = "chime-status-event"
+	EventTypeMissedRings    = "missed-rings-event"
 )
 
+// EventCommon holds the fields common to all events. It is embedded in each event type
 type EventCommon struct {
-	EventType string `json:"eventType"`
+	EventType string    `json:"eventType"`
+	ID        uuid.UUID `json:"id"`
+}
+
+// newEventCommon returns the common fields for a new event of eventType, with a new ID
+func newEventCommon(eventType string) EventCommon {
+	return EventCommon{
+		EventType: eventType,
+		ID:        uuid.Must(uuid.NewV4()),
+	}
+}
+
+// GetType returns the event type
+func (e EventCommon) GetType() string {
+	return e.EventType
 }
+
+// GetID returns the ID of the event
+func (e EventCommon) GetID() uuid.UUID {
+	return e.ID
+}
+
 type Event interface {
 	GetType() string
+	// GetID returns the unique ID of the event. For button events this is used as the
+	// correlation ID when logging the handling of the event on the bellpush and chimes
+	GetID() uuid.UUID
 	GetProperties() map[string]string
-	ToJSON() (string, error)
 }
 
-// ParseEventJSON parses the JSON representation of a Event
-func ParseEventJSON(jsonValue []byte) (*EventCommon, error) {
-
-	var event EventCommon
-	err := json.Unmarshal(jsonValue, &event)
-	if err != nil {
-		return nil, err
-	}
-	return &event, nil
+// ToJSON converts the event to JSON
+func ToJSON(event Event) (string, error) {
+	jsonValue, err := json.Marshal(event)
+	return string(jsonValue), err
 }
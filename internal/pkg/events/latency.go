@@ -0,0 +1,148 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gobuffalo/uuid"
+)
+
+// Hops recorded as a button event travels from the bellpush to a chime.
+// The origin of the event (e.g. the GPIO callback) is the event's Time rather than a hop
+const (
+	// HopBroadcast is when the bellpush broadcast the event to the chimes (bellpush clock)
+	HopBroadcast = "broadcast"
+	// HopSend is when the bellpush wrote the event to the chime's websocket (bellpush clock)
+	HopSend = "send"
+	// HopReceive is when the chime read the event from the websocket (chime clock)
+	HopReceive = "receive"
+	// HopRelayOn is when the chime turned the relay on (chime clock)
+	HopRelayOn = "relay-on"
+	// HopReport is when the chime sent the latency report back to the bellpush (chime clock)
+	HopReport = "report"
+)
+
+// MessageTypeLatencyReport is the messageType for a LatencyReport sent from a chime to the bellpush
+const MessageTypeLatencyReport = "latency-report"
+
+// Hop records when a button event reached a point on its way to the chime relay
+type Hop struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+}
+
+// AddHop records that the event reached the named hop at the specified time
+func (e *ButtonEvent) AddHop(name string, t time.Time) {
+	e.Hops = append(e.Hops, Hop{Name: name, Time: t})
+}
+
+// WithHop returns a copy of the event with the hop added, leaving the original unchanged.
+// This is used when the event is shared between chimes, e.g. to record per-chime send times
+func (e *ButtonEvent) WithHop(name string, t time.Time) *ButtonEvent {
+	result := *e
+	result.Hops = make([]Hop, len(e.Hops), len(e.Hops)+1)
+	copy(result.Hops, e.Hops)
+	result.AddHop(name, t)
+	return &result
+}
+
+// LatencyReport is sent by a chime after handling a button pressed event so that the bellpush
+// can measure the end-to-end latency. It contains the hops from the event along with those added by the chime
+type LatencyReport struct {
+	MessageType string    `json:"messageType"`
+	EventID     uuid.UUID `json:"eventId"`
+	// EventTime is the origin time of the event (bellpush clock)
+	EventTime time.Time `json:"eventTime"`
+	Hops      []Hop     `json:"hops"`
+}
+
+// NewLatencyReport creates a report for the button event (including the hops recorded so far)
+func NewLatencyReport(event *ButtonEvent) *LatencyReport {
+	hops := make([]Hop, len(event.Hops))
+	copy(hops, event.Hops)
+	return &LatencyReport{
+		MessageType: MessageTypeLatencyReport,
+		EventID:     event.ID,
+		EventTime:   event.Time,
+		Hops:        hops,
+	}
+}
+
+// AddHop records that the report reached the named hop at the specified time
+func (r *LatencyReport) AddHop(name string, t time.Time) {
+	r.Hops = append(r.Hops, Hop{Name: name, Time: t})
+}
+
+// HopTime returns the time recorded for the named hop
+func (r *LatencyReport) HopTime(name string) (time.Time, bool) {
+	for _, hop := range r.Hops {
+		if hop.Name == name {
+			return hop.Time, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ParseLatencyReportJSON parses the JSON representation of a LatencyReport
+func ParseLatencyReportJSON(jsonValue []byte) (*LatencyReport, error) {
+	var report LatencyReport
+	err := json.Unmarshal(jsonValue, &report)
+	if err != nil {
+		return nil, err
+	}
+	if report.MessageType != MessageTypeLatencyReport {
+		return nil, fmt.Errorf("unexpected messageType %q", report.MessageType)
+	}
+	return &report, nil
+}
+
+// LatencyBreakdown is the result of analysing a LatencyReport
+type LatencyBreakdown struct {
+	// ClockOffset is the estimated offset of the chime's clock from the bellpush's clock
+	ClockOffset time.Duration
+	// RoundTrip is the network round trip time (excluding the time the chime spent handling the event)
+	RoundTrip time.Duration
+	// Latencies is the time from the event origin to each hop, with chime hops adjusted for ClockOffset
+	Latencies map[string]time.Duration
+}
+
+// Analyse calculates the latency to each hop. receivedAt is when the bellpush received the report.
+//
+// The clock offset between the Pis is estimated in the same way as NTP, using the send time (T1, bellpush clock),
+// receive time (T2, chime clock), report time (T3, chime clock) and receivedAt (T4, bellpush clock):
+//
+//	offset    = ((T2 - T1) + (T3 - T4)) / 2
+//	roundTrip = (T4 - T1) - (T3 - T2)
+//
+// This assumes that the network delay is the same in each direction
+func (r *LatencyReport) Analyse(receivedAt time.Time) (LatencyBreakdown, error) {
+	sendTime, ok := r.HopTime(HopSend)
+	if !ok {
+		return LatencyBreakdown{}, fmt.Errorf("missing %q hop", HopSend)
+	}
+	receiveTime, ok := r.HopTime(HopReceive)
+	if !ok {
+		return LatencyBreakdown{}, fmt.Errorf("missing %q hop", HopReceive)
+	}
+	reportTime, ok := r.HopTime(HopReport)
+	if !ok {
+		return LatencyBreakdown{}, fmt.Errorf("missing %q hop", HopReport)
+	}
+
+	offset := (receiveTime.Sub(sendTime) + reportTime.Sub(receivedAt)) / 2
+	breakdown := LatencyBreakdown{
+		ClockOffset: offset,
+		RoundTrip:   receivedAt.Sub(sendTime) - reportTime.Sub(receiveTime),
+		Latencies:   make(map[string]time.Duration, len(r.Hops)),
+	}
+	for _, hop := range r.Hops {
+		hopTime := hop.Time
+		switch hop.Name {
+		case HopReceive, HopRelayOn, HopReport:
+			hopTime = hopTime.Add(-offset)
+		}
+		breakdown.Latencies[hop.Name] = hopTime.Sub(r.EventTime)
+	}
+	return breakdown, nil
+}
@@ -0,0 +1,108 @@
+package events
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestLatencyReport returns a report for an event at eventTime with the hops at the specified offsets from it
+func newTestLatencyReport(eventTime time.Time, hops map[string]time.Duration) *LatencyReport {
+	report := &LatencyReport{MessageType: MessageTypeLatencyReport, EventTime: eventTime}
+	for _, name := range []string{HopBroadcast, HopSend, HopReceive, HopRelayOn, HopReport} {
+		if offset, ok := hops[name]; ok {
+			report.AddHop(name, eventTime.Add(offset))
+		}
+	}
+	return report
+}
+
+func TestLatencyReportAnalyse(t *testing.T) {
+	eventTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	ms := time.Millisecond
+	// chimeHops returns the hops for an event that takes outbound to reach a chime whose clock is skew ahead
+	// of the bellpush: it is sent at 2ms, turns the relay on 2ms after receiving it and reports 1ms after that
+	chimeHops := func(outbound time.Duration, skew time.Duration) map[string]time.Duration {
+		return map[string]time.Duration{
+			HopBroadcast: 1 * ms,
+			HopSend:      2 * ms,
+			HopReceive:   2*ms + outbound + skew,
+			HopRelayOn:   4*ms + outbound + skew,
+			HopReport:    5*ms + outbound + skew,
+		}
+	}
+
+	testCases := []struct {
+		name       string
+		hops       map[string]time.Duration
+		receivedAt time.Duration
+		expected   LatencyBreakdown
+	}{
+		{
+			name:       "synchronised clocks",
+			hops:       chimeHops(5*ms, 0),
+			receivedAt: 15 * ms,
+			expected: LatencyBreakdown{
+				ClockOffset: 0,
+				RoundTrip:   10 * ms,
+				Latencies:   map[string]time.Duration{HopBroadcast: 1 * ms, HopSend: 2 * ms, HopReceive: 7 * ms, HopRelayOn: 9 * ms, HopReport: 10 * ms},
+			},
+		},
+		{
+			name:       "chime clock ahead",
+			hops:       chimeHops(5*ms, 2*time.Second),
+			receivedAt: 15 * ms,
+			expected: LatencyBreakdown{
+				ClockOffset: 2 * time.Second,
+				RoundTrip:   10 * ms,
+				Latencies:   map[string]time.Duration{HopBroadcast: 1 * ms, HopSend: 2 * ms, HopReceive: 7 * ms, HopRelayOn: 9 * ms, HopReport: 10 * ms},
+			},
+		},
+		{
+			name:       "chime clock behind",
+			hops:       chimeHops(5*ms, -1500*ms),
+			receivedAt: 15 * ms,
+			expected: LatencyBreakdown{
+				ClockOffset: -1500 * ms,
+				RoundTrip:   10 * ms,
+				Latencies:   map[string]time.Duration{HopBroadcast: 1 * ms, HopSend: 2 * ms, HopReceive: 7 * ms, HopRelayOn: 9 * ms, HopReport: 10 * ms},
+			},
+		},
+		{
+			// the network delay is assumed to be the same in each direction, so half the difference shows up
+			// as clock offset
+			name:       "asymmetric network delay",
+			hops:       chimeHops(8*ms, time.Second),
+			receivedAt: 15 * ms,
+			expected: LatencyBreakdown{
+				ClockOffset: time.Second + 3*ms,
+				RoundTrip:   10 * ms,
+				Latencies:   map[string]time.Duration{HopBroadcast: 1 * ms, HopSend: 2 * ms, HopReceive: 7 * ms, HopRelayOn: 9 * ms, HopReport: 10 * ms},
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			report := newTestLatencyReport(eventTime, testCase.hops)
+			breakdown, err := report.Analyse(eventTime.Add(testCase.receivedAt))
+			if err != nil {
+				t.Fatalf("Analyse returned an error: %v", err)
+			}
+			if !reflect.DeepEqual(breakdown, testCase.expected) {
+				t.Errorf("expected %+v, got %+v", testCase.expected, breakdown)
+			}
+		})
+	}
+}
+
+func TestLatencyReportAnalyseMissingHops(t *testing.T) {
+	eventTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, missing := range []string{HopSend, HopReceive, HopReport} {
+		hops := map[string]time.Duration{HopSend: time.Millisecond, HopReceive: 2 * time.Millisecond, HopReport: 3 * time.Millisecond}
+		delete(hops, missing)
+		report := newTestLatencyReport(eventTime, hops)
+		if breakdown, err := report.Analyse(eventTime.Add(4 * time.Millisecond)); err == nil {
+			t.Errorf("missing %s: expected an error, got %+v", missing, breakdown)
+		}
+	}
+}
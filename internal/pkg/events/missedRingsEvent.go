@@ -0,0 +1,52 @@
+package events
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gobuffalo/uuid"
+)
+
+// MissedRing is a ring that a chime missed while it was disconnected
+type MissedRing struct {
+	// ID is the ID of the button event for the ring
+	ID     uuid.UUID `json:"id"`
+	Time   time.Time `json:"time"`
+	Door   string    `json:"door,omitempty"`
+	Source string    `json:"source,omitempty"`
+}
+
+// MissedRingsEvent is sent by the bellpush to a chime when it reconnects, summarising the rings that it
+// missed while it was disconnected
+type MissedRingsEvent struct {
+	EventCommon
+	// DisconnectedAt is when the bellpush noticed that the chime had disconnected
+	DisconnectedAt time.Time `json:"disconnectedAt"`
+	// Count is the number of missed rings, which can be more than len(Rings) as only the most recent are kept
+	Count int          `json:"count"`
+	Rings []MissedRing `json:"rings"`
+}
+
+var _ Event = MissedRingsEvent{}
+
+func init() {
+	Register(EventTypeMissedRings, func() Event { return &MissedRingsEvent{} })
+}
+
+func NewMissedRingsEvent(disconnectedAt time.Time, count int, rings []MissedRing) *MissedRingsEvent {
+	return &MissedRingsEvent{
+		EventCommon:    newEventCommon(EventTypeMissedRings),
+		DisconnectedAt: disconnectedAt,
+		Count:          count,
+		Rings:          rings,
+	}
+}
+
+func (e MissedRingsEvent) GetProperties() map[string]string {
+	return map[string]string{
+		"type":           e.EventType,
+		"id":             e.ID.String(),
+		"disconnectedAt": e.DisconnectedAt.String(),
+		"count":          strconv.Itoa(e.Count),
+	}
+}
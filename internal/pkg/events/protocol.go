@@ -0,0 +1,163 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// The chime starts its websocket connection to the bellpush with a Hello message. The bellpush replies with a
+// Welcome message with the protocol version and features to use, or closes the connection with a reason if
+// there isn't a protocol version that both support. Protocol version 1 is the original hello message, which
+// only has the chime name. Chimes that send it don't get a welcome message
+const (
+	MessageTypeHello   = "hello"
+	MessageTypeWelcome = "welcome"
+
+	// ProtocolVersion is the latest protocol version supported by the bellpush and chime
+	ProtocolVersion = 2
+	// MinProtocolVersion is the oldest protocol version supported by the bellpush and chime
+	MinProtocolVersion = 1
+)
+
+// Capabilities that a chime sends in its Hello message. The relay, audio, LED and desktop capabilities describe
+// the chime's hardware. The others are protocol features, which are negotiated with the bellpush
+const (
+	CapabilityRelay   = "relay"
+	CapabilityAudio   = "audio"
+	CapabilityLED     = "led"
+	CapabilityDesktop = "desktop"
+	// FeatureAck is for chimes that acknowledge button pressed events with a LatencyReport
+	FeatureAck = "ack"
+	// FeatureSnoozeRequest is for chimes that send a SnoozeRequest (e.g. from a snooze button)
+	FeatureSnoozeRequest = "snooze-request"
+	// FeatureMissedRings is for chimes that handle a MissedRingsEvent when they reconnect
+	FeatureMissedRings = "missed-rings"
+	// FeatureButtonEvents is for chimes that handle the stuck button and short, long and double press events
+	FeatureButtonEvents = "button-events"
+)
+
+// Features are the protocol features supported by this version of the bellpush and chime
+var Features = []string{FeatureAck, FeatureSnoozeRequest, FeatureMissedRings, FeatureButtonEvents}
+
+// Hello is sent by a chime when it connects to the bellpush
+type Hello struct {
+	MessageType string `json:"messageType"`
+	// SenderName is the name of the chime
+	SenderName string `json:"senderName"`
+	// ProtocolVersion and MinProtocolVersion are the range of protocol versions supported by the chime.
+	// They are zero for protocol version 1
+	ProtocolVersion    int    `json:"protocolVersion,omitempty"`
+	MinProtocolVersion int    `json:"minProtocolVersion,omitempty"`
+	SoftwareVersion    string `json:"softwareVersion,omitempty"`
+	// Capabilities are the chime's hardware and the protocol features that it supports
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// NewHello creates the Hello message for the chime
+func NewHello(senderName string, softwareVersion string, capabilities []string) *Hello {
+	return &Hello{
+		MessageType:        MessageTypeHello,
+		SenderName:         senderName,
+		ProtocolVersion:    ProtocolVersion,
+		MinProtocolVersion: MinProtocolVersion,
+		SoftwareVersion:    softwareVersion,
+		Capabilities:       capabilities,
+	}
+}
+
+// ParseHelloJSON parses the JSON representation of a Hello. The protocol versions are set to 1 for a
+// version 1 hello message
+func ParseHelloJSON(jsonValue []byte) (*Hello, error) {
+	var hello Hello
+	if err := json.Unmarshal(jsonValue, &hello); err != nil {
+		return nil, err
+	}
+	if hello.MessageType != MessageTypeHello {
+		return nil, fmt.Errorf("unexpected messageType %q", hello.MessageType)
+	}
+	if hello.SenderName == "" {
+		return nil, fmt.Errorf("no senderName")
+	}
+	if hello.ProtocolVersion == 0 {
+		hello.ProtocolVersion = 1
+	}
+	if hello.MinProtocolVersion == 0 {
+		hello.MinProtocolVersion = 1
+	}
+	if hello.MinProtocolVersion > hello.ProtocolVersion {
+		return nil, fmt.Errorf("invalid protocol versions %d-%d", hello.MinProtocolVersion, hello.ProtocolVersion)
+	}
+	return &hello, nil
+}
+
+// Welcome is sent by the bellpush in reply to a Hello with the protocol version and features to use
+type Welcome struct {
+	MessageType     string `json:"messageType"`
+	ProtocolVersion int    `json:"protocolVersion"`
+	SoftwareVersion string `json:"softwareVersion"`
+	DoorName        string `json:"doorName"`
+	// Features are the protocol features supported by both the chime and the bellpush
+	Features []string `json:"features"`
+}
+
+// NewWelcome creates the Welcome message for the negotiated protocol version and features
+func NewWelcome(protocolVersion int, features []string, softwareVersion string, doorName string) *Welcome {
+	return &Welcome{
+		MessageType:     MessageTypeWelcome,
+		ProtocolVersion: protocolVersion,
+		SoftwareVersion: softwareVersion,
+		DoorName:        doorName,
+		Features:        features,
+	}
+}
+
+// ParseWelcomeJSON parses the JSON representation of a Welcome
+func ParseWelcomeJSON(jsonValue []byte) (*Welcome, error) {
+	var welcome Welcome
+	if err := json.Unmarshal(jsonValue, &welcome); err != nil {
+		return nil, err
+	}
+	if welcome.MessageType != MessageTypeWelcome {
+		return nil, fmt.Errorf("unexpected messageType %q", welcome.MessageType)
+	}
+	return &welcome, nil
+}
+
+// HasFeature returns true if the feature was negotiated
+func (w *Welcome) HasFeature(feature string) bool {
+	return hasString(w.Features, feature)
+}
+
+// Negotiate returns the protocol version and features to use with a chime that sent hello: the latest version
+// supported by both and the features that both support. The error describes the versions supported by each
+// if there isn't a version that both support, and is short enough for a websocket close reason
+func Negotiate(hello *Hello) (int, []string, error) {
+	version := hello.ProtocolVersion
+	if version > ProtocolVersion {
+		version = ProtocolVersion
+	}
+	if version < hello.MinProtocolVersion || version < MinProtocolVersion {
+		return 0, nil, fmt.Errorf("incompatible protocol: chime supports %d-%d, bellpush supports %d-%d",
+			hello.MinProtocolVersion, hello.ProtocolVersion, MinProtocolVersion, ProtocolVersion)
+	}
+	features := []string{}
+	if version >= 2 {
+		for _, feature := range Features {
+			if hasString(hello.Capabilities, feature) {
+				features = append(features, feature)
+			}
+		}
+	}
+	sort.Strings(features)
+	return version, features, nil
+}
+
+func hasString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
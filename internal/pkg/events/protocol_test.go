@@ -0,0 +1,140 @@
+package events
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHelloJSON(t *testing.T) {
+	testCases := []struct {
+		name        string
+		jsonValue   string
+		expected    *Hello
+		expectError bool
+	}{
+		{
+			name:      "version 1 hello defaults the protocol versions",
+			jsonValue: `{"messageType":"hello","senderName":"kitchen"}`,
+			expected:  &Hello{MessageType: MessageTypeHello, SenderName: "kitchen", ProtocolVersion: 1, MinProtocolVersion: 1},
+		},
+		{
+			name:      "version 2 hello",
+			jsonValue: `{"messageType":"hello","senderName":"kitchen","protocolVersion":2,"minProtocolVersion":1,"softwareVersion":"v0.3.0","capabilities":["relay","ack"]}`,
+			expected: &Hello{
+				MessageType:        MessageTypeHello,
+				SenderName:         "kitchen",
+				ProtocolVersion:    2,
+				MinProtocolVersion: 1,
+				SoftwareVersion:    "v0.3.0",
+				Capabilities:       []string{"relay", "ack"},
+			},
+		},
+		{
+			name:      "unknown future version",
+			jsonValue: `{"messageType":"hello","senderName":"kitchen","protocolVersion":7,"minProtocolVersion":5}`,
+			expected:  &Hello{MessageType: MessageTypeHello, SenderName: "kitchen", ProtocolVersion: 7, MinProtocolVersion: 5},
+		},
+		{name: "wrong message type", jsonValue: `{"messageType":"welcome","senderName":"kitchen"}`, expectError: true},
+		{name: "no sender name", jsonValue: `{"messageType":"hello"}`, expectError: true},
+		{name: "min version greater than version", jsonValue: `{"messageType":"hello","senderName":"kitchen","protocolVersion":2,"minProtocolVersion":3}`, expectError: true},
+		{name: "invalid JSON", jsonValue: `{"messageType":`, expectError: true},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			hello, err := ParseHelloJSON([]byte(testCase.jsonValue))
+			if testCase.expectError {
+				if err == nil {
+					t.Errorf("expected an error, got %#v", hello)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseHelloJSON returned an error: %v", err)
+			}
+			if !reflect.DeepEqual(hello, testCase.expected) {
+				t.Errorf("expected %#v, got %#v", testCase.expected, hello)
+			}
+		})
+	}
+}
+
+func TestParseWelcomeJSON(t *testing.T) {
+	welcome, err := ParseWelcomeJSON([]byte(`{"messageType":"welcome","protocolVersion":2,"softwareVersion":"v0.3.0","doorName":"front","features":["ack","snooze-request"]}`))
+	if err != nil {
+		t.Fatalf("ParseWelcomeJSON returned an error: %v", err)
+	}
+	expected := NewWelcome(2, []string{FeatureAck, FeatureSnoozeRequest}, "v0.3.0", "front")
+	if !reflect.DeepEqual(welcome, expected) {
+		t.Errorf("expected %#v, got %#v", expected, welcome)
+	}
+	if !welcome.HasFeature(FeatureSnoozeRequest) || welcome.HasFeature(FeatureMissedRings) {
+		t.Errorf("expected only the listed features, got %v", welcome.Features)
+	}
+
+	for _, jsonValue := range []string{
+		`{"messageType":"hello","protocolVersion":2}`,
+		`{"protocolVersion":2}`,
+		`[]`,
+	} {
+		if welcome, err := ParseWelcomeJSON([]byte(jsonValue)); err == nil {
+			t.Errorf("%s: expected an error, got %#v", jsonValue, welcome)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	testCases := []struct {
+		name             string
+		hello            *Hello
+		expectedVersion  int
+		expectedFeatures []string
+		expectError      bool
+	}{
+		{
+			name:             "version 1 chime gets no features",
+			hello:            &Hello{ProtocolVersion: 1, MinProtocolVersion: 1, Capabilities: []string{FeatureAck}},
+			expectedVersion:  1,
+			expectedFeatures: []string{},
+		},
+		{
+			name:             "features are the intersection of the capabilities and the bellpush features",
+			hello:            &Hello{ProtocolVersion: 2, MinProtocolVersion: 1, Capabilities: []string{CapabilityRelay, FeatureSnoozeRequest, "teleport", FeatureAck}},
+			expectedVersion:  2,
+			expectedFeatures: []string{FeatureAck, FeatureSnoozeRequest},
+		},
+		{
+			name:             "no common features",
+			hello:            &Hello{ProtocolVersion: 2, MinProtocolVersion: 2, Capabilities: []string{CapabilityLED}},
+			expectedVersion:  2,
+			expectedFeatures: []string{},
+		},
+		{
+			name:             "newer chime falls back to the bellpush version",
+			hello:            &Hello{ProtocolVersion: ProtocolVersion + 3, MinProtocolVersion: 1, Capabilities: Features},
+			expectedVersion:  ProtocolVersion,
+			expectedFeatures: []string{FeatureAck, FeatureButtonEvents, FeatureMissedRings, FeatureSnoozeRequest},
+		},
+		{
+			name:        "unknown version above the bellpush version",
+			hello:       &Hello{ProtocolVersion: ProtocolVersion + 3, MinProtocolVersion: ProtocolVersion + 1},
+			expectError: true,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			version, features, err := Negotiate(testCase.hello)
+			if testCase.expectError {
+				if err == nil {
+					t.Errorf("expected an error, got version %d with %v", version, features)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Negotiate returned an error: %v", err)
+			}
+			if version != testCase.expectedVersion || !reflect.DeepEqual(features, testCase.expectedFeatures) {
+				t.Errorf("expected version %d with %v, got version %d with %v", testCase.expectedVersion, testCase.expectedFeatures, version, features)
+			}
+		})
+	}
+}
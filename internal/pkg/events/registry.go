@@ -0,0 +1,134 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/gobuffalo/uuid"
+)
+
+// registry holds a function for each registered event type that returns an empty event to decode into
+var (
+	registryLock sync.RWMutex
+	registry     = map[string]func() Event{}
+)
+
+// Register registers an event type for Decode. newEvent returns an empty event of the type (as a pointer) for the
+// JSON to be decoded into. The event types in this package register themselves. Panics if eventType is already
+// registered
+func Register(eventType string, newEvent func() Event) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, ok := registry[eventType]; ok {
+		panic(fmt.Sprintf("event type %q is already registered", eventType))
+	}
+	registry[eventType] = newEvent
+}
+
+// RegisteredTypes returns the registered event types, sorted
+func RegisteredTypes() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	eventTypes := make([]string, 0, len(registry))
+	for eventType := range registry {
+		eventTypes = append(eventTypes, eventType)
+	}
+	sort.Strings(eventTypes)
+	return eventTypes
+}
+
+// Decode decodes the JSON representation of an event, returning the registered type for its eventType
+// (e.g. *ButtonEvent). An event with a type that isn't registered (e.g. from a newer bellpush) is returned
+// as an *UnknownEvent rather than an error
+func Decode(jsonValue []byte) (Event, error) {
+	var header struct {
+		EventType string `json:"eventType"`
+	}
+	if err := json.Unmarshal(jsonValue, &header); err != nil {
+		return nil, err
+	}
+	if header.EventType == "" {
+		return nil, fmt.Errorf("no eventType")
+	}
+	registryLock.RLock()
+	newEvent, ok := registry[header.EventType]
+	registryLock.RUnlock()
+	if !ok {
+		return newUnknownEvent(header.EventType, jsonValue), nil
+	}
+	event := newEvent()
+	if err := json.Unmarshal(jsonValue, event); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", header.EventType, err)
+	}
+	return event, nil
+}
+
+// UnknownEvent is an event with a type that isn't registered. The original JSON is kept so that the event can be
+// passed on unchanged
+type UnknownEvent struct {
+	// EventCommon has the event's ID (uuid.Nil if it didn't have one)
+	EventCommon
+	Raw json.RawMessage
+}
+
+var _ Event = UnknownEvent{}
+
+func newUnknownEvent(eventType string, jsonValue []byte) *UnknownEvent {
+	var header struct {
+		ID uuid.UUID `json:"id"`
+	}
+	// the ID is optional as the format of the event is unknown
+	_ = json.Unmarshal(jsonValue, &header)
+	raw := make(json.RawMessage, len(jsonValue))
+	copy(raw, jsonValue)
+	return &UnknownEvent{
+		EventCommon: EventCommon{
+			EventType: eventType,
+			ID:        header.ID,
+		},
+		Raw: raw,
+	}
+}
+
+// MarshalJSON returns the original JSON so that the event is unchanged when it is converted to JSON
+func (e UnknownEvent) MarshalJSON() ([]byte, error) {
+	return e.Raw, nil
+}
+
+func (e UnknownEvent) GetProperties() map[string]string {
+	return map[string]string{
+		"type": e.EventType,
+		"id":   e.ID.String(),
+	}
+}
+
+// Handler handles a decoded event
+type Handler func(event Event) error
+
+// On returns a Handler for events of type T (e.g. *SnoozeEvent), so that handlers don't need to check the type
+func On[T Event](handler func(event T) error) Handler {
+	return func(event Event) error {
+		typedEvent, ok := event.(T)
+		if !ok {
+			return fmt.Errorf("unexpected %T for %s", event, event.GetType())
+		}
+		return handler(typedEvent)
+	}
+}
+
+// Handlers dispatches events to a Handler by event type
+type Handlers map[string]Handler
+
+// Dispatch calls the handler for the event's type, or unhandled (if not nil) if there isn't one,
+// e.g. for an *UnknownEvent
+func (h Handlers) Dispatch(event Event, unhandled Handler) error {
+	if handler, ok := h[event.GetType()]; ok {
+		return handler(event)
+	}
+	if unhandled != nil {
+		return unhandled(event)
+	}
+	return nil
+}
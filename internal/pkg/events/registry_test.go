@@ -0,0 +1,186 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gobuffalo/uuid"
+)
+
+// sampleEvents returns an event with every field set for each event type in this package
+func sampleEvents() map[string]Event {
+	eventTime := time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC)
+	buttonEvent := NewButtonEvent(ButtonLongPress, "gpio")
+	buttonEvent.Door = "front"
+	buttonEvent.HeldFor = 2 * time.Second
+	buttonEvent.Time = eventTime
+	buttonEvent.Hops = []Hop{{Name: HopBroadcast, Time: eventTime.Add(time.Millisecond)}}
+	return map[string]Event{
+		EventTypeButton:         buttonEvent,
+		EventTypeSnooze:         NewSnoozeEvent(eventTime),
+		EventTypeUnSnooze:       NewUnSnoozeEvent(),
+		EventTypeStopProcessing: NewStopProcessingEvent(),
+		EventTypeChimeStatus:    NewChimeStatusEvent("kitchen", true),
+		EventTypeMissedRings: NewMissedRingsEvent(eventTime, 3, []MissedRing{
+			{ID: buttonEvent.ID, Time: eventTime, Door: "front", Source: "gpio"},
+		}),
+	}
+}
+
+func TestDecodeRoundTrip(t *testing.T) {
+	samples := sampleEvents()
+	for _, eventType := range RegisteredTypes() {
+		t.Run(eventType, func(t *testing.T) {
+			event, ok := samples[eventType]
+			if !ok {
+				t.Fatalf("no sample event for %s - add one to sampleEvents", eventType)
+			}
+			jsonValue, err := ToJSON(event)
+			if err != nil {
+				t.Fatalf("ToJSON returned an error: %v", err)
+			}
+
+			// the common fields are at the top level of the JSON
+			var fields map[string]interface{}
+			if err := json.Unmarshal([]byte(jsonValue), &fields); err != nil {
+				t.Fatal(err)
+			}
+			if fields["eventType"] != eventType || fields["id"] != event.GetID().String() {
+				t.Errorf("expected eventType %q and id %q, got %s", eventType, event.GetID(), jsonValue)
+			}
+
+			decoded, err := Decode([]byte(jsonValue))
+			if err != nil {
+				t.Fatalf("Decode returned an error: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, event) {
+				t.Errorf("expected %#v, got %#v", event, decoded)
+			}
+			if decoded.GetType() != eventType || decoded.GetID() != event.GetID() {
+				t.Errorf("expected type %s and ID %s, got %s and %s", eventType, event.GetID(), decoded.GetType(), decoded.GetID())
+			}
+		})
+	}
+}
+
+func TestDecodeUnknownEventKeepsRawJSON(t *testing.T) {
+	jsonValue := `{"eventType":"doorbell-battery-event","id":"6ba7b810-9dad-11d1-80b4-00c04fd430c8","level":42,"nested":{"b":[1,2]}}`
+	event, err := Decode([]byte(jsonValue))
+	if err != nil {
+		t.Fatalf("Decode returned an error: %v", err)
+	}
+	unknown, ok := event.(*UnknownEvent)
+	if !ok {
+		t.Fatalf("expected an *UnknownEvent, got %T", event)
+	}
+	if unknown.GetType() != "doorbell-battery-event" || unknown.GetID().String() != "6ba7b810-9dad-11d1-80b4-00c04fd430c8" {
+		t.Errorf("unexpected type %q and ID %s", unknown.GetType(), unknown.GetID())
+	}
+	if string(unknown.Raw) != jsonValue {
+		t.Errorf("expected the raw JSON %s, got %s", jsonValue, unknown.Raw)
+	}
+	reencoded, err := ToJSON(unknown)
+	if err != nil {
+		t.Fatalf("ToJSON returned an error: %v", err)
+	}
+	if reencoded != jsonValue {
+		t.Errorf("expected %s, got %s", jsonValue, reencoded)
+	}
+
+	// the ID is optional
+	event, err = Decode([]byte(`{"eventType":"doorbell-battery-event","id":42}`))
+	if err != nil {
+		t.Fatalf("Decode returned an error for an unknown event without a valid ID: %v", err)
+	}
+	if event.GetID() != uuid.Nil {
+		t.Errorf("expected a nil ID, got %s", event.GetID())
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, jsonValue := range []string{
+		``,
+		`[]`,
+		`{}`,
+		`{"eventType":""}`,
+		`{"eventType":"button-event","buttonEventType":"pressed"}`,
+		`{"eventType":"snooze-event","id":"not-a-uuid"}`,
+	} {
+		if event, err := Decode([]byte(jsonValue)); err == nil {
+			t.Errorf("%s: expected an error, got %#v", jsonValue, event)
+		}
+	}
+}
+
+func TestHandlersDispatch(t *testing.T) {
+	var snoozed *SnoozeEvent
+	var unhandled Event
+	handlers := Handlers{
+		EventTypeSnooze: On(func(event *SnoozeEvent) error {
+			snoozed = event
+			return nil
+		}),
+		EventTypeUnSnooze: On(func(event *UnSnoozeEvent) error {
+			return errors.New("unsnooze failed")
+		}),
+	}
+	recordUnhandled := func(event Event) error {
+		unhandled = event
+		return nil
+	}
+
+	snoozeEvent := NewSnoozeEvent(time.Now())
+	if err := handlers.Dispatch(snoozeEvent, recordUnhandled); err != nil || snoozed != snoozeEvent {
+		t.Errorf("expected the snooze handler to be called, got %v", err)
+	}
+	if err := handlers.Dispatch(NewUnSnoozeEvent(), recordUnhandled); err == nil || err.Error() != "unsnooze failed" {
+		t.Errorf("expected the unsnooze handler's error, got %v", err)
+	}
+	stopEvent := NewStopProcessingEvent()
+	if err := handlers.Dispatch(stopEvent, recordUnhandled); err != nil || unhandled != stopEvent {
+		t.Errorf("expected the unhandled handler to be called, got %v", err)
+	}
+	if err := handlers.Dispatch(stopEvent, nil); err != nil {
+		t.Errorf("expected no error without an unhandled handler, got %v", err)
+	}
+	// a handler registered for the wrong type returns an error rather than panicking
+	mismatched := Handlers{EventTypeSnooze: On(func(event *UnSnoozeEvent) error { return nil })}
+	if err := mismatched.Dispatch(snoozeEvent, nil); err == nil {
+		t.Error("expected an error for a mismatched handler")
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, event := range sampleEvents() {
+		jsonValue, err := ToJSON(event)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add([]byte(jsonValue))
+	}
+	f.Add([]byte(`{"eventType":"doorbell-battery-event","level":42}`))
+	f.Fuzz(func(t *testing.T, jsonValue []byte) {
+		event, err := Decode(jsonValue)
+		if err != nil {
+			return
+		}
+		if event.GetType() == "" {
+			t.Errorf("decoded an event without a type from %q", jsonValue)
+		}
+		// a decoded event can always be converted back to JSON, which decodes to the same type and ID
+		reencoded, err := ToJSON(event)
+		if err != nil {
+			t.Fatalf("ToJSON returned an error for %q: %v", jsonValue, err)
+		}
+		decoded, err := Decode([]byte(reencoded))
+		if err != nil {
+			t.Fatalf("failed to decode %s (from %q): %v", reencoded, jsonValue, err)
+		}
+		if decoded.GetType() != event.GetType() || decoded.GetID() != event.GetID() {
+			t.Errorf("round trip changed the type or ID: %s then %s", jsonValue, reencoded)
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gobuffalo/uuid"
+)
+
+// MessageTypeSnoozeRequest is the messageType for a SnoozeRequest sent from a chime to the bellpush
+const MessageTypeSnoozeRequest = "snooze-request"
+
+// SnoozeRequest is sent by a chime to ask the bellpush to snooze it (e.g. from a button on the chime).
+// The bellpush holds the snooze state, so it applies the request and sends the chime a snooze or unsnooze event
+type SnoozeRequest struct {
+	MessageType string    `json:"messageType"`
+	ID          uuid.UUID `json:"id"`
+	// Duration is how long to snooze for. Zero cancels the snooze
+	Duration time.Duration `json:"duration"`
+}
+
+// NewSnoozeRequest creates a request to snooze for duration (or to cancel the snooze if duration is zero)
+func NewSnoozeRequest(duration time.Duration) *SnoozeRequest {
+	return &SnoozeRequest{
+		MessageType: MessageTypeSnoozeRequest,
+		ID:          uuid.Must(uuid.NewV4()),
+		Duration:    duration,
+	}
+}
+
+// IsCancel returns true if the request cancels the snooze
+func (r *SnoozeRequest) IsCancel() bool {
+	return r.Duration == 0
+}
+
+// ParseSnoozeRequestJSON parses the JSON representation of a SnoozeRequest
+func ParseSnoozeRequestJSON(jsonValue []byte) (*SnoozeRequest, error) {
+	var request SnoozeRequest
+	err := json.Unmarshal(jsonValue, &request)
+	if err != nil {
+		return nil, err
+	}
+	if request.MessageType != MessageTypeSnoozeRequest {
+		return nil, fmt.Errorf("unexpected messageType %q", request.MessageType)
+	}
+	if request.Duration < 0 {
+		return nil, fmt.Errorf("invalid snooze duration %v", request.Duration)
+	}
+	return &request, nil
+}
@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+func encodeLogfmt(entry Entry) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("time=")
+	buf.WriteString(entry.Time.Format(time.RFC3339Nano))
+	buf.WriteString(" level=")
+	buf.WriteString(entry.Level.String())
+	buf.WriteString(" msg=")
+	writeLogfmtValue(&buf, entry.Message)
+	forEachField(entry.Fields, func(key string, value any) {
+		buf.WriteByte(' ')
+		buf.WriteString(sanitizeLogfmtKey(key))
+		buf.WriteByte('=')
+		writeLogfmtValue(&buf, formatValue(value))
+	})
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+func writeLogfmtValue(buf *bytes.Buffer, value string) {
+	if value == "" || strings.IndexFunc(value, needsQuoting) >= 0 {
+		buf.WriteString(strconv.Quote(value))
+		return
+	}
+	buf.WriteString(value)
+}
+
+func needsQuoting(r rune) bool {
+	return r <= ' ' || r == '=' || r == '"' || r == unicode.ReplacementChar || !unicode.IsPrint(r)
+}
+
+func sanitizeLogfmtKey(key string) string {
+	if key == "" {
+		return "!EMPTYKEY"
+	}
+	return strings.Map(func(r rune) rune {
+		if needsQuoting(r) {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+func encodeJSON(entry Entry) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`{"time":`)
+	writeJSONValue(&buf, entry.Time.Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeJSONValue(&buf, entry.Level.String())
+	buf.WriteString(`,"msg":`)
+	writeJSONValue(&buf, entry.Message)
+	forEachField(entry.Fields, func(key string, value any) {
+		buf.WriteByte(',')
+		writeJSONValue(&buf, key)
+		buf.WriteByte(':')
+		switch v := value.(type) {
+		case bool, int, int32, int64, uint, uint32, uint64, float32, float64:
+			writeJSONValue(&buf, v)
+		default:
+			writeJSONValue(&buf, formatValue(value))
+		}
+	})
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+func writeJSONValue(buf *bytes.Buffer, value any) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		b, _ = json.Marshal(err.Error())
+	}
+	buf.Write(b)
+}
@@ -0,0 +1,30 @@
+package logging
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// LevelHandler serves the current log level on GET and changes it on PUT or POST
+// (level passed as the `level` query string parameter, e.g. /log/level?level=debug)
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			level, err := ParseLevel(r.URL.Query().Get("level"))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = fmt.Fprintln(w, err)
+				return
+			}
+			previous := GetLevel()
+			SetLevel(level)
+			Default().Info("Log level changed", "from", previous, "to", level)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		_, _ = fmt.Fprintln(w, GetLevel())
+	})
+}
@@ -0,0 +1,293 @@
+// Package logging provides the leveled, structured logger shared by the bellpush and chime.
+//
+// Entries are written as logfmt (the default) or JSON lines. Key/value pairs are passed
+// alongside the message, e.g.
+//
+//	logger.Info("Client connected", "chime", name)
+//
+// The level is held globally so that it can be changed while the process is running (see LevelHandler).
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/stuartleeks/pi-bell/internal/pkg/configfile"
+)
+
+// CorrelationIDKey is the field used to tie together the log entries for a single ring
+// across the bellpush and chimes. Its value is the ID of the originating button event.
+const CorrelationIDKey = "correlationId"
+
+// Level is the severity of a log entry
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int32(l))
+}
+
+// ParseLevel parses a level name (debug, info, warn, error)
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug, nil
+	case "info", "information":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("invalid log level %q (expected debug, info, warn or error)", s)
+}
+
+// Format is the output encoding for log entries
+type Format int
+
+const (
+	FormatLogfmt Format = iota
+	FormatJSON
+)
+
+// ParseFormat parses a format name (logfmt, json)
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "logfmt", "text":
+		return FormatLogfmt, nil
+	case "json":
+		return FormatJSON, nil
+	}
+	return FormatLogfmt, fmt.Errorf("invalid log format %q (expected logfmt or json)", s)
+}
+
+// Entry is a single log entry as passed to a Hook
+type Entry struct {
+	Time    time.Time
+	Level   Level
+	Message string
+	// Fields holds the key/value pairs in the order they were added
+	Fields []any
+}
+
+// Hook receives every entry that passes the level filter, e.g. to forward it to telemetry
+type Hook func(entry Entry)
+
+var (
+	currentLevel atomic.Int32
+
+	outputLock sync.Mutex
+	output     io.Writer = os.Stderr
+	format               = FormatLogfmt
+	hook       Hook
+)
+
+func init() {
+	currentLevel.Store(int32(LevelInfo))
+}
+
+// Config holds the logging settings from the config file
+type Config struct {
+	Level  string `yaml:"level" env:"LOG_LEVEL"`
+	Format string `yaml:"format" env:"LOG_FORMAT"`
+}
+
+// Validate checks the settings, adding any problems to errs
+func (c Config) Validate(errs *configfile.Errors, path string) {
+	if _, err := ParseLevel(c.Level); c.Level != "" && err != nil {
+		errs.Add(configfile.Join(path, "level"), "%v", err)
+	}
+	if _, err := ParseFormat(c.Format); err != nil {
+		errs.Add(configfile.Join(path, "format"), "%v", err)
+	}
+}
+
+// Apply sets the level and format. This is safe to call while running (e.g. on config reload)
+func (c Config) Apply() error {
+	level := LevelInfo
+	if c.Level != "" {
+		var err error
+		if level, err = ParseLevel(c.Level); err != nil {
+			return err
+		}
+	}
+	format, err := ParseFormat(c.Format)
+	if err != nil {
+		return err
+	}
+	SetLevel(level)
+	SetFormat(format)
+	return nil
+}
+
+// SetLevel sets the minimum level that is written. Safe to call at any time.
+func SetLevel(level Level) {
+	currentLevel.Store(int32(level))
+}
+
+// GetLevel returns the current minimum level
+func GetLevel() Level {
+	return Level(currentLevel.Load())
+}
+
+// SetFormat sets the output encoding
+func SetFormat(f Format) {
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	format = f
+}
+
+// SetOutput sets the writer that entries are written to (defaults to stderr)
+func SetOutput(w io.Writer) {
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	output = w
+}
+
+// SetHook sets a function to be called for every entry that is written
+func SetHook(h Hook) {
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	hook = h
+}
+
+// Logger writes entries with a fixed set of fields attached
+type Logger struct {
+	fields []any
+}
+
+// New returns a Logger with the given key/value pairs attached to every entry
+func New(keyValues ...any) *Logger {
+	return &Logger{fields: keyValues}
+}
+
+// With returns a copy of the Logger with additional key/value pairs attached
+func (l *Logger) With(keyValues ...any) *Logger {
+	fields := make([]any, 0, len(l.fields)+len(keyValues))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyValues...)
+	return &Logger{fields: fields}
+}
+
+// WithCorrelationID returns a copy of the Logger tagged with the given correlation ID
+func (l *Logger) WithCorrelationID(id fmt.Stringer) *Logger {
+	return l.With(CorrelationIDKey, id.String())
+}
+
+// Enabled reports whether entries at the given level are currently written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= GetLevel()
+}
+
+func (l *Logger) Debug(message string, keyValues ...any) {
+	l.log(LevelDebug, message, keyValues)
+}
+func (l *Logger) Info(message string, keyValues ...any) {
+	l.log(LevelInfo, message, keyValues)
+}
+func (l *Logger) Warn(message string, keyValues ...any) {
+	l.log(LevelWarn, message, keyValues)
+}
+func (l *Logger) Error(message string, keyValues ...any) {
+	l.log(LevelError, message, keyValues)
+}
+
+func (l *Logger) log(level Level, message string, keyValues []any) {
+	if !l.Enabled(level) {
+		return
+	}
+	fields := make([]any, 0, len(l.fields)+len(keyValues))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyValues...)
+	entry := Entry{
+		Time:    time.Now(),
+		Level:   level,
+		Message: message,
+		Fields:  fields,
+	}
+
+	outputLock.Lock()
+	var line []byte
+	if format == FormatJSON {
+		line = encodeJSON(entry)
+	} else {
+		line = encodeLogfmt(entry)
+	}
+	_, _ = output.Write(line)
+	h := hook
+	outputLock.Unlock()
+
+	if h != nil {
+		h(entry)
+	}
+}
+
+// FieldMap returns the entry's fields as a map of strings (e.g. for telemetry properties)
+func (e Entry) FieldMap() map[string]string {
+	result := make(map[string]string, len(e.Fields)/2)
+	forEachField(e.Fields, func(key string, value any) {
+		result[key] = formatValue(value)
+	})
+	return result
+}
+
+// forEachField walks key/value pairs. A trailing key with no value is reported under "!BADKEY".
+func forEachField(fields []any, f func(key string, value any)) {
+	for i := 0; i < len(fields); i += 2 {
+		if i+1 >= len(fields) {
+			f("!BADKEY", fields[i])
+			return
+		}
+		key, ok := fields[i].(string)
+		if !ok {
+			key = fmt.Sprint(fields[i])
+		}
+		f(key, fields[i+1])
+	}
+}
+
+func formatValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(value)
+}
+
+var defaultLogger = New()
+
+// Default returns the Logger with no fields attached
+func Default() *Logger {
+	return defaultLogger
+}
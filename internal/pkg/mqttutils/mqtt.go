@@ -0,0 +1,131 @@
+package mqttutils
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stuartleeks/pi-bell/internal/pkg/configfile"
+)
+
+const (
+	PayloadOnline  = "online"
+	PayloadOffline = "offline"
+)
+
+// Ack is published by MQTT chimes when they have handled an event
+type Ack struct {
+	ID        string    `json:"id"`
+	EventType string    `json:"eventType"`
+	ChimeName string    `json:"chimeName"`
+	Time      time.Time `json:"time"`
+}
+
+// ChimeInfo is published (retained) by MQTT chimes to their info topic. The chime's topics use the sanitized
+// name, so this carries the name that the chime is known by
+type ChimeInfo struct {
+	Name string `json:"name"`
+}
+
+// DefaultTopicPrefix is the default root for all pi-bell topics
+const DefaultTopicPrefix = "pibell"
+
+// Config holds the settings for connecting to an MQTT broker
+type Config struct {
+	// Broker is the broker URL, e.g. tcp://mqtt.local:1883. MQTT is disabled if this is empty
+	Broker   string `yaml:"broker" env:"MQTT_BROKER"`
+	Username string `yaml:"username" env:"MQTT_USERNAME"`
+	Password string `yaml:"password" env:"MQTT_PASSWORD"`
+	// TopicPrefix is the root for all pi-bell topics (defaults to DefaultTopicPrefix)
+	TopicPrefix string `yaml:"topicPrefix" env:"MQTT_TOPIC_PREFIX"`
+}
+
+// Enabled returns true if a broker has been configured
+func (c Config) Enabled() bool {
+	return c.Broker != ""
+}
+
+// Validate checks the settings, adding any problems to errs
+func (c Config) Validate(errs *configfile.Errors, path string) {
+	if !c.Enabled() {
+		return
+	}
+	if u, err := url.Parse(c.Broker); err != nil || u.Scheme == "" || u.Host == "" {
+		errs.Add(configfile.Join(path, "broker"), "invalid broker URL %q: expected e.g. tcp://mqtt.local:1883", c.Broker)
+	}
+	if c.TopicPrefix == "" || strings.ContainsAny(c.TopicPrefix, "+#") {
+		errs.Add(configfile.Join(path, "topicPrefix"), "must be set and can't contain MQTT wildcards")
+	}
+}
+
+// NewClientOptions returns client options for the config with a last will
+// that marks availabilityTopic as offline
+func (c Config) NewClientOptions(clientID string, availabilityTopic string) *mqtt.ClientOptions {
+	options := mqtt.NewClientOptions()
+	options.AddBroker(c.Broker)
+	options.SetClientID(clientID)
+	options.SetUsername(c.Username)
+	options.SetPassword(c.Password)
+	options.SetAutoReconnect(true)
+	options.SetConnectRetry(true)
+	options.SetConnectRetryInterval(5 * time.Second)
+	options.SetMaxReconnectInterval(1 * time.Minute)
+	options.SetWill(availabilityTopic, PayloadOffline, 1, true)
+	return options
+}
+
+// BellPushTopic returns the topic for the specified path under a bellpush node.
+// Pass "+" as nodeID to subscribe to all bellpushes
+func (c Config) BellPushTopic(nodeID string, path string) string {
+	return fmt.Sprintf("%s/%s/%s", c.TopicPrefix, nodeID, path)
+}
+
+// NodeID returns the bellpush node ID from a topic returned by BellPushTopic or ChimeTopic
+// (e.g. for a message received on a wildcard subscription), or "" if the topic isn't under TopicPrefix
+func (c Config) NodeID(topic string) string {
+	prefix := c.TopicPrefix + "/"
+	if !strings.HasPrefix(topic, prefix) {
+		return ""
+	}
+	nodeID, _, _ := strings.Cut(strings.TrimPrefix(topic, prefix), "/")
+	return nodeID
+}
+
+// ChimeTopic returns the topic for the specified path under a chime on a bellpush node
+func (c Config) ChimeTopic(nodeID string, chimeName string, path string) string {
+	return fmt.Sprintf("%s/%s/chime/%s/%s", c.TopicPrefix, nodeID, SanitizeID(chimeName), path)
+}
+
+// ChimePresenceTopic returns the topic for the specified path for a chime that uses MQTT as its transport.
+// These topics aren't tied to a bellpush node so that a chime can be fed by multiple bellpushes
+func (c Config) ChimePresenceTopic(chimeName string, path string) string {
+	return fmt.Sprintf("%s/chimes/%s/%s", c.TopicPrefix, SanitizeID(chimeName), path)
+}
+
+// AllChimePresenceTopic returns a wildcard topic matching the specified path for all MQTT chimes
+func (c Config) AllChimePresenceTopic(path string) string {
+	return fmt.Sprintf("%s/chimes/+/%s", c.TopicPrefix, path)
+}
+
+// AllChimesTopic returns a wildcard topic matching the specified path for all chimes on a bellpush node
+func (c Config) AllChimesTopic(nodeID string, path string) string {
+	return fmt.Sprintf("%s/%s/chime/+/%s", c.TopicPrefix, nodeID, path)
+}
+
+var invalidIDChars = regexp.MustCompile("[^a-zA-Z0-9_-]")
+
+// SanitizeID replaces characters that aren't valid in topic levels or Home Assistant object IDs
+func SanitizeID(value string) string {
+	return invalidIDChars.ReplaceAllString(value, "_")
+}
+
+// Wait waits for a token to complete with a timeout
+func Wait(token mqtt.Token, timeout time.Duration) error {
+	if !token.WaitTimeout(timeout) {
+		return fmt.Errorf("timed out waiting for mqtt operation")
+	}
+	return token.Error()
+}
@@ -7,6 +7,14 @@ const (
 	GPIO17 string = "11"
 	//GPIO18 represents GPIO pin 18
 	GPIO18 string = "12"
+	//GPIO22 represents GPIO pin 22
+	GPIO22 string = "15"
+	//GPIO23 represents GPIO pin 23
+	GPIO23 string = "16"
+	//GPIO24 represents GPIO pin 24
+	GPIO24 string = "18"
+	//GPIO27 represents GPIO pin 27
+	GPIO27 string = "13"
 
 	//TODO - add other pins!
 )
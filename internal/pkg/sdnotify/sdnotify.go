@@ -0,0 +1,71 @@
+// Package sdnotify implements the systemd sd_notify protocol so that the bellpush and chime can
+// report readiness and status to systemd and ping the service watchdog (see the Type=notify units in scripts).
+//
+// When the process isn't started by systemd (NOTIFY_SOCKET isn't set) the functions do nothing.
+// Failures to notify are logged rather than returned as they shouldn't stop the bell working
+package sdnotify
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/stuartleeks/pi-bell/internal/pkg/logging"
+)
+
+var logger = logging.New("component", "sdnotify")
+
+// Notify sends state (e.g. "READY=1") to systemd
+func Notify(state string) {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return
+	}
+	// a leading @ is an abstract socket, which is handled by the net package
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		logger.Warn("Failed to connect to systemd notify socket", "err", err)
+		return
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		logger.Warn("Failed to notify systemd", "state", state, "err", err)
+	}
+}
+
+// Ready tells systemd that startup has finished
+func Ready() {
+	Notify("READY=1")
+}
+
+// Stopping tells systemd that the process is shutting down
+func Stopping() {
+	Notify("STOPPING=1")
+}
+
+// Status sets the status shown by `systemctl status`
+func Status(format string, a ...any) {
+	Notify("STATUS=" + fmt.Sprintf(format, a...))
+}
+
+// Watchdog pings the systemd watchdog. This should be called every WatchdogInterval from
+// a loop that would stop running if the process was wedged
+func Watchdog() {
+	Notify("WATCHDOG=1")
+}
+
+// WatchdogInterval returns how often Watchdog should be called (half the WatchdogSec from the unit file),
+// or 0 if the watchdog isn't enabled for this process
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		// the watchdog is for another process (e.g. a parent that started us)
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
@@ -0,0 +1,66 @@
+package telemetry
+
+import (
+	"errors"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+type appInsightsExporter struct {
+	client appinsights.TelemetryClient
+}
+
+func newAppInsightsExporter(instrumentationKey string, role string) *appInsightsExporter {
+	telemetryConfig := appinsights.NewTelemetryConfiguration(instrumentationKey)
+	telemetryConfig.MaxBatchInterval = batchInterval
+	client := appinsights.NewTelemetryClientFromConfig(telemetryConfig)
+	client.Context().Tags.Cloud().SetRole(role)
+	return &appInsightsExporter{client: client}
+}
+
+var severityLevels = map[Severity]contracts.SeverityLevel{
+	Verbose:     appinsights.Verbose,
+	Information: appinsights.Information,
+	Warning:     appinsights.Warning,
+	Error:       appinsights.Error,
+	Critical:    appinsights.Critical,
+}
+
+func (e *appInsightsExporter) export(items []item) error {
+	for _, i := range items {
+		var telemetry appinsights.Telemetry
+		switch i.kind {
+		case kindEvent:
+			eventTelemetry := appinsights.NewEventTelemetry(i.name)
+			for name, value := range i.properties {
+				eventTelemetry.Properties[name] = value
+			}
+			telemetry = eventTelemetry
+		case kindTrace:
+			traceTelemetry := appinsights.NewTraceTelemetry(i.name, severityLevels[i.severity])
+			for name, value := range i.properties {
+				traceTelemetry.Properties[name] = value
+			}
+			telemetry = traceTelemetry
+		case kindException:
+			exceptionTelemetry := appinsights.NewExceptionTelemetry(errors.New(i.name))
+			for name, value := range i.properties {
+				exceptionTelemetry.Properties[name] = value
+			}
+			telemetry = exceptionTelemetry
+		}
+		telemetry.SetTime(i.time)
+		e.client.Track(telemetry)
+	}
+	e.client.Channel().Flush()
+	return nil
+}
+
+func (e *appInsightsExporter) close() {
+	select {
+	case <-e.client.Channel().Close(5 * time.Second):
+	case <-time.After(10 * time.Second):
+	}
+}
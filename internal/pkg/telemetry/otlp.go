@@ -0,0 +1,129 @@
+package telemetry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// otlpExporter sends telemetry as OpenTelemetry log records using OTLP/HTTP with JSON encoding.
+// Events are sent with an event.name attribute - see https://opentelemetry.io/docs/specs/otlp/
+type otlpExporter struct {
+	logsURL    string
+	role       string
+	hostname   string
+	httpClient *http.Client
+}
+
+func newOtlpExporter(endpoint string, role string) *otlpExporter {
+	hostname, _ := os.Hostname()
+	return &otlpExporter{
+		logsURL:    strings.TrimSuffix(endpoint, "/") + "/v1/logs",
+		role:       role,
+		hostname:   hostname,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+type otlpLogRecord struct {
+	TimeUnixNano   string          `json:"timeUnixNano"`
+	SeverityNumber int             `json:"severityNumber"`
+	SeverityText   string          `json:"severityText"`
+	Body           otlpValue       `json:"body"`
+	Attributes     []otlpAttribute `json:"attributes,omitempty"`
+}
+
+// OpenTelemetry severity numbers - see https://opentelemetry.io/docs/specs/otel/logs/data-model/#severity-fields
+var otlpSeverities = map[Severity]struct {
+	number int
+	text   string
+}{
+	Verbose:     {5, "DEBUG"},
+	Information: {9, "INFO"},
+	Warning:     {13, "WARN"},
+	Error:       {17, "ERROR"},
+	Critical:    {21, "FATAL"},
+}
+
+func otlpAttributes(values map[string]string) []otlpAttribute {
+	attributes := make([]otlpAttribute, 0, len(values))
+	for key, value := range values {
+		attributes = append(attributes, otlpAttribute{Key: key, Value: otlpValue{StringValue: value}})
+	}
+	return attributes
+}
+
+func (e *otlpExporter) export(items []item) error {
+	logRecords := make([]otlpLogRecord, 0, len(items))
+	for _, i := range items {
+		attributes := map[string]string{}
+		for name, value := range i.properties {
+			attributes[name] = value
+		}
+		switch i.kind {
+		case kindEvent:
+			attributes["event.name"] = i.name
+		case kindException:
+			attributes["exception.message"] = i.name
+			attributes["exception.type"] = i.properties["exceptionType"]
+			delete(attributes, "exceptionType")
+		}
+		severity := otlpSeverities[i.severity]
+		logRecords = append(logRecords, otlpLogRecord{
+			TimeUnixNano:   strconv.FormatInt(i.time.UnixNano(), 10),
+			SeverityNumber: severity.number,
+			SeverityText:   severity.text,
+			Body:           otlpValue{StringValue: i.name},
+			Attributes:     otlpAttributes(attributes),
+		})
+	}
+
+	payload := map[string]interface{}{
+		"resourceLogs": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]string{
+						"service.name": e.role,
+						"host.name":    e.hostname,
+					}),
+				},
+				"scopeLogs": []interface{}{
+					map[string]interface{}{
+						"scope":      map[string]string{"name": "github.com/stuartleeks/pi-bell"},
+						"logRecords": logRecords,
+					},
+				},
+			},
+		},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	response, err := e.httpClient.Post(e.logsURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error sending to %s: %w", e.logsURL, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		responseBody, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("unexpected status %d from %s: %s", response.StatusCode, e.logsURL, strings.TrimSpace(string(responseBody)))
+	}
+	return nil
+}
+
+func (e *otlpExporter) close() {}
@@ -0,0 +1,240 @@
+package telemetry
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/stuartleeks/pi-bell/internal/pkg/configfile"
+	"github.com/stuartleeks/pi-bell/internal/pkg/logging"
+)
+
+var logger = logging.New("component", "telemetry")
+
+// Severity indicates the severity of a trace message
+type Severity int
+
+const (
+	Verbose Severity = iota
+	Information
+	Warning
+	Error
+	Critical
+)
+
+// Client is used to send telemetry. Implementations must not block the caller
+// so that a slow telemetry endpoint can never delay handling a ring
+type Client interface {
+	TrackEvent(name string, properties map[string]string)
+	TrackTrace(message string, severity Severity, properties map[string]string)
+	TrackException(err error)
+	// Close sends any pending telemetry, waiting up to timeout
+	Close(timeout time.Duration)
+}
+
+type itemKind int
+
+const (
+	kindEvent itemKind = iota
+	kindTrace
+	kindException
+)
+
+// item is a single piece of telemetry queued for export
+type item struct {
+	kind       itemKind
+	time       time.Time
+	name       string
+	severity   Severity
+	properties map[string]string
+}
+
+// exporter sends batches of telemetry to a backend
+type exporter interface {
+	export(items []item) error
+	close()
+}
+
+const (
+	queueSize     = 1000
+	maxBatchSize  = 100
+	batchInterval = 2 * time.Second
+)
+
+// batchingClient queues telemetry and exports it in batches from a background goroutine.
+// If the queue is full then telemetry is dropped rather than blocking the caller
+type batchingClient struct {
+	exporter exporter
+	items    chan item
+	done     chan bool
+	dropped  int64
+
+	closedLock sync.RWMutex
+	closed     bool
+}
+
+var _ Client = &batchingClient{}
+
+func newBatchingClient(exporter exporter) *batchingClient {
+	client := &batchingClient{
+		exporter: exporter,
+		items:    make(chan item, queueSize),
+		done:     make(chan bool),
+	}
+	go client.run()
+	return client
+}
+
+func (c *batchingClient) enqueue(i item) {
+	i.time = time.Now()
+	c.closedLock.RLock()
+	defer c.closedLock.RUnlock()
+	if c.closed {
+		return
+	}
+	select {
+	case c.items <- i:
+	default:
+		atomic.AddInt64(&c.dropped, 1)
+	}
+}
+
+func (c *batchingClient) TrackEvent(name string, properties map[string]string) {
+	c.enqueue(item{kind: kindEvent, name: name, severity: Information, properties: properties})
+}
+func (c *batchingClient) TrackTrace(message string, severity Severity, properties map[string]string) {
+	c.enqueue(item{kind: kindTrace, name: message, severity: severity, properties: properties})
+}
+func (c *batchingClient) TrackException(err error) {
+	if err == nil {
+		return
+	}
+	c.enqueue(item{kind: kindException, name: err.Error(), severity: Error, properties: map[string]string{
+		"exceptionType": fmt.Sprintf("%T", err),
+	}})
+}
+
+func (c *batchingClient) Close(timeout time.Duration) {
+	c.closedLock.Lock()
+	if c.closed {
+		c.closedLock.Unlock()
+		return
+	}
+	c.closed = true
+	close(c.items)
+	c.closedLock.Unlock()
+
+	select {
+	case <-c.done:
+	case <-time.After(timeout):
+		logger.Warn("Timed out waiting for telemetry to be sent", "timeout", timeout)
+	}
+	c.exporter.close()
+}
+
+func (c *batchingClient) run() {
+	defer close(c.done)
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+
+	batch := make([]item, 0, maxBatchSize)
+	send := func() {
+		if dropped := atomic.SwapInt64(&c.dropped, 0); dropped > 0 {
+			logger.Warn("Dropped telemetry items as the queue was full", "dropped", dropped)
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := c.exporter.export(batch); err != nil {
+			logger.Warn("Error sending telemetry", "err", err)
+		}
+		batch = make([]item, 0, maxBatchSize)
+	}
+
+	for {
+		select {
+		case i, ok := <-c.items:
+			if !ok {
+				send()
+				return
+			}
+			batch = append(batch, i)
+			if len(batch) >= maxBatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		}
+	}
+}
+
+type noopClient struct{}
+
+var _ Client = noopClient{}
+
+// NewNoopClient returns a client that discards all telemetry
+func NewNoopClient() Client {
+	return noopClient{}
+}
+
+func (noopClient) TrackEvent(string, map[string]string)           {}
+func (noopClient) TrackTrace(string, Severity, map[string]string) {}
+func (noopClient) TrackException(error)                           {}
+func (noopClient) Close(time.Duration)                            {}
+
+// Config holds the telemetry settings from the config file
+type Config struct {
+	// Backend is appinsights, otlp or none. If empty then Application Insights is used if
+	// AppInsightsInstrumentationKey is set
+	Backend                       string `yaml:"backend" env:"TELEMETRY_BACKEND"`
+	AppInsightsInstrumentationKey string `yaml:"appInsightsInstrumentationKey" env:"APPINSIGHTS_INSTRUMENTATIONKEY"`
+	// OTLPEndpoint is the OTLP/HTTP endpoint (default http://localhost:4318)
+	OTLPEndpoint string `yaml:"otlpEndpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+}
+
+func (c Config) backend() string {
+	backend := strings.ToLower(c.Backend)
+	if backend == "" {
+		backend = "none"
+		if c.AppInsightsInstrumentationKey != "" {
+			backend = "appinsights"
+		}
+	}
+	return backend
+}
+
+// Validate checks the settings, adding any problems to errs
+func (c Config) Validate(errs *configfile.Errors, path string) {
+	switch c.backend() {
+	case "appinsights":
+		if c.AppInsightsInstrumentationKey == "" {
+			errs.Add(configfile.Join(path, "appInsightsInstrumentationKey"), "must be set when the backend is appinsights")
+		}
+	case "otlp", "none":
+	default:
+		errs.Add(configfile.Join(path, "backend"), "invalid backend %q: expected appinsights, otlp or none", c.Backend)
+	}
+}
+
+// NewClient creates a client using the backend selected by the config:
+//   - "appinsights" uses Application Insights with AppInsightsInstrumentationKey
+//   - "otlp" sends OpenTelemetry logs to OTLPEndpoint (default http://localhost:4318)
+//   - "none" discards telemetry
+func NewClient(config Config, role string) (Client, error) {
+	switch backend := config.backend(); backend {
+	case "appinsights":
+		return newBatchingClient(newAppInsightsExporter(config.AppInsightsInstrumentationKey, role)), nil
+	case "otlp":
+		endpoint := config.OTLPEndpoint
+		if endpoint == "" {
+			endpoint = "http://localhost:4318"
+		}
+		return newBatchingClient(newOtlpExporter(endpoint, role)), nil
+	case "none":
+		return NewNoopClient(), nil
+	default:
+		return nil, fmt.Errorf("invalid telemetry backend %q: expected appinsights, otlp or none", backend)
+	}
+}
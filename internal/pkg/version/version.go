@@ -0,0 +1,5 @@
+// Package version holds the build version of the bellpush and chime
+package version
+
+// Version is set at build time, e.g. go build -ldflags "-X github.com/stuartleeks/pi-bell/internal/pkg/version.Version=v0.3.0"
+var Version = "dev"
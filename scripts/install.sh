@@ -28,3 +28,7 @@ tar -xzvf /tmp/pi-bell.tar.gz -C "$INSTALL_FOLDER"
 
 echo "Add $INSTALL_FOLDER to your PATH"
 
+
+if ! command -v aplay > /dev/null || ! command -v oggdec > /dev/null; then
+    echo "To play sounds on the chime, install aplay and oggdec (for OGG files): sudo apt install alsa-utils vorbis-tools"
+fi